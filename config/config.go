@@ -16,9 +16,12 @@ limitations under the License.
 package config
 
 import (
+	"bytes"
+	"io/ioutil"
 	"os"
 	"strings"
 
+	"github.com/kpango/glg"
 	yaml "gopkg.in/yaml.v2"
 )
 
@@ -39,21 +42,51 @@ type Config struct {
 	// Role represent the configuration to generate role token from athenz server.
 	Role Role `yaml:"roletoken"`
 
+	// Access represent the configuration to generate access token from athenz server.
+	Access Access `yaml:"accesstoken"`
+
+	// ServiceCert represent the configuration to get the service certificate from athenz server.
+	ServiceCert ServiceCert `yaml:"service_cert"`
+
+	// RoleCert represent the configuration to get the role certificate from athenz server.
+	RoleCert RoleCert `yaml:"role_cert"`
+
 	// Proxy represent the configuration of the reverse proxy server to connect to athenz to get N-token and role token.
 	Proxy Proxy `yaml:"proxy"`
+
+	// Policy represent the authorization policy of the clients to request the tokens.
+	Policy Policy `yaml:"policy"`
+
+	// unknownFields represent the YAML keys and overrides which are not defined in Config, and reported by Validate.
+	unknownFields []string
 }
 
 // Server represent client sidecar server and health check server configuration.
 type Server struct {
-	// Port represent client sidecar server port.
+	// Port represent client sidecar server port. (0 implies disabled when Socket is set)
 	Port int `yaml:"port"`
 
+	// Socket represent the Unix domain socket of client sidecar server, served in addition to or instead of Port.
+	Socket Socket `yaml:"socket"`
+
 	// HealthzPort represent health check server port for K8s.
 	HealthzPort int `yaml:"health_check_port"`
 
 	// HealthzPath represent the server path (pattern) for health check server.
 	HealthzPath string `yaml:"health_check_path"`
 
+	// LivezPath represent the server path (pattern) for liveness check on health check server.
+	LivezPath string `yaml:"livez_path"`
+
+	// ReadyzPath represent the server path (pattern) for readiness check on health check server.
+	ReadyzPath string `yaml:"readyz_path"`
+
+	// MetricsPath represent the server path (pattern) for Prometheus metrics on health check server. (empty implies disabled)
+	MetricsPath string `yaml:"metrics_path"`
+
+	// AdminPort represent the admin server port to inspect and manipulate the token cache, which listens on the loopback address only. (0 implies disabled)
+	AdminPort int `yaml:"admin_port"`
+
 	// Timeout represent the client sidecar server timeout value.
 	Timeout string `yaml:"timeout"`
 
@@ -67,6 +100,21 @@ type Server struct {
 	TLS TLS `yaml:"tls"`
 }
 
+// Socket represent the Unix domain socket configuration for client sidecar server.
+type Socket struct {
+	// Path represent the Unix domain socket file path. (empty implies disabled)
+	Path string `yaml:"path"`
+
+	// Owner represent the user name or uid of the socket file owner. (empty implies the process user)
+	Owner string `yaml:"owner"`
+
+	// Group represent the group name or gid of the socket file. (empty implies the process group)
+	Group string `yaml:"group"`
+
+	// Mode represent the permission bits of the socket file in octal, e.g. "0660". (default "0600")
+	Mode string `yaml:"mode"`
+}
+
 // TLS represent the TLS configuration for client sidecar server.
 type TLS struct {
 	// Enable represent the client sidecar server enable TLS or not.
@@ -80,6 +128,21 @@ type TLS struct {
 
 	// CAKey represent the CA certificate used to start client sidecar server.
 	CA string `yaml:"ca"`
+
+	// MinVersion represent the minimum TLS version, "1.0", "1.1", "1.2" or "1.3". (default "1.2")
+	MinVersion string `yaml:"min_version"`
+
+	// MaxVersion represent the maximum TLS version. (empty implies the latest version supported)
+	MaxVersion string `yaml:"max_version"`
+
+	// CipherSuites represent the names of the cipher suites for TLS 1.2 and below, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256". (empty implies the default cipher suites)
+	CipherSuites []string `yaml:"cipher_suites"`
+
+	// ClientAuth represent the client certificate authentication mode, "none", "request", "verify-if-given" or "require". (empty implies "require" if CA is set, otherwise "none")
+	ClientAuth string `yaml:"client_auth"`
+
+	// ReloadInterval represent the interval to check the changes of the certificate, the key and the CA files. (default 10s, 0 implies disabled)
+	ReloadInterval string `yaml:"reload_interval"`
 }
 
 // Proxy represent the reverse proxy configuration to connect to Athenz server
@@ -90,10 +153,22 @@ type Proxy struct {
 	// RoleAuthHeaderName represent the HTTP header key name of the role token for Role token proxy request
 	RoleAuthHeaderName string `yaml:"role_header_key"`
 
+	// AccessAuthHeaderName represent the HTTP header key name of the access token for Access token proxy request
+	AccessAuthHeaderName string `yaml:"access_header_key"`
+
+	// AccessAuthScheme represent the authorization scheme prepended to the access token for Access token proxy request
+	AccessAuthScheme string `yaml:"access_auth_scheme"`
+
 	// BufferSize represent the reverse proxy buffer size
 	BufferSize uint64 `yaml:"buffer_size"`
 }
 
+// Policy represent the authorization policy configuration of the clients to request the tokens.
+type Policy struct {
+	// Path represent the policy file path. (empty implies all the requests are allowed)
+	Path string `yaml:"path"`
+}
+
 // Token represent the N-token detail to get the host certificate and role token
 type Token struct {
 	// AthenzDomain represent the athenz domain value to generate the N-token.
@@ -126,8 +201,14 @@ type Role struct {
 	// PrincipalAuthHeaderName is the HTTP header name for holding the n-token.
 	PrincipalAuthHeaderName string `yaml:"auth_header_key"`
 
-	// AthenzURL represent the athenz URL to get the role token
-	AthenzURL string `yaml:"athenz_url"`
+	// AthenzURL represent the athenz URLs to get the role token, it accepts a single URL or a list of URLs.
+	AthenzURL URLList `yaml:"athenz_url"`
+
+	// AthenzURLStrategy represent how to choose the athenz URL for each request, "priority" (default) or "round_robin".
+	AthenzURLStrategy string `yaml:"athenz_url_strategy"`
+
+	// UnhealthyDuration represent the duration to avoid the athenz URL after a connection error or a server error.
+	UnhealthyDuration string `yaml:"unhealthy_duration"`
 
 	// AthenzRootCA represent the Athenz server Root Certificate
 	AthenzRootCA string `yaml:"athenz_root_ca"`
@@ -135,14 +216,195 @@ type Role struct {
 	// TokenExpiry represent the duration of the expiration
 	TokenExpiry string `yaml:"expiration"`
 
-	// RefreshInterval represent the role token refresh duration.
+	// RefreshInterval represent the maximum duration between the refreshes of each role token.
 	RefreshInterval string `yaml:"refresh_interval"`
 
+	// RefreshRatio represent the fraction of the remaining lifetime of each role token to wait before refreshing it, e.g. 0.75.
+	RefreshRatio float64 `yaml:"refresh_ratio"`
+
+	// RefreshJitter represent the maximum fraction of the refresh delay to randomly subtract, to spread the refresh requests to athenz server.
+	RefreshJitter float64 `yaml:"refresh_jitter"`
+
 	// ErrRetryMaxCount represent the maximum error retry count during refreshing the role token cache.
 	ErrRetryMaxCount int `yaml:"err_retry_max_count"`
 
 	// ErrRetryInterval represent the error retry interval when refreshing the role token cache.
 	ErrRetryInterval string `yaml:"err_retry_interval"`
+
+	// RefreshConcurrency represent the number of the workers to refresh the role token cache.
+	RefreshConcurrency int `yaml:"refresh_concurrency"`
+
+	// RefreshDeadline represent the deadline of a refresh pass of the role token cache.
+	RefreshDeadline string `yaml:"refresh_deadline"`
+
+	// MaxInflightRequests represent the maximum number of the concurrent role token requests to athenz server. (0 implies unlimited)
+	MaxInflightRequests int `yaml:"max_inflight_requests"`
+
+	// ErrRetryMaxInterval represent the maximum error retry interval, the error retry interval doubles on each failure up to it.
+	ErrRetryMaxInterval string `yaml:"err_retry_max_interval"`
+
+	// CircuitBreaker represent the circuit breaker configuration of the role token requests to athenz server.
+	CircuitBreaker CircuitBreaker `yaml:"circuit_breaker"`
+
+	// ServeStale represent whether to keep serving the cached role token until its actual expiry time when it cannot be refreshed from athenz server.
+	ServeStale bool `yaml:"serve_stale"`
+
+	// CacheSnapshot represent the on-disk snapshot configuration of the role token cache.
+	CacheSnapshot CacheSnapshot `yaml:"cache_snapshot"`
+
+	// IdleTTL represent the duration after the last access to stop refreshing the role token and drop it from the cache. (empty implies disabled)
+	IdleTTL string `yaml:"idle_ttl"`
+
+	// MaxCacheEntries represent the maximum number of the cached role tokens, the least recently used one is evicted when it is exceeded. (0 implies unlimited)
+	MaxCacheEntries int `yaml:"max_cache_entries"`
+
+	// NegativeCacheTTL represent the duration to return the client error (4xx) of athenz server for the same domain, role and proxy for principal without requesting again. (default 10s, 0 implies disabled)
+	NegativeCacheTTL string `yaml:"negative_cache_ttl"`
+
+	// Prefetch represent the role tokens to fetch at startup and keep in the cache even if they are not requested.
+	Prefetch []PrefetchRole `yaml:"prefetch"`
+}
+
+// PrefetchRole represent the role token to prefetch
+type PrefetchRole struct {
+	// Domain represent the domain of the role token.
+	Domain string `yaml:"domain"`
+
+	// Role represent the role of the role token, comma separated for multiple roles. (empty implies all the roles)
+	Role string `yaml:"role"`
+
+	// ProxyForPrincipal represent the proxy for principal of the role token.
+	ProxyForPrincipal string `yaml:"proxy_for_principal"`
+
+	// MinExpiry represent the minimum expiry of the role token in seconds.
+	MinExpiry int64 `yaml:"min_expiry"`
+
+	// MaxExpiry represent the maximum expiry of the role token in seconds.
+	MaxExpiry int64 `yaml:"max_expiry"`
+}
+
+// CacheSnapshot represent the on-disk snapshot configuration of the token cache
+type CacheSnapshot struct {
+	// Path represent the file path of the snapshot encrypted by the key derived from the service private key. (empty implies disabled)
+	Path string `yaml:"path"`
+
+	// Interval represent the minimum interval between the writes of the snapshot on the cache change.
+	Interval string `yaml:"interval"`
+}
+
+// URLList represent the list of the URLs, it can be written as a single URL or a sequence of URLs in YAML.
+type URLList []string
+
+// UnmarshalYAML decodes a single URL or a sequence of URLs to the URLList.
+func (l *URLList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		*l = nil
+		if s != "" {
+			*l = URLList{s}
+		}
+		return nil
+	}
+
+	var ss []string
+	if err := unmarshal(&ss); err != nil {
+		return err
+	}
+	*l = ss
+	return nil
+}
+
+// CircuitBreaker represent the circuit breaker configuration of the requests to athenz server
+type CircuitBreaker struct {
+	// FailureThreshold represent the number of the consecutive failures to open the circuit breaker. (0 implies disabled)
+	FailureThreshold int `yaml:"failure_threshold"`
+
+	// OpenDuration represent the duration to fail fast before probing athenz server after the circuit breaker is opened.
+	OpenDuration string `yaml:"open_duration"`
+}
+
+// Access represent the Access token configuration
+type Access struct {
+	// PrincipalAuthHeaderName is the HTTP header name for holding the n-token.
+	PrincipalAuthHeaderName string `yaml:"auth_header_key"`
+
+	// AthenzURL represent the athenz URL to get the access token
+	AthenzURL string `yaml:"athenz_url"`
+
+	// AthenzRootCA represent the Athenz server Root Certificate
+	AthenzRootCA string `yaml:"athenz_root_ca"`
+
+	// TokenExpiry represent the duration of the expiration
+	TokenExpiry string `yaml:"expiration"`
+
+	// RefreshInterval represent the access token refresh duration.
+	RefreshInterval string `yaml:"refresh_interval"`
+
+	// ErrRetryMaxCount represent the maximum error retry count during refreshing the access token cache.
+	ErrRetryMaxCount int `yaml:"err_retry_max_count"`
+
+	// ErrRetryInterval represent the error retry interval when refreshing the access token cache.
+	ErrRetryInterval string `yaml:"err_retry_interval"`
+}
+
+// ServiceCert represent the service certificate configuration
+type ServiceCert struct {
+	// Enable represent whether to get the service certificate from athenz server or not.
+	Enable bool `yaml:"enable"`
+
+	// PrincipalAuthHeaderName is the HTTP header name for holding the n-token.
+	PrincipalAuthHeaderName string `yaml:"auth_header_key"`
+
+	// AthenzURL represent the athenz URL to get the service certificate
+	AthenzURL string `yaml:"athenz_url"`
+
+	// AthenzRootCA represent the Athenz server Root Certificate
+	AthenzRootCA string `yaml:"athenz_root_ca"`
+
+	// DNSSuffix represent the DNS suffix of the SAN DNS name of the service certificate, e.g. "athenz.cloud".
+	DNSSuffix string `yaml:"dns_suffix"`
+
+	// Expiration represent the requested lifetime of the service certificate.
+	Expiration string `yaml:"expiration"`
+
+	// RefreshBefore represent the duration before the certificate expiry to refresh the service certificate.
+	RefreshBefore string `yaml:"refresh_before"`
+
+	// ErrRetryMaxCount represent the maximum error retry count during refreshing the service certificate.
+	ErrRetryMaxCount int `yaml:"err_retry_max_count"`
+
+	// ErrRetryInterval represent the error retry interval when refreshing the service certificate.
+	ErrRetryInterval string `yaml:"err_retry_interval"`
+}
+
+// RoleCert represent the role certificate configuration
+type RoleCert struct {
+	// Enable represent whether to get the role certificate from athenz server or not.
+	Enable bool `yaml:"enable"`
+
+	// PrincipalAuthHeaderName is the HTTP header name for holding the n-token.
+	PrincipalAuthHeaderName string `yaml:"auth_header_key"`
+
+	// AthenzURL represent the athenz URL to get the role certificate
+	AthenzURL string `yaml:"athenz_url"`
+
+	// AthenzRootCA represent the Athenz server Root Certificate
+	AthenzRootCA string `yaml:"athenz_root_ca"`
+
+	// DNSSuffix represent the domain part of the SAN email address of the role certificate, e.g. "athenz.cloud".
+	DNSSuffix string `yaml:"dns_suffix"`
+
+	// Expiration represent the default requested lifetime of the role certificate.
+	Expiration string `yaml:"expiration"`
+
+	// RefreshBefore represent the duration before the certificate expiry to refresh the role certificate.
+	RefreshBefore string `yaml:"refresh_before"`
+
+	// ErrRetryMaxCount represent the maximum error retry count during refreshing the role certificate cache.
+	ErrRetryMaxCount int `yaml:"err_retry_max_count"`
+
+	// ErrRetryInterval represent the error retry interval when refreshing the role certificate cache.
+	ErrRetryInterval string `yaml:"err_retry_interval"`
 }
 
 const (
@@ -150,16 +412,51 @@ const (
 )
 
 // New returns *Config or error when decode the configuration file to actually *Config struct.
-func New(path string) (*Config, error) {
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+// The fields are overridden by the environment variables started with EnvPrefix after decoding the file,
+// and then by overrides, the "key=value" pairs of the dot separated YAML keys given by the command line flags.
+// The unknown YAML keys and overrides are not treated as error here, but reported by Config.Validate.
+// The environment variables which do not match any field are only logged as warning.
+func New(path string, overrides ...string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 	cfg := new(Config)
-	err = yaml.NewDecoder(f).Decode(&cfg)
+	err = yaml.NewDecoder(bytes.NewReader(b)).Decode(&cfg)
 	if err != nil {
 		return nil, err
 	}
+
+	// decode again in strict mode to find the unknown keys
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.SetStrict(true)
+	if err := dec.Decode(new(Config)); err != nil {
+		if te, ok := err.(*yaml.TypeError); ok {
+			for _, e := range te.Errors {
+				if strings.Contains(e, "not found in type") {
+					cfg.unknownFields = append(cfg.unknownFields, e)
+				}
+			}
+		}
+	}
+
+	unknownEnv, err := applyEnv(cfg, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+	// the unmatched environment variables are not fatal, since the environment may define them for other purposes,
+	// e.g. the service links of Kubernetes such as ATHENZ_CLIENT_SIDECAR_SERVICE_HOST
+	for _, k := range unknownEnv {
+		glg.Warnf("environment variable %s does not match any field, ignored", k)
+	}
+
+	unknownOverrides, err := applyOverrides(cfg, overrides)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range unknownOverrides {
+		cfg.unknownFields = append(cfg.unknownFields, "override "+k+" does not match any field")
+	}
 	return cfg, nil
 }
 
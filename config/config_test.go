@@ -21,6 +21,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	yaml "gopkg.in/yaml.v2"
 )
 
 func TestGetVersion(t *testing.T) {
@@ -65,6 +67,9 @@ func TestNew(t *testing.T) {
 					Port:             8080,
 					HealthzPort:      80,
 					HealthzPath:      "/healthz",
+					LivezPath:        "/livez",
+					ReadyzPath:       "/readyz",
+					MetricsPath:      "/metrics",
 					Timeout:          "10s",
 					ShutdownDuration: "10s",
 					ProbeWaitTime:    "9s",
@@ -81,18 +86,39 @@ func TestNew(t *testing.T) {
 					NTokenPath:      "/tmp/ntoken",
 					PrivateKeyPath:  "_athenz_private_key_",
 					ValidateToken:   false,
-					RefreshDuration: "30m",
+					RefreshDuration: "10m",
 					KeyVersion:      "v1.0",
 					Expiration:      "20m",
 				},
 				Role: Role{
+					PrincipalAuthHeaderName: "Athenz-Principal",
+					AthenzURL:               URLList{"https://www.athenz.com:4443/zts/v1"},
+					TokenExpiry:             "30m",
+				},
+				Access: Access{
 					PrincipalAuthHeaderName: "Athenz-Principal",
 					AthenzURL:               "https://www.athenz.com:4443/zts/v1",
 					TokenExpiry:             "30m",
 				},
+				ServiceCert: ServiceCert{
+					PrincipalAuthHeaderName: "Athenz-Principal",
+					AthenzURL:               "https://www.athenz.com:4443/zts/v1",
+					DNSSuffix:               "athenz.cloud",
+					Expiration:              "720h",
+					RefreshBefore:           "24h",
+				},
+				RoleCert: RoleCert{
+					PrincipalAuthHeaderName: "Athenz-Principal",
+					AthenzURL:               "https://www.athenz.com:4443/zts/v1",
+					DNSSuffix:               "athenz.cloud",
+					Expiration:              "720h",
+					RefreshBefore:           "24h",
+				},
 				Proxy: Proxy{
 					PrincipalAuthHeaderName: "Athenz-Principal",
 					RoleAuthHeaderName:      "Athenz-Role-Auth",
+					AccessAuthHeaderName:    "Authorization",
+					AccessAuthScheme:        "Bearer",
 					BufferSize:              1024,
 				},
 			},
@@ -104,6 +130,13 @@ func TestNew(t *testing.T) {
 			},
 			wantErr: fmt.Errorf("yaml: line "),
 		},
+		{
+			name: "Read not exists config file",
+			args: args{
+				path: "./assets/not_exists_config.yaml",
+			},
+			wantErr: fmt.Errorf("open ./assets/not_exists_config.yaml: no such file or directory"),
+		},
 	}
 
 	for _, tt := range tests {
@@ -132,6 +165,63 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNew_unknownFields(t *testing.T) {
+	got, err := New("./assets/unknown_field_config.yaml")
+	if err != nil {
+		t.Errorf("New() error = %v", err)
+		return
+	}
+	want := []string{"line 5: field unknown_port not found in type config.Server"}
+	if !reflect.DeepEqual(got.unknownFields, want) {
+		t.Errorf("New() unknownFields = %v, want %v", got.unknownFields, want)
+	}
+	if _, err := os.Stat("./assets/not_exists_config.yaml"); !os.IsNotExist(err) {
+		t.Errorf("New() should not create the config file, err: %v", err)
+	}
+}
+
+func TestURLList_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    URLList
+		wantErr bool
+	}{
+		{
+			name: "UnmarshalYAML decode a single URL",
+			yaml: "athenz_url: https://zts1.athenz.io/zts/v1",
+			want: URLList{"https://zts1.athenz.io/zts/v1"},
+		},
+		{
+			name: "UnmarshalYAML decode a list of URLs",
+			yaml: "athenz_url: [https://zts1.athenz.io/zts/v1, https://zts2.athenz.io/zts/v1]",
+			want: URLList{"https://zts1.athenz.io/zts/v1", "https://zts2.athenz.io/zts/v1"},
+		},
+		{
+			name: "UnmarshalYAML decode an empty URL",
+			yaml: `athenz_url: ""`,
+		},
+		{
+			name:    "UnmarshalYAML return error with a map",
+			yaml:    "athenz_url: {url: https://zts1.athenz.io/zts/v1}",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got Role
+			err := yaml.Unmarshal([]byte(tt.yaml), &got)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalYAML() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got.AthenzURL, tt.want) {
+				t.Errorf("UnmarshalYAML() = %v, want %v", got.AthenzURL, tt.want)
+			}
+		})
+	}
+}
+
 func TestGetActualValue(t *testing.T) {
 	type args struct {
 		cfg string
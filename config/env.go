@@ -0,0 +1,178 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvPrefix represents the prefix of the environment variables overriding the configuration fields.
+	// The variable name is EnvPrefix followed by the upper case YAML keys joined with "_", e.g. ATHENZ_CLIENT_SIDECAR_ROLETOKEN_ATHENZ_URL.
+	EnvPrefix = "ATHENZ_CLIENT_SIDECAR_"
+)
+
+// applyEnv overrides the fields of cfg by the environment variables started with EnvPrefix.
+// It returns the environment variables which do not match any field, or error if the value cannot be parsed.
+func applyEnv(cfg *Config, environ []string) ([]string, error) {
+	vars := make(map[string]string)
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+		if i := strings.Index(kv, "="); i > 0 {
+			vars[kv[:i]] = kv[i+1:]
+		}
+	}
+	if len(vars) == 0 {
+		return nil, nil
+	}
+
+	if err := applyEnvStruct(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"), vars); err != nil {
+		return nil, err
+	}
+
+	// the matched variables are deleted by applyEnvStruct
+	unknown := make([]string, 0, len(vars))
+	for k := range vars {
+		unknown = append(unknown, k)
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// applyOverrides overrides the fields of cfg by overrides, the "key=value" pairs of the dot separated YAML keys, e.g. "server.port=8081".
+// It returns the keys which do not match any field, or error if the override is malformed or the value cannot be parsed.
+func applyOverrides(cfg *Config, overrides []string) ([]string, error) {
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+
+	// the keys are converted to the environment variable names to share the parser
+	vars := make(map[string]string, len(overrides))
+	keys := make(map[string]string, len(overrides))
+	for _, o := range overrides {
+		i := strings.Index(o, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s: override must be key=value", o)
+		}
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(o[:i], ".", "_"))
+		vars[name] = o[i+1:]
+		keys[name] = o[:i]
+	}
+
+	if err := applyEnvStruct(reflect.ValueOf(cfg).Elem(), strings.TrimSuffix(EnvPrefix, "_"), vars); err != nil {
+		return nil, err
+	}
+
+	unknown := make([]string, 0, len(vars))
+	for k := range vars {
+		unknown = append(unknown, keys[k])
+	}
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+// applyEnvStruct sets the exported fields of v from vars recursively, and deletes the used variables from vars.
+func applyEnvStruct(v reflect.Value, prefix string, vars map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if f.PkgPath != "" || tag == "" || tag == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnvStruct(fv, name, vars); err != nil {
+				return err
+			}
+			continue
+		}
+
+		val, ok := vars[name]
+		if !ok {
+			continue
+		}
+		delete(vars, name)
+
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(val)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			fv.SetBool(b)
+		case reflect.Int, reflect.Int64:
+			n, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			fv.SetInt(n)
+		case reflect.Float64:
+			n, err := strconv.ParseFloat(val, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			fv.SetFloat(n)
+		case reflect.Uint64:
+			n, err := strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			fv.SetUint(n)
+		case reflect.Slice:
+			if fv.Type().Elem().Kind() != reflect.String {
+				// the list of the structs, e.g. the prefetch role tokens, is written in YAML
+				if err := setYAML(fv, val); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+				continue
+			}
+			// the list is written as the comma separated values
+			l := reflect.MakeSlice(fv.Type(), 0, 0)
+			for _, s := range strings.Split(val, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					l = reflect.Append(l, reflect.ValueOf(s))
+				}
+			}
+			fv.Set(l)
+		default:
+			return fmt.Errorf("%s: unsupported field type %s", name, fv.Type())
+		}
+	}
+	return nil
+}
+
+// setYAML sets v by the YAML value val, e.g. "[{domain: domain.shopping, role: users}]".
+func setYAML(v reflect.Value, val string) error {
+	p := reflect.New(v.Type())
+	if err := yaml.UnmarshalStrict([]byte(val), p.Interface()); err != nil {
+		return err
+	}
+	v.Set(p.Elem())
+	return nil
+}
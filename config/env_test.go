@@ -0,0 +1,281 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func Test_applyEnv(t *testing.T) {
+	type test struct {
+		name        string
+		cfg         *Config
+		environ     []string
+		want        *Config
+		wantUnknown []string
+		wantErr     error
+	}
+	tests := []test{
+		{
+			name: "applyEnv override the fields",
+			cfg: &Config{
+				Server: Server{
+					Port: 8080,
+				},
+				Role: Role{
+					AthenzURL: URLList{"https://www.athenz.com:4443/zts/v1"},
+				},
+			},
+			environ: []string{
+				"HOME=/root",
+				"ATHENZ_CLIENT_SIDECAR_SERVER_PORT=8081",
+				"ATHENZ_CLIENT_SIDECAR_SERVER_TLS_ENABLED=true",
+				"ATHENZ_CLIENT_SIDECAR_ROLETOKEN_ATHENZ_URL=https://zts1.athenz.io/zts/v1, https://zts2.athenz.io/zts/v1",
+				"ATHENZ_CLIENT_SIDECAR_PROXY_BUFFER_SIZE=2048",
+				"ATHENZ_CLIENT_SIDECAR_NTOKEN_KEY_VERSION=v=2",
+			},
+			want: &Config{
+				Server: Server{
+					Port: 8081,
+					TLS: TLS{
+						Enabled: true,
+					},
+				},
+				Token: Token{
+					KeyVersion: "v=2",
+				},
+				Role: Role{
+					AthenzURL: URLList{"https://zts1.athenz.io/zts/v1", "https://zts2.athenz.io/zts/v1"},
+				},
+				Proxy: Proxy{
+					BufferSize: 2048,
+				},
+			},
+		},
+		{
+			name: "applyEnv override the prefetch role tokens by YAML",
+			cfg:  &Config{},
+			environ: []string{
+				"ATHENZ_CLIENT_SIDECAR_ROLETOKEN_PREFETCH=[{domain: domain.shopping, role: \"users,admin\"}, {domain: domain.travel, min_expiry: 600}]",
+			},
+			want: &Config{
+				Role: Role{
+					Prefetch: []PrefetchRole{
+						{
+							Domain: "domain.shopping",
+							Role:   "users,admin",
+						},
+						{
+							Domain:    "domain.travel",
+							MinExpiry: 600,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "applyEnv return error with invalid prefetch role tokens",
+			cfg:  &Config{},
+			environ: []string{
+				"ATHENZ_CLIENT_SIDECAR_ROLETOKEN_PREFETCH=[{domain: domain.shopping, unknown: 1}]",
+			},
+			wantErr: fmt.Errorf("ATHENZ_CLIENT_SIDECAR_ROLETOKEN_PREFETCH: yaml: unmarshal errors:\n  line 1: field unknown not found in type config.PrefetchRole"),
+		},
+		{
+			name: "applyEnv return unknown environment variables",
+			cfg:  &Config{},
+			environ: []string{
+				"ATHENZ_CLIENT_SIDECAR_SERVER_UNKNOWN=1",
+				"ATHENZ_CLIENT_SIDECAR_SERVER=1",
+				"ATHENZ_CLIENT_SIDECAR_VERSION=v1.0.0",
+			},
+			want: &Config{
+				Version: "v1.0.0",
+			},
+			wantUnknown: []string{
+				"ATHENZ_CLIENT_SIDECAR_SERVER",
+				"ATHENZ_CLIENT_SIDECAR_SERVER_UNKNOWN",
+			},
+		},
+		{
+			name: "applyEnv return error with invalid int",
+			cfg:  &Config{},
+			environ: []string{
+				"ATHENZ_CLIENT_SIDECAR_SERVER_PORT=dummy",
+			},
+			wantErr: fmt.Errorf(`ATHENZ_CLIENT_SIDECAR_SERVER_PORT: strconv.ParseInt: parsing "dummy": invalid syntax`),
+		},
+		{
+			name: "applyEnv return error with invalid bool",
+			cfg:  &Config{},
+			environ: []string{
+				"ATHENZ_CLIENT_SIDECAR_ENABLE_LOG_COLOR=dummy",
+			},
+			wantErr: fmt.Errorf(`ATHENZ_CLIENT_SIDECAR_ENABLE_LOG_COLOR: strconv.ParseBool: parsing "dummy": invalid syntax`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUnknown, err := applyEnv(tt.cfg, tt.environ)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("applyEnv() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("applyEnv() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(tt.cfg, tt.want) {
+				t.Errorf("applyEnv() cfg = %+v, want %+v", tt.cfg, tt.want)
+			}
+			if len(gotUnknown) != 0 || len(tt.wantUnknown) != 0 {
+				if !reflect.DeepEqual(gotUnknown, tt.wantUnknown) {
+					t.Errorf("applyEnv() unknown = %v, want %v", gotUnknown, tt.wantUnknown)
+				}
+			}
+		})
+	}
+}
+
+func TestNew_env(t *testing.T) {
+	os.Setenv("ATHENZ_CLIENT_SIDECAR_SERVER_PORT", "9999")
+	os.Setenv("ATHENZ_CLIENT_SIDECAR_SERVICE_HOST", "10.0.0.1")
+	defer os.Unsetenv("ATHENZ_CLIENT_SIDECAR_SERVER_PORT")
+	defer os.Unsetenv("ATHENZ_CLIENT_SIDECAR_SERVICE_HOST")
+
+	got, err := New("./assets/valid_config.yaml")
+	if err != nil {
+		t.Errorf("New() error = %v", err)
+		return
+	}
+	if got.Server.Port != 9999 {
+		t.Errorf("New() Server.Port = %v, want %v", got.Server.Port, 9999)
+	}
+	// the unknown environment variables, e.g. the service links of Kubernetes, are not reported by Validate
+	if len(got.unknownFields) != 0 {
+		t.Errorf("New() unknownFields = %v, want empty", got.unknownFields)
+	}
+}
+
+func Test_applyOverrides(t *testing.T) {
+	type test struct {
+		name        string
+		cfg         *Config
+		overrides   []string
+		want        *Config
+		wantUnknown []string
+		wantErr     error
+	}
+	tests := []test{
+		{
+			name: "applyOverrides override the fields",
+			cfg: &Config{
+				Server: Server{
+					Port: 8080,
+				},
+			},
+			overrides: []string{
+				"server.port=8081",
+				"server.tls.enabled=true",
+				"roletoken.athenz_url=https://zts1.athenz.io/zts/v1,https://zts2.athenz.io/zts/v1",
+				"ntoken.key_version=v=2",
+			},
+			want: &Config{
+				Server: Server{
+					Port: 8081,
+					TLS: TLS{
+						Enabled: true,
+					},
+				},
+				Token: Token{
+					KeyVersion: "v=2",
+				},
+				Role: Role{
+					AthenzURL: URLList{"https://zts1.athenz.io/zts/v1", "https://zts2.athenz.io/zts/v1"},
+				},
+			},
+		},
+		{
+			name: "applyOverrides return unknown keys",
+			cfg:  &Config{},
+			overrides: []string{
+				"server.unknown=1",
+				"version=v1.0.0",
+			},
+			want: &Config{
+				Version: "v1.0.0",
+			},
+			wantUnknown: []string{
+				"server.unknown",
+			},
+		},
+		{
+			name: "applyOverrides return error without value",
+			cfg:  &Config{},
+			overrides: []string{
+				"server.port",
+			},
+			wantErr: fmt.Errorf("server.port: override must be key=value"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUnknown, err := applyOverrides(tt.cfg, tt.overrides)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("applyOverrides() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("applyOverrides() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(tt.cfg, tt.want) {
+				t.Errorf("applyOverrides() cfg = %+v, want %+v", tt.cfg, tt.want)
+			}
+			if len(gotUnknown) != 0 || len(tt.wantUnknown) != 0 {
+				if !reflect.DeepEqual(gotUnknown, tt.wantUnknown) {
+					t.Errorf("applyOverrides() unknown = %v, want %v", gotUnknown, tt.wantUnknown)
+				}
+			}
+		})
+	}
+}
+
+func TestNew_overrides(t *testing.T) {
+	os.Setenv("ATHENZ_CLIENT_SIDECAR_SERVER_PORT", "9999")
+	defer os.Unsetenv("ATHENZ_CLIENT_SIDECAR_SERVER_PORT")
+
+	// the override wins over the environment variable
+	got, err := New("./assets/valid_config.yaml", "server.port=8081", "dummy=1")
+	if err != nil {
+		t.Errorf("New() error = %v", err)
+		return
+	}
+	if got.Server.Port != 8081 {
+		t.Errorf("New() Server.Port = %v, want %v", got.Server.Port, 8081)
+	}
+	want := []string{"override dummy does not match any field"}
+	if !reflect.DeepEqual(got.unknownFields, want) {
+		t.Errorf("New() unknownFields = %v, want %v", got.unknownFields, want)
+	}
+}
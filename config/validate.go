@@ -0,0 +1,230 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ValidationError represents all the problems found in the configuration.
+type ValidationError []string
+
+const (
+	// defaultTokenRefreshInterval represents the default refresh interval of the role token and access token cache.
+	defaultTokenRefreshInterval = time.Minute * 30
+
+	// maxPort represents the maximum TCP port number.
+	maxPort = 65535
+)
+
+// tlsVersions represents the TLS version names accepted in the configuration and their order. The empty name implies the default version.
+var tlsVersions = map[string]int{
+	"":    0,
+	"1.0": 10,
+	"1.1": 11,
+	"1.2": 12,
+	"1.3": 13,
+}
+
+// Error returns all the problems in one line.
+func (v ValidationError) Error() string {
+	return "invalid config: " + strings.Join(v, ", ")
+}
+
+// Validate returns ValidationError containing every problem of the configuration, or nil if the configuration is valid.
+// It checks the version, the port numbers, the durations, the relation of the refresh interval and the expiration,
+// the private key file, the TLS settings and the unknown YAML keys found by New.
+func (c *Config) Validate() error {
+	var v ValidationError
+	addf := func(format string, args ...interface{}) {
+		v = append(v, fmt.Sprintf(format, args...))
+	}
+	duration := func(name, val string, required bool) time.Duration {
+		if val == "" {
+			if required {
+				addf("%s: required", name)
+			}
+			return 0
+		}
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			addf("%s: %v", name, err)
+			return 0
+		}
+		if d < 0 {
+			addf("%s: must not be negative", name)
+		}
+		return d
+	}
+	nonNegative := func(name string, val int) {
+		if val < 0 {
+			addf("%s: must not be negative", name)
+		}
+	}
+	refreshInterval := func(name, refresh, expiry string) {
+		r := duration(name+".refresh_interval", refresh, false)
+		e := duration(name+".expiration", expiry, false)
+		if refresh == "" {
+			r = defaultTokenRefreshInterval
+		}
+		if e != 0 && r > e {
+			addf("%s: refresh interval %v > token expiry time %v", name, r, e)
+		}
+	}
+
+	for _, k := range c.unknownFields {
+		addf("unknown field: %s", k)
+	}
+
+	if c.Version != currentVersion {
+		addf("version: %q is not supported, want %q", c.Version, currentVersion)
+	}
+
+	// server
+	if c.Server.Port < 0 || c.Server.Port > maxPort || (c.Server.Port == 0 && GetActualValue(c.Server.Socket.Path) == "") {
+		addf("server.port: %d is out of range", c.Server.Port)
+	}
+	if c.Server.Socket.Mode != "" {
+		if m, err := strconv.ParseUint(c.Server.Socket.Mode, 8, 32); err != nil || m > 0777 {
+			addf("server.socket.mode: %q is not an octal permission, e.g. \"0660\"", c.Server.Socket.Mode)
+		}
+	}
+	if c.Server.HealthzPort < 0 || c.Server.HealthzPort > maxPort {
+		addf("server.health_check_port: %d is out of range", c.Server.HealthzPort)
+	} else if c.Server.HealthzPort > 0 && c.Server.HealthzPort == c.Server.Port {
+		addf("server.health_check_port: %d conflicts with server.port", c.Server.HealthzPort)
+	}
+	if c.Server.AdminPort < 0 || c.Server.AdminPort > maxPort {
+		addf("server.admin_port: %d is out of range", c.Server.AdminPort)
+	} else if c.Server.AdminPort > 0 && (c.Server.AdminPort == c.Server.Port || c.Server.AdminPort == c.Server.HealthzPort) {
+		addf("server.admin_port: %d conflicts with server.port or server.health_check_port", c.Server.AdminPort)
+	}
+	duration("server.timeout", c.Server.Timeout, false)
+	duration("server.shutdown_duration", c.Server.ShutdownDuration, false)
+	duration("server.probe_wait_time", c.Server.ProbeWaitTime, false)
+	if c.Server.TLS.Enabled {
+		if GetActualValue(c.Server.TLS.Cert) == "" || GetActualValue(c.Server.TLS.Key) == "" {
+			addf("server.tls: cert and key are required when TLS is enabled")
+		}
+		minVer, minOK := tlsVersions[c.Server.TLS.MinVersion]
+		if !minOK {
+			addf("server.tls.min_version: %q is not a TLS version, e.g. \"1.2\"", c.Server.TLS.MinVersion)
+		}
+		maxVer, maxOK := tlsVersions[c.Server.TLS.MaxVersion]
+		if !maxOK {
+			addf("server.tls.max_version: %q is not a TLS version, e.g. \"1.3\"", c.Server.TLS.MaxVersion)
+		}
+		if minOK && maxOK && c.Server.TLS.MinVersion != "" && c.Server.TLS.MaxVersion != "" && minVer > maxVer {
+			addf("server.tls: min_version %s > max_version %s", c.Server.TLS.MinVersion, c.Server.TLS.MaxVersion)
+		}
+		switch c.Server.TLS.ClientAuth {
+		case "", "none", "request":
+		case "verify-if-given", "require":
+			if GetActualValue(c.Server.TLS.CA) == "" {
+				addf("server.tls.client_auth: %q requires ca", c.Server.TLS.ClientAuth)
+			}
+		default:
+			addf("server.tls.client_auth: %q is not one of none, request, verify-if-given or require", c.Server.TLS.ClientAuth)
+		}
+		duration("server.tls.reload_interval", c.Server.TLS.ReloadInterval, false)
+	}
+
+	// ntoken
+	refresh := duration("ntoken.refresh_duration", c.Token.RefreshDuration, true)
+	expiry := duration("ntoken.expiration", c.Token.Expiration, true)
+	if refresh > 0 && expiry > 0 && refresh > expiry {
+		addf("ntoken: refresh duration %v > token expiry time %v", refresh, expiry)
+	}
+	keyRequired := c.Token.NTokenPath == "" || c.ServiceCert.Enable || c.RoleCert.Enable
+	if key := GetActualValue(c.Token.PrivateKeyPath); keyRequired || key != "" {
+		if _, err := os.Stat(key); err != nil {
+			addf("ntoken.private_key_path: %v", err)
+		}
+	}
+
+	// roletoken
+	refreshInterval("roletoken", c.Role.RefreshInterval, c.Role.TokenExpiry)
+	if c.Role.RefreshRatio < 0 || c.Role.RefreshRatio > 1 {
+		addf("roletoken.refresh_ratio: %v is out of range [0, 1]", c.Role.RefreshRatio)
+	}
+	if c.Role.RefreshJitter < 0 || c.Role.RefreshJitter >= 1 {
+		addf("roletoken.refresh_jitter: %v is out of range [0, 1)", c.Role.RefreshJitter)
+	}
+	duration("roletoken.err_retry_interval", c.Role.ErrRetryInterval, false)
+	nonNegative("roletoken.err_retry_max_count", c.Role.ErrRetryMaxCount)
+	duration("roletoken.refresh_deadline", c.Role.RefreshDeadline, false)
+	duration("roletoken.err_retry_max_interval", c.Role.ErrRetryMaxInterval, false)
+	nonNegative("roletoken.circuit_breaker.failure_threshold", c.Role.CircuitBreaker.FailureThreshold)
+	duration("roletoken.circuit_breaker.open_duration", c.Role.CircuitBreaker.OpenDuration, false)
+	nonNegative("roletoken.refresh_concurrency", c.Role.RefreshConcurrency)
+	nonNegative("roletoken.max_inflight_requests", c.Role.MaxInflightRequests)
+	switch c.Role.AthenzURLStrategy {
+	case "", "priority", "round_robin":
+	default:
+		addf("roletoken.athenz_url_strategy: %q is not supported, want \"priority\" or \"round_robin\"", c.Role.AthenzURLStrategy)
+	}
+	duration("roletoken.unhealthy_duration", c.Role.UnhealthyDuration, false)
+	duration("roletoken.cache_snapshot.interval", c.Role.CacheSnapshot.Interval, false)
+	duration("roletoken.idle_ttl", c.Role.IdleTTL, false)
+	nonNegative("roletoken.max_cache_entries", c.Role.MaxCacheEntries)
+	duration("roletoken.negative_cache_ttl", c.Role.NegativeCacheTTL, false)
+	for i, p := range c.Role.Prefetch {
+		name := fmt.Sprintf("roletoken.prefetch[%d]", i)
+		if p.Domain == "" {
+			addf("%s.domain: required", name)
+		}
+		if p.MinExpiry < 0 || p.MaxExpiry < 0 {
+			addf("%s: expiry must not be negative", name)
+		} else if p.MaxExpiry > 0 && p.MinExpiry > p.MaxExpiry {
+			addf("%s: min_expiry %d > max_expiry %d", name, p.MinExpiry, p.MaxExpiry)
+		}
+	}
+	if GetActualValue(c.Role.CacheSnapshot.Path) != "" {
+		if _, err := os.Stat(GetActualValue(c.Token.PrivateKeyPath)); err != nil {
+			addf("roletoken.cache_snapshot: private key is required, %v", err)
+		}
+	}
+
+	// accesstoken
+	refreshInterval("accesstoken", c.Access.RefreshInterval, c.Access.TokenExpiry)
+	duration("accesstoken.err_retry_interval", c.Access.ErrRetryInterval, false)
+	nonNegative("accesstoken.err_retry_max_count", c.Access.ErrRetryMaxCount)
+
+	// service_cert
+	if c.ServiceCert.Enable {
+		duration("service_cert.expiration", c.ServiceCert.Expiration, false)
+		duration("service_cert.refresh_before", c.ServiceCert.RefreshBefore, false)
+		duration("service_cert.err_retry_interval", c.ServiceCert.ErrRetryInterval, false)
+		nonNegative("service_cert.err_retry_max_count", c.ServiceCert.ErrRetryMaxCount)
+	}
+
+	// role_cert
+	if c.RoleCert.Enable {
+		duration("role_cert.expiration", c.RoleCert.Expiration, false)
+		duration("role_cert.refresh_before", c.RoleCert.RefreshBefore, false)
+		duration("role_cert.err_retry_interval", c.RoleCert.ErrRetryInterval, false)
+		nonNegative("role_cert.err_retry_max_count", c.RoleCert.ErrRetryMaxCount)
+	}
+
+	if len(v) > 0 {
+		return v
+	}
+	return nil
+}
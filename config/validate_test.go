@@ -0,0 +1,337 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	valid := func() *Config {
+		return &Config{
+			Version: currentVersion,
+			Server: Server{
+				Port:             8080,
+				HealthzPort:      80,
+				Timeout:          "10s",
+				ShutdownDuration: "10s",
+				ProbeWaitTime:    "9s",
+			},
+			Token: Token{
+				PrivateKeyPath:  "./assets/valid_config.yaml",
+				RefreshDuration: "10m",
+				Expiration:      "20m",
+			},
+			Role: Role{
+				TokenExpiry:     "30m",
+				RefreshInterval: "20m",
+			},
+		}
+	}
+	type test struct {
+		name string
+		cfg  func() *Config
+		want error
+	}
+	tests := []test{
+		{
+			name: "Validate return nil for valid config",
+			cfg:  valid,
+		},
+		{
+			name: "Validate return nil when private key is not required",
+			cfg: func() *Config {
+				c := valid()
+				c.Token.NTokenPath = "/tmp/ntoken"
+				c.Token.PrivateKeyPath = ""
+				return c
+			},
+		},
+		{
+			name: "Validate return all the problems",
+			cfg: func() *Config {
+				c := valid()
+				c.unknownFields = []string{"line 1: field dummy not found in type config.Config"}
+				c.Version = "v0"
+				c.Server.Port = 0
+				c.Server.HealthzPort = 70000
+				c.Server.Timeout = "1x"
+				c.Server.TLS = TLS{Enabled: true}
+				c.Token.PrivateKeyPath = "./assets/not_exists.key"
+				c.Token.RefreshDuration = ""
+				c.Role.RefreshInterval = "1h"
+				c.Access.TokenExpiry = "10m"
+				c.Access.ErrRetryMaxCount = -1
+				c.RoleCert = RoleCert{
+					Enable:        true,
+					RefreshBefore: "-1h",
+				}
+				return c
+			},
+			want: ValidationError{
+				"unknown field: line 1: field dummy not found in type config.Config",
+				`version: "v0" is not supported, want "v1.0.0"`,
+				"server.port: 0 is out of range",
+				"server.health_check_port: 70000 is out of range",
+				`server.timeout: time: unknown unit "x" in duration "1x"`,
+				"server.tls: cert and key are required when TLS is enabled",
+				"ntoken.refresh_duration: required",
+				"ntoken.private_key_path: stat ./assets/not_exists.key: no such file or directory",
+				"roletoken: refresh interval 1h0m0s > token expiry time 30m0s",
+				"accesstoken: refresh interval 30m0s > token expiry time 10m0s",
+				"accesstoken.err_retry_max_count: must not be negative",
+				"role_cert.refresh_before: must not be negative",
+			},
+		},
+		{
+			name: "Validate return nil when server listens on unix domain socket only",
+			cfg: func() *Config {
+				c := valid()
+				c.Server.Port = 0
+				c.Server.Socket = Socket{
+					Path:  "/var/run/athenz/sidecar.sock",
+					Group: "athenz",
+					Mode:  "0660",
+				}
+				return c
+			},
+		},
+		{
+			name: "Validate return error when unix domain socket mode is invalid",
+			cfg: func() *Config {
+				c := valid()
+				c.Server.Socket = Socket{
+					Path: "/var/run/athenz/sidecar.sock",
+					Mode: "rw-rw----",
+				}
+				return c
+			},
+			want: ValidationError{
+				`server.socket.mode: "rw-rw----" is not an octal permission, e.g. "0660"`,
+			},
+		},
+		{
+			name: "Validate return nil for valid TLS policy",
+			cfg: func() *Config {
+				c := valid()
+				c.Server.TLS = TLS{
+					Enabled:        true,
+					Cert:           "/etc/tls/tls.crt",
+					Key:            "/etc/tls/tls.key",
+					CA:             "/etc/tls/ca.crt",
+					MinVersion:     "1.2",
+					MaxVersion:     "1.3",
+					ClientAuth:     "verify-if-given",
+					ReloadInterval: "30s",
+				}
+				return c
+			},
+		},
+		{
+			name: "Validate return error when TLS policy is invalid",
+			cfg: func() *Config {
+				c := valid()
+				c.Server.TLS = TLS{
+					Enabled:        true,
+					Cert:           "/etc/tls/tls.crt",
+					Key:            "/etc/tls/tls.key",
+					MinVersion:     "1.3",
+					MaxVersion:     "1.2",
+					ClientAuth:     "require",
+					ReloadInterval: "-1s",
+				}
+				return c
+			},
+			want: ValidationError{
+				"server.tls: min_version 1.3 > max_version 1.2",
+				`server.tls.client_auth: "require" requires ca`,
+				"server.tls.reload_interval: must not be negative",
+			},
+		},
+		{
+			name: "Validate return error when TLS version or client auth is unknown",
+			cfg: func() *Config {
+				c := valid()
+				c.Server.TLS = TLS{
+					Enabled:    true,
+					Cert:       "/etc/tls/tls.crt",
+					Key:        "/etc/tls/tls.key",
+					MinVersion: "TLSv1.2",
+					ClientAuth: "optional",
+				}
+				return c
+			},
+			want: ValidationError{
+				`server.tls.min_version: "TLSv1.2" is not a TLS version, e.g. "1.2"`,
+				`server.tls.client_auth: "optional" is not one of none, request, verify-if-given or require`,
+			},
+		},
+		{
+			name: "Validate return error when ports conflict",
+			cfg: func() *Config {
+				c := valid()
+				c.Server.HealthzPort = c.Server.Port
+				return c
+			},
+			want: ValidationError{
+				"server.health_check_port: 8080 conflicts with server.port",
+			},
+		},
+		{
+			name: "Validate return error when admin port conflicts",
+			cfg: func() *Config {
+				c := valid()
+				c.Server.AdminPort = c.Server.HealthzPort
+				return c
+			},
+			want: ValidationError{
+				"server.admin_port: 80 conflicts with server.port or server.health_check_port",
+			},
+		},
+		{
+			name: "Validate return error when role token refresh ratio and jitter are out of range",
+			cfg: func() *Config {
+				c := valid()
+				c.Role.RefreshRatio = 1.5
+				c.Role.RefreshJitter = 1
+				return c
+			},
+			want: ValidationError{
+				"roletoken.refresh_ratio: 1.5 is out of range [0, 1]",
+				"roletoken.refresh_jitter: 1 is out of range [0, 1)",
+			},
+		},
+		{
+			name: "Validate return error when role token athenz URL strategy is unknown",
+			cfg: func() *Config {
+				c := valid()
+				c.Role.AthenzURLStrategy = "random"
+				c.Role.UnhealthyDuration = "-1s"
+				return c
+			},
+			want: ValidationError{
+				`roletoken.athenz_url_strategy: "random" is not supported, want "priority" or "round_robin"`,
+				"roletoken.unhealthy_duration: must not be negative",
+			},
+		},
+		{
+			name: "Validate return error when role token cache settings are invalid",
+			cfg: func() *Config {
+				c := valid()
+				c.Role.CacheSnapshot.Path = "/tmp/roletoken.snapshot"
+				c.Role.CacheSnapshot.Interval = "1x"
+				c.Role.IdleTTL = "-1h"
+				c.Role.MaxCacheEntries = -1
+				c.Role.NegativeCacheTTL = "-1s"
+				return c
+			},
+			want: ValidationError{
+				`roletoken.cache_snapshot.interval: time: unknown unit "x" in duration "1x"`,
+				"roletoken.idle_ttl: must not be negative",
+				"roletoken.max_cache_entries: must not be negative",
+				"roletoken.negative_cache_ttl: must not be negative",
+			},
+		},
+		{
+			name: "Validate return error when role token prefetch is invalid",
+			cfg: func() *Config {
+				c := valid()
+				c.Role.Prefetch = []PrefetchRole{
+					{
+						Domain: "domain",
+						Role:   "role",
+					},
+					{
+						Role: "role",
+					},
+					{
+						Domain:    "domain",
+						MinExpiry: 600,
+						MaxExpiry: 300,
+					},
+				}
+				return c
+			},
+			want: ValidationError{
+				"roletoken.prefetch[1].domain: required",
+				"roletoken.prefetch[2]: min_expiry 600 > max_expiry 300",
+			},
+		},
+		{
+			name: "Validate return error when ntoken refresh duration > expiration",
+			cfg: func() *Config {
+				c := valid()
+				c.Token.RefreshDuration = "30m"
+				return c
+			},
+			want: ValidationError{
+				"ntoken: refresh duration 30m0s > token expiry time 20m0s",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg().Validate()
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("Validate() = %#v, want %#v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfig_Validate_file(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{
+			name: "Validate valid config file",
+			path: "./assets/valid_config.yaml",
+		},
+		{
+			name:    "Validate config file with unknown field",
+			path:    "./assets/unknown_field_config.yaml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := New(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidationError_Error(t *testing.T) {
+	err := ValidationError{"a: invalid", "b: required"}
+	want := "invalid config: a: invalid, b: required"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %v, want %v", got, want)
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/kpango/glg v1.4.6
 	github.com/kpango/ntokend v1.0.7
 	github.com/pkg/errors v0.8.1
+	github.com/prometheus/client_golang v1.2.1
 	golang.org/x/sync v0.0.0-20190911185100-cd5d95a43a6e
 	gopkg.in/yaml.v2 v2.2.4
 )
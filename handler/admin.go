@@ -0,0 +1,107 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/kpango/glg"
+	"github.com/yahoojapan/athenz-client-sidecar/model"
+	"github.com/yahoojapan/athenz-client-sidecar/service"
+)
+
+// AdminHandler for handling a set of HTTP requests on the admin server.
+type AdminHandler interface {
+	// RoleTokenCache handles list cached role tokens requests.
+	RoleTokenCache(http.ResponseWriter, *http.Request) error
+	// RefreshRoleTokenCache handles refresh a cached role token requests.
+	RefreshRoleTokenCache(http.ResponseWriter, *http.Request) error
+	// InvalidateRoleTokenCache handles invalidate one or all cached role tokens requests.
+	InvalidateRoleTokenCache(http.ResponseWriter, *http.Request) error
+}
+
+// adminHandler is internal implementation of AdminHandler interface.
+type adminHandler struct {
+	role service.RoleCacheAdmin
+}
+
+// NewAdmin creates a handler for handling the admin requests to inspect and manipulate the token cache.
+func NewAdmin(role service.RoleCacheAdmin) AdminHandler {
+	return &adminHandler{
+		role: role,
+	}
+}
+
+// RoleTokenCache handles list cached role tokens requests and responses the information of the cached role tokens without the tokens. Depends on role token service.
+func (h *adminHandler) RoleTokenCache(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(h.role.CacheEntries())
+}
+
+// RefreshRoleTokenCache handles refresh a cached role token requests and responses the refreshed information. Depends on role token service.
+func (h *adminHandler) RefreshRoleTokenCache(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	var data model.RoleCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return err
+	}
+	e, err := h.role.RefreshCacheEntry(r.Context(), data.Domain, data.Role, data.ProxyForPrincipal)
+	if err == service.ErrRoleCacheEntryNotFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	glg.Infof("role token cache is refreshed by admin request, domain: %s, role: %s, proxyForPrincipal: %s", data.Domain, data.Role, data.ProxyForPrincipal)
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(e)
+}
+
+// InvalidateRoleTokenCache handles invalidate one or all cached role tokens requests and responses the number of the invalidated role tokens. Depends on role token service.
+func (h *adminHandler) InvalidateRoleTokenCache(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	var data model.RoleCacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		return err
+	}
+
+	var n int
+	switch {
+	case data.All:
+		n = h.role.InvalidateAllCacheEntries()
+	case data.Domain == "":
+		// the empty request does not invalidate all by mistake
+		http.Error(w, "domain or all is required", http.StatusBadRequest)
+		return nil
+	case h.role.InvalidateCacheEntry(data.Domain, data.Role, data.ProxyForPrincipal):
+		n = 1
+	default:
+		http.Error(w, service.ErrRoleCacheEntryNotFound.Error(), http.StatusNotFound)
+		return nil
+	}
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(model.RoleCacheInvalidateResponse{
+		Invalidated: n,
+	})
+}
@@ -0,0 +1,200 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/yahoojapan/athenz-client-sidecar/service"
+)
+
+// roleCacheAdminMock is the mock of service.RoleCacheAdmin.
+type roleCacheAdminMock struct {
+	entries []service.RoleCacheEntry
+}
+
+func (m *roleCacheAdminMock) CacheEntries() []service.RoleCacheEntry {
+	return m.entries
+}
+
+func (m *roleCacheAdminMock) RefreshCacheEntry(ctx context.Context, domain, role, proxyForPrincipal string) (*service.RoleCacheEntry, error) {
+	for _, e := range m.entries {
+		if e.Domain == domain && e.Role == role && e.ProxyForPrincipal == proxyForPrincipal {
+			e.LastRefresh++
+			return &e, nil
+		}
+	}
+	return nil, service.ErrRoleCacheEntryNotFound
+}
+
+func (m *roleCacheAdminMock) InvalidateCacheEntry(domain, role, proxyForPrincipal string) bool {
+	for i, e := range m.entries {
+		if e.Domain == domain && e.Role == role && e.ProxyForPrincipal == proxyForPrincipal {
+			m.entries = append(m.entries[:i], m.entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (m *roleCacheAdminMock) InvalidateAllCacheEntries() int {
+	n := len(m.entries)
+	m.entries = nil
+	return n
+}
+
+func newRoleCacheAdminMock() *roleCacheAdminMock {
+	return &roleCacheAdminMock{
+		entries: []service.RoleCacheEntry{
+			{
+				Domain:      "domain1",
+				Role:        "role1",
+				ExpiryTime:  100,
+				LastRefresh: 10,
+				LastAccess:  20,
+			},
+			{
+				Domain:            "domain2",
+				Role:              "role2",
+				ProxyForPrincipal: "principal",
+				ExpiryTime:        200,
+				LastRefresh:       30,
+				LastAccess:        40,
+				LastError:         "error",
+			},
+		},
+	}
+}
+
+func Test_adminHandler_RoleTokenCache(t *testing.T) {
+	h := NewAdmin(newRoleCacheAdminMock())
+	w := httptest.NewRecorder()
+	if err := h.RoleTokenCache(w, httptest.NewRequest(http.MethodGet, "/roletoken/cache", nil)); err != nil {
+		t.Fatalf("RoleTokenCache() error = %v", err)
+	}
+
+	want := `[{"domain":"domain1","role":"role1","proxyForPrincipal":"","expiryTime":100,"lastRefresh":10,"lastAccess":20},` +
+		`{"domain":"domain2","role":"role2","proxyForPrincipal":"principal","expiryTime":200,"lastRefresh":30,"lastAccess":40,"lastError":"error"}]` + "\n"
+	if err := EqualResponse(w, http.StatusOK, map[string]string{"Content-type": "application/json; charset=utf-8"}, []byte(want)); err != nil {
+		t.Errorf("RoleTokenCache() %v", err)
+	}
+}
+
+func Test_adminHandler_RefreshRoleTokenCache(t *testing.T) {
+	type test struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+		wantErr  bool
+	}
+	tests := []test{
+		{
+			name:     "refresh cached role token",
+			body:     `{"domain":"domain1","role":"role1"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"domain":"domain1","role":"role1","proxyForPrincipal":"","expiryTime":100,"lastRefresh":11,"lastAccess":20}` + "\n",
+		},
+		{
+			name:     "refresh not cached role token",
+			body:     `{"domain":"domain3","role":"role1"}`,
+			wantCode: http.StatusNotFound,
+			wantBody: service.ErrRoleCacheEntryNotFound.Error() + "\n",
+		},
+		{
+			name:    "invalid request body",
+			body:    `{`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewAdmin(newRoleCacheAdminMock())
+			w := httptest.NewRecorder()
+			err := h.RefreshRoleTokenCache(w, httptest.NewRequest(http.MethodPost, "/roletoken/cache/refresh", strings.NewReader(tt.body)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RefreshRoleTokenCache() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if err := EqualResponse(w, tt.wantCode, nil, []byte(tt.wantBody)); err != nil {
+				t.Errorf("RefreshRoleTokenCache() %v", err)
+			}
+		})
+	}
+}
+
+func Test_adminHandler_InvalidateRoleTokenCache(t *testing.T) {
+	type test struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+		wantErr  bool
+	}
+	tests := []test{
+		{
+			name:     "invalidate cached role token",
+			body:     `{"domain":"domain2","role":"role2","proxy_for_principal":"principal"}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"invalidated":1}` + "\n",
+		},
+		{
+			name:     "invalidate all role tokens",
+			body:     `{"all":true}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"invalidated":2}` + "\n",
+		},
+		{
+			name:     "invalidate not cached role token",
+			body:     `{"domain":"domain2","role":"role2"}`,
+			wantCode: http.StatusNotFound,
+			wantBody: service.ErrRoleCacheEntryNotFound.Error() + "\n",
+		},
+		{
+			name:     "empty request does not invalidate all",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+			wantBody: "domain or all is required\n",
+		},
+		{
+			name:    "invalid request body",
+			body:    `{`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewAdmin(newRoleCacheAdminMock())
+			w := httptest.NewRecorder()
+			err := h.InvalidateRoleTokenCache(w, httptest.NewRequest(http.MethodPost, "/roletoken/cache/invalidate", strings.NewReader(tt.body)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InvalidateRoleTokenCache() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if err := EqualResponse(w, tt.wantCode, nil, []byte(tt.wantBody)); err != nil {
+				t.Errorf("InvalidateRoleTokenCache() %v", err)
+			}
+		})
+	}
+}
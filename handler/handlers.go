@@ -16,14 +16,20 @@ limitations under the License.
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httputil"
+	"sync"
 
+	"github.com/kpango/glg"
 	ntokend "github.com/kpango/ntokend"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
+	"github.com/yahoojapan/athenz-client-sidecar/metrics"
 	"github.com/yahoojapan/athenz-client-sidecar/model"
 	"github.com/yahoojapan/athenz-client-sidecar/service"
 )
@@ -38,28 +44,75 @@ type Handler interface {
 	RoleToken(http.ResponseWriter, *http.Request) error
 	// RoleTokenProxy handles proxy requests that require a role token.
 	RoleTokenProxy(http.ResponseWriter, *http.Request) error
+	// AccessToken handles get access token requests.
+	AccessToken(http.ResponseWriter, *http.Request) error
+	// AccessTokenProxy handles proxy requests that require a access token.
+	AccessTokenProxy(http.ResponseWriter, *http.Request) error
+	// SvcCert handles get service certificate requests.
+	SvcCert(http.ResponseWriter, *http.Request) error
+	// RoleCert handles get role certificate requests.
+	RoleCert(http.ResponseWriter, *http.Request) error
+	// Reload replaces the proxy configuration.
+	Reload(config.Proxy)
 }
 
+const (
+	// defaultAccessAuthHeaderName represents the default HTTP header name of the access token for the proxy request.
+	defaultAccessAuthHeaderName = "Authorization"
+
+	// defaultAccessAuthScheme represents the default authorization scheme of the access token for the proxy request.
+	defaultAccessAuthScheme = "Bearer"
+
+	// staleTokenHeaderName represents the HTTP response header name to notify the returned role token is stale.
+	staleTokenHeaderName = "X-Athenz-Token-Stale"
+)
+
+var (
+	// ErrSvcCertDisabled represents an error that the service certificate is requested while it is disabled.
+	ErrSvcCertDisabled = errors.New("service certificate is disabled")
+
+	// ErrRoleCertDisabled represents an error that the role certificate is requested while it is disabled.
+	ErrRoleCertDisabled = errors.New("role certificate is disabled")
+)
+
+// proxyNameKey is the context key of the proxy handler name, which is used as the label of the proxy metrics.
+type proxyNameKey struct{}
+
 // Func is http.HandlerFunc with error return.
 type Func func(http.ResponseWriter, *http.Request) error
 
 // handler is internal implementation of Handler interface.
 type handler struct {
-	proxy *httputil.ReverseProxy
-	token ntokend.TokenProvider
-	role  service.RoleProvider
-	cfg   config.Proxy
+	proxy    *httputil.ReverseProxy
+	token    ntokend.TokenProvider
+	role     service.RoleProvider
+	access   service.AccessProvider
+	svcCert  service.SvcCertProvider
+	roleCert service.RoleCertProvider
+	authz    service.Authorizer
+	cfg      config.Proxy
+
+	// mu guards cfg which can be replaced by Reload.
+	mu sync.RWMutex
 }
 
 // New creates a handler for handling different HTTP requests based on the given services. It also contains a reverse proxy for handling proxy request.
-func New(cfg config.Proxy, bp httputil.BufferPool, token ntokend.TokenProvider, role service.RoleProvider) Handler {
+// The svcCert and roleCert providers can be nil when the service certificate or the role certificate is disabled,
+// and the authz can be nil when the token requests are not authorized by the policy.
+func New(cfg config.Proxy, bp httputil.BufferPool, token ntokend.TokenProvider, role service.RoleProvider, access service.AccessProvider, svcCert service.SvcCertProvider, roleCert service.RoleCertProvider, authz service.Authorizer) Handler {
 	return &handler{
 		proxy: &httputil.ReverseProxy{
-			BufferPool: bp,
+			BufferPool:     bp,
+			ModifyResponse: observeProxyResponse,
+			ErrorHandler:   handleProxyError,
 		},
-		token: token,
-		role:  role,
-		cfg:   cfg,
+		token:    token,
+		role:     role,
+		access:   access,
+		svcCert:  svcCert,
+		roleCert: roleCert,
+		authz:    authz,
+		cfg:      cfg,
 	}
 }
 
@@ -67,6 +120,9 @@ func New(cfg config.Proxy, bp httputil.BufferPool, token ntokend.TokenProvider,
 func (h *handler) NToken(w http.ResponseWriter, r *http.Request) error {
 	defer flushAndClose(r.Body)
 
+	if !h.authorizeNToken(w, r) {
+		return nil
+	}
 	tok, err := h.token()
 	if err != nil {
 		return err
@@ -82,12 +138,15 @@ func (h *handler) NToken(w http.ResponseWriter, r *http.Request) error {
 func (h *handler) NTokenProxy(w http.ResponseWriter, r *http.Request) error {
 	defer flushAndClose(r.Body)
 
+	if !h.authorizeNToken(w, r) {
+		return nil
+	}
 	tok, err := h.token()
 	if err != nil {
 		return err
 	}
-	r.Header.Set(h.cfg.PrincipalAuthHeaderName, tok)
-	h.proxy.ServeHTTP(w, r)
+	r.Header.Set(h.proxyConfig().PrincipalAuthHeaderName, tok)
+	h.serveProxy(w, r, "ntoken")
 	return nil
 }
 
@@ -100,10 +159,16 @@ func (h *handler) RoleToken(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	if !validateRequest(w, data) || !h.authorize(w, r, data.Domain, data.Role, data.ProxyForPrincipal) {
+		return nil
+	}
 	tok, err := h.role(r.Context(), data.Domain, data.Role, data.ProxyForPrincipal, data.MinExpiry, data.MaxExpiry)
 	if err != nil {
 		return err
 	}
+	if tok.Stale {
+		w.Header().Set(staleTokenHeaderName, "true")
+	}
 
 	w.Header().Set("Content-type", "application/json; charset=utf-8")
 	return json.NewEncoder(w).Encode(tok)
@@ -116,15 +181,221 @@ func (h *handler) RoleTokenProxy(w http.ResponseWriter, r *http.Request) error {
 	role := r.Header.Get("Athenz-Role")
 	domain := r.Header.Get("Athenz-Domain")
 	principal := r.Header.Get("Athenz-Proxy-Principal")
+	if !validateRequest(w, model.RoleRequest{Domain: domain, Role: role, ProxyForPrincipal: principal}) || !h.authorize(w, r, domain, role, principal) {
+		return nil
+	}
 	tok, err := h.role(r.Context(), domain, role, principal, 0, 0)
 	if err != nil {
 		return err
 	}
-	r.Header.Set(h.cfg.RoleAuthHeaderName, tok.Token)
-	h.proxy.ServeHTTP(w, r)
+	if tok.Stale {
+		w.Header().Set(staleTokenHeaderName, "true")
+	}
+	r.Header.Set(h.proxyConfig().RoleAuthHeaderName, tok.Token)
+	h.serveProxy(w, r, "roletoken")
+	return nil
+}
+
+// AccessToken handles access token requests and responses the corresponding access token. Depends on access token service.
+func (h *handler) AccessToken(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	var data model.AccessRequest
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return err
+	}
+	if !validateRequest(w, data) || !h.authorize(w, r, data.Domain, data.Role, data.ProxyForPrincipal) {
+		return nil
+	}
+	tok, err := h.access(r.Context(), data.Domain, data.Role, data.ProxyForPrincipal, data.Expiry)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(tok)
+}
+
+// AccessTokenProxy attaches access token to HTTP requests and proxies it. Depends on access token service.
+func (h *handler) AccessTokenProxy(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	role := r.Header.Get("Athenz-Role")
+	domain := r.Header.Get("Athenz-Domain")
+	principal := r.Header.Get("Athenz-Proxy-Principal")
+	if !validateRequest(w, model.AccessRequest{Domain: domain, Role: role, ProxyForPrincipal: principal}) || !h.authorize(w, r, domain, role, principal) {
+		return nil
+	}
+	tok, err := h.access(r.Context(), domain, role, principal, 0)
+	if err != nil {
+		return err
+	}
+
+	cfg := h.proxyConfig()
+	header := cfg.AccessAuthHeaderName
+	if header == "" {
+		header = defaultAccessAuthHeaderName
+	}
+	scheme := cfg.AccessAuthScheme
+	if scheme == "" {
+		scheme = defaultAccessAuthScheme
+	}
+	r.Header.Set(header, scheme+" "+tok.AccessToken)
+	h.serveProxy(w, r, "accesstoken")
+	return nil
+}
+
+// SvcCert handles service certificate requests and responses the service certificate and its CA certificate chain. Depends on service certificate service.
+func (h *handler) SvcCert(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	if h.svcCert == nil {
+		return ErrSvcCertDisabled
+	}
+	if !h.authorizeSvcCert(w, r) {
+		return nil
+	}
+
+	cert, err := h.svcCert(r.Context())
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(cert)
+}
+
+// RoleCert handles role certificate requests and responses the corresponding role certificate. Depends on role certificate service.
+func (h *handler) RoleCert(w http.ResponseWriter, r *http.Request) error {
+	defer flushAndClose(r.Body)
+
+	if h.roleCert == nil {
+		return ErrRoleCertDisabled
+	}
+
+	var data model.RoleCertRequest
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return err
+	}
+	if !validateRequest(w, data) || !h.authorize(w, r, data.Domain, data.Role, data.ProxyForPrincipal) {
+		return nil
+	}
+	cert, err := h.roleCert(r.Context(), data.Domain, data.Role, data.ProxyForPrincipal, data.Expiry)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(cert)
+}
+
+// Reload replaces the proxy configuration, e.g. the header names of the proxy requests.
+// The buffer size of the proxy cannot be changed without restart.
+func (h *handler) Reload(cfg config.Proxy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cfg = cfg
+}
+
+// proxyConfig returns the current proxy configuration.
+func (h *handler) proxyConfig() config.Proxy {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.cfg
+}
+
+// serveProxy proxies the request to the destination, and records the response status code as the proxy metrics named by name.
+func (h *handler) serveProxy(w http.ResponseWriter, r *http.Request, name string) {
+	h.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), proxyNameKey{}, name)))
+}
+
+// observeProxyResponse records the status code returned from the proxy destination.
+func observeProxyResponse(res *http.Response) error {
+	if name, ok := res.Request.Context().Value(proxyNameKey{}).(string); ok {
+		metrics.ObserveProxyResponse(name, res.StatusCode)
+	}
 	return nil
 }
 
+// handleProxyError records the proxy error, and responses HTTP Status Bad Gateway (502) like the default behavior of httputil.ReverseProxy.
+func handleProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if name, ok := r.Context().Value(proxyNameKey{}).(string); ok {
+		metrics.ObserveProxyResponse(name, 0)
+	}
+	glg.Errorf("http: proxy error: %v", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// validator represent the request which can be validated.
+type validator interface {
+	Validate() error
+}
+
+// validateRequest responses HTTP Status Bad Request (400) with the reason and returns false if req is invalid.
+func validateRequest(w http.ResponseWriter, req validator) bool {
+	if err := req.Validate(); err != nil {
+		glg.Debugf("invalid request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// authorize responses HTTP Status Forbidden (403) and returns false if the client is not allowed to request the role token, the access token or the role certificate by the policy.
+func (h *handler) authorize(w http.ResponseWriter, r *http.Request, domain, role, proxyForPrincipal string) bool {
+	if h.authz == nil {
+		return true
+	}
+	return allowed(w, r, h.authz.AuthorizeRole(newClient(r), domain, role, proxyForPrincipal))
+}
+
+// authorizeNToken responses HTTP Status Forbidden (403) and returns false if the client is not allowed to request the n-token by the policy.
+func (h *handler) authorizeNToken(w http.ResponseWriter, r *http.Request) bool {
+	if h.authz == nil {
+		return true
+	}
+	return allowed(w, r, h.authz.AuthorizeNToken(newClient(r)))
+}
+
+// authorizeSvcCert responses HTTP Status Forbidden (403) and returns false if the client is not allowed to request the service certificate by the policy.
+func (h *handler) authorizeSvcCert(w http.ResponseWriter, r *http.Request) bool {
+	if h.authz == nil {
+		return true
+	}
+	return allowed(w, r, h.authz.AuthorizeSvcCert(newClient(r)))
+}
+
+// allowed responses HTTP Status Forbidden (403) and returns false if the authorization error is not nil.
+func allowed(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err != nil {
+		glg.Warnf("%s request is denied: %v", r.URL.Path, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// newClient returns the identity of the client sending r.
+func newClient(r *http.Request) *service.Client {
+	c := new(service.Client)
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	c.IP = net.ParseIP(host)
+	c.Cred = service.PeerCredFromContext(r.Context())
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cert := r.TLS.VerifiedChains[0][0]
+		if cert.Subject.CommonName != "" {
+			c.Names = append(c.Names, cert.Subject.CommonName)
+		}
+		c.Names = append(c.Names, cert.DNSNames...)
+	}
+	return c
+}
+
 // flushAndClose helps to flush and close a ReadCloser. Used for request body internal.
 // Returns if there is any errors.
 func flushAndClose(rc io.ReadCloser) error {
@@ -20,9 +20,13 @@ import (
 
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
@@ -33,6 +37,7 @@ import (
 	ntokend "github.com/kpango/ntokend"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
 	"github.com/yahoojapan/athenz-client-sidecar/infra"
+	"github.com/yahoojapan/athenz-client-sidecar/metrics"
 	"github.com/yahoojapan/athenz-client-sidecar/service"
 )
 
@@ -77,10 +82,14 @@ func EqualResponse(writer http.ResponseWriter, code int, header map[string]strin
 
 func TestNew(t *testing.T) {
 	type args struct {
-		cfg   config.Proxy
-		bp    httputil.BufferPool
-		token ntokend.TokenProvider
-		role  service.RoleProvider
+		cfg      config.Proxy
+		bp       httputil.BufferPool
+		token    ntokend.TokenProvider
+		role     service.RoleProvider
+		access   service.AccessProvider
+		svcCert  service.SvcCertProvider
+		roleCert service.RoleCertProvider
+		authz    service.Authorizer
 	}
 	type testcase struct {
 		name      string
@@ -106,6 +115,29 @@ func TestNew(t *testing.T) {
 						ExpiryTime: 90,
 					}, fmt.Errorf("get-role-token-error-91")
 				},
+				access: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+					return &service.AccessTokenResponse{
+						AccessToken: "access-token-97",
+						ExpiresIn:   98,
+					}, fmt.Errorf("get-access-token-error-99")
+				},
+				svcCert: func(ctx context.Context) (*service.SvcCert, error) {
+					return &service.SvcCert{
+						Cert:       "cert-105",
+						ExpiryTime: 106,
+					}, fmt.Errorf("get-svc-cert-error-107")
+				},
+				roleCert: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiry int64) (*service.RoleCert, error) {
+					return &service.RoleCert{
+						Cert:       "role-cert-113",
+						ExpiryTime: 114,
+					}, fmt.Errorf("get-role-cert-error-115")
+				},
+				authz: &authorizerMock{
+					role: func(c *service.Client, domain, role, proxyForPrincipal string) error {
+						return fmt.Errorf("authz-error-121")
+					},
+				},
 			},
 			want: &handler{
 				cfg: config.Proxy{
@@ -143,6 +175,52 @@ func TestNew(t *testing.T) {
 					return &NotEqualError{"role() err", gotError, wantError}
 				}
 
+				// access
+				gotAccessToken, gotError := got.access(nil, "", "", "", 0)
+				wantAccessToken, wantError := &service.AccessTokenResponse{
+					AccessToken: "access-token-97",
+					ExpiresIn:   98,
+				}, fmt.Errorf("get-access-token-error-99")
+				if !reflect.DeepEqual(gotAccessToken, wantAccessToken) {
+					return &NotEqualError{"access()", gotAccessToken, wantAccessToken}
+				}
+				if !reflect.DeepEqual(gotError, wantError) {
+					return &NotEqualError{"access() err", gotError, wantError}
+				}
+
+				// svcCert
+				gotSvcCert, gotError := got.svcCert(nil)
+				wantSvcCert, wantError := &service.SvcCert{
+					Cert:       "cert-105",
+					ExpiryTime: 106,
+				}, fmt.Errorf("get-svc-cert-error-107")
+				if !reflect.DeepEqual(gotSvcCert, wantSvcCert) {
+					return &NotEqualError{"svcCert()", gotSvcCert, wantSvcCert}
+				}
+				if !reflect.DeepEqual(gotError, wantError) {
+					return &NotEqualError{"svcCert() err", gotError, wantError}
+				}
+
+				// roleCert
+				gotRoleCert, gotError := got.roleCert(nil, "", "", "", 0)
+				wantRoleCert, wantError := &service.RoleCert{
+					Cert:       "role-cert-113",
+					ExpiryTime: 114,
+				}, fmt.Errorf("get-role-cert-error-115")
+				if !reflect.DeepEqual(gotRoleCert, wantRoleCert) {
+					return &NotEqualError{"roleCert()", gotRoleCert, wantRoleCert}
+				}
+				if !reflect.DeepEqual(gotError, wantError) {
+					return &NotEqualError{"roleCert() err", gotError, wantError}
+				}
+
+				// authz
+				gotError = got.authz.AuthorizeRole(nil, "", "", "")
+				wantError = fmt.Errorf("authz-error-121")
+				if !reflect.DeepEqual(gotError, wantError) {
+					return &NotEqualError{"authz() err", gotError, wantError}
+				}
+
 				return nil
 			},
 		},
@@ -150,7 +228,7 @@ func TestNew(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := New(tt.args.cfg, tt.args.bp, tt.args.token, tt.args.role)
+			got := New(tt.args.cfg, tt.args.bp, tt.args.token, tt.args.role, tt.args.access, tt.args.svcCert, tt.args.roleCert, tt.args.authz)
 			if err := tt.checkFunc(got.(*handler), tt.want); err != nil {
 				t.Errorf("New() %v", err)
 				return
@@ -162,6 +240,7 @@ func TestNew(t *testing.T) {
 func Test_handler_NToken(t *testing.T) {
 	type fields struct {
 		token ntokend.TokenProvider
+		authz service.Authorizer
 	}
 	type args struct {
 		w http.ResponseWriter
@@ -234,6 +313,57 @@ func Test_handler_NToken(t *testing.T) {
 				body:   []byte(`{"token":"token-247"}` + "\n"),
 			},
 		},
+		{
+			name: "Check handler NToken, denied by policy",
+			fields: fields{
+				token: func() (string, error) {
+					return "", fmt.Errorf("n-token must not be requested")
+				},
+				authz: &authorizerMock{
+					ntoken: func(c *service.Client) error {
+						if c.Cred == nil || c.Cred.UID != 1000 || c.Cred.GID != 1001 {
+							return fmt.Errorf("unexpected client: %v", c)
+						}
+						return service.ErrPolicyDenied
+					},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-272", nil).WithContext(
+					service.WithPeerCred(context.Background(), &service.PeerCred{UID: 1000, GID: 1001, PID: 272}),
+				),
+			},
+			want: want{
+				code:   http.StatusForbidden,
+				header: map[string]string{},
+				body:   []byte(service.ErrPolicyDenied.Error() + "\n"),
+			},
+		},
+		{
+			name: "Check handler NToken, allowed by policy",
+			fields: fields{
+				token: func() (string, error) {
+					return "token-291", nil
+				},
+				authz: &authorizerMock{
+					ntoken: func(c *service.Client) error {
+						return nil
+					},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-296", nil),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"token":"token-291"}` + "\n"),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -242,6 +372,7 @@ func Test_handler_NToken(t *testing.T) {
 			var err error
 			h := &handler{
 				token: tt.fields.token,
+				authz: tt.fields.authz,
 			}
 
 			gotError := h.NToken(tt.args.w, tt.args.r)
@@ -478,6 +609,24 @@ func Test_handler_NTokenProxy(t *testing.T) {
 }
 
 // readCloserMock is the adapter implementation of io.ReadCloser interface for mocking.
+type authorizerMock struct {
+	ntoken  func(c *service.Client) error
+	svcCert func(c *service.Client) error
+	role    func(c *service.Client, domain, role, proxyForPrincipal string) error
+}
+
+func (a *authorizerMock) AuthorizeNToken(c *service.Client) error {
+	return a.ntoken(c)
+}
+
+func (a *authorizerMock) AuthorizeSvcCert(c *service.Client) error {
+	return a.svcCert(c)
+}
+
+func (a *authorizerMock) AuthorizeRole(c *service.Client, domain, role, proxyForPrincipal string) error {
+	return a.role(c, domain, role, proxyForPrincipal)
+}
+
 type readCloserMock struct {
 	readMock  func(p []byte) (n int, err error)
 	closeMock func() error
@@ -495,7 +644,8 @@ func (r *readCloserMock) Close() error {
 
 func Test_handler_RoleToken(t *testing.T) {
 	type fields struct {
-		role service.RoleProvider
+		role  service.RoleProvider
+		authz service.Authorizer
 	}
 	type args struct {
 		w http.ResponseWriter
@@ -540,7 +690,7 @@ func Test_handler_RoleToken(t *testing.T) {
 			},
 			args: args{
 				w: httptest.NewRecorder(),
-				r: httptest.NewRequest(http.MethodGet, "http://url-576", strings.NewReader(`{}`)),
+				r: httptest.NewRequest(http.MethodGet, "http://url-576", strings.NewReader(`{"domain":"domain"}`)),
 			},
 			want: want{
 				code:   http.StatusOK,
@@ -576,7 +726,7 @@ func Test_handler_RoleToken(t *testing.T) {
 					ctx, cancel := context.WithCancel(context.Background())
 					defer cancel()
 
-					request := httptest.NewRequest(http.MethodGet, "http://url-612", strings.NewReader(`{}`))
+					request := httptest.NewRequest(http.MethodGet, "http://url-612", strings.NewReader(`{"domain":"domain"}`))
 					return request.WithContext(ctx)
 				}(),
 			},
@@ -587,6 +737,75 @@ func Test_handler_RoleToken(t *testing.T) {
 			},
 			wantError: context.Canceled,
 		},
+		{
+			name: "Check handler RoleToken, invalid domain",
+			fields: fields{
+				role: func(ctx context.Context, domain string, role string, proxyForPrincipal string, minExpiry int64, maxExpiry int64) (roleToken *service.RoleToken, err error) {
+					return nil, fmt.Errorf("role token must not be requested")
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-651", strings.NewReader(`{"domain":"../admin"}`)),
+			},
+			want: want{
+				code:   http.StatusBadRequest,
+				header: map[string]string{},
+				body:   []byte(`domain "../admin" must consist of [a-zA-Z0-9_-] separated by ".": invalid name` + "\n"),
+			},
+		},
+		{
+			name: "Check handler RoleToken, denied by policy",
+			fields: fields{
+				role: func(ctx context.Context, domain string, role string, proxyForPrincipal string, minExpiry int64, maxExpiry int64) (roleToken *service.RoleToken, err error) {
+					return nil, fmt.Errorf("role token must not be requested")
+				},
+				authz: &authorizerMock{
+					role: func(c *service.Client, domain, role, proxyForPrincipal string) error {
+						if c.IP.String() != "192.0.2.1" || domain != "domain" || role != "admin" {
+							return fmt.Errorf("unexpected client: %v, domain: %s, role: %s", c, domain, role)
+						}
+						return service.ErrPolicyDenied
+					},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-669", strings.NewReader(`{"domain":"domain","role":"admin"}`)),
+			},
+			want: want{
+				code:   http.StatusForbidden,
+				header: map[string]string{},
+				body:   []byte(service.ErrPolicyDenied.Error() + "\n"),
+			},
+		},
+		{
+			name: "Check handler RoleToken, allowed by policy",
+			fields: fields{
+				role: func(ctx context.Context, domain string, role string, proxyForPrincipal string, minExpiry int64, maxExpiry int64) (roleToken *service.RoleToken, err error) {
+					return &service.RoleToken{
+						Token:      "role-token-691",
+						ExpiryTime: 692,
+					}, nil
+				},
+				authz: &authorizerMock{
+					role: func(c *service.Client, domain, role, proxyForPrincipal string) error {
+						return nil
+					},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-697", strings.NewReader(`{"domain":"domain","role":"users"}`)),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"token":"role-token-691","expiryTime":692}` + "\n"),
+			},
+		},
 		{
 			name: "Check handler RoleToken, request got role token",
 			fields: fields{
@@ -615,6 +834,30 @@ func Test_handler_RoleToken(t *testing.T) {
 				body: []byte(`{"token":"role-token-629","expiryTime":630}` + "\n"),
 			},
 		},
+		{
+			name: "Check handler RoleToken, request got stale role token",
+			fields: fields{
+				role: func(ctx context.Context, domain string, role string, proxyForPrincipal string, minExpiry int64, maxExpiry int64) (roleToken *service.RoleToken, err error) {
+					return &service.RoleToken{
+						Token:      "role-token-680",
+						ExpiryTime: 681,
+						Stale:      true,
+					}, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-687", strings.NewReader(`{"domain":"domain"}`)),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type":         "application/json; charset=utf-8",
+					"X-Athenz-Token-Stale": "true",
+				},
+				body: []byte(`{"token":"role-token-680","expiryTime":681,"stale":true}` + "\n"),
+			},
+		},
 		func() testcase {
 			requestClosed := false
 			return testcase{
@@ -652,7 +895,8 @@ func Test_handler_RoleToken(t *testing.T) {
 
 			var err error
 			h := &handler{
-				role: tt.fields.role,
+				role:  tt.fields.role,
+				authz: tt.fields.authz,
 			}
 
 			gotError := h.RoleToken(tt.args.w, tt.args.r)
@@ -719,7 +963,11 @@ func Test_handler_RoleTokenProxy(t *testing.T) {
 			},
 			args: args{
 				w: httptest.NewRecorder(),
-				r: httptest.NewRequest(http.MethodGet, "http://url-754", nil),
+				r: func() *http.Request {
+					request := httptest.NewRequest(http.MethodGet, "http://url-754", nil)
+					request.Header.Set("Athenz-Domain", "athenz-domain-754")
+					return request
+				}(),
 			},
 			want: want{
 				code:   http.StatusOK,
@@ -756,6 +1004,7 @@ func Test_handler_RoleTokenProxy(t *testing.T) {
 					defer cancel()
 
 					request := httptest.NewRequest(http.MethodGet, "http://url-790", strings.NewReader(`{}`))
+					request.Header.Set("Athenz-Domain", "athenz-domain-790")
 					return request.WithContext(ctx)
 				}(),
 			},
@@ -885,6 +1134,28 @@ func Test_handler_RoleTokenProxy(t *testing.T) {
 				body: []byte(`proxied-877-body-900`),
 			},
 		},
+		{
+			name: "Check handler RoleTokenProxy, invalid role header",
+			fields: fields{
+				role: func(ctx context.Context, domain string, role string, proxyForPrincipal string, minExpiry int64, maxExpiry int64) (*service.RoleToken, error) {
+					return nil, fmt.Errorf("role token must not be requested")
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: func() *http.Request {
+					request := httptest.NewRequest(http.MethodGet, "http://url-940", nil)
+					request.Header.Set("Athenz-Domain", "domain")
+					request.Header.Set("Athenz-Role", "role?x=1")
+					return request
+				}(),
+			},
+			want: want{
+				code:   http.StatusBadRequest,
+				header: map[string]string{},
+				body:   []byte(`role "role?x=1" must consist of [a-zA-Z0-9_-] separated by ".": invalid name` + "\n"),
+			},
+		},
 		{
 			name: "Check handler RoleTokenProxy, request body closed",
 			fields: fields{
@@ -914,7 +1185,11 @@ func Test_handler_RoleTokenProxy(t *testing.T) {
 			},
 			args: args{
 				w: httptest.NewRecorder(),
-				r: httptest.NewRequest(http.MethodPost, "http://url-949", bytes.NewReader([]byte("body-949"))),
+				r: func() *http.Request {
+					request := httptest.NewRequest(http.MethodPost, "http://url-949", bytes.NewReader([]byte("body-949")))
+					request.Header.Set("Athenz-Domain", "athenz-domain-949")
+					return request
+				}(),
 			},
 			want: want{
 				code: http.StatusOK,
@@ -962,87 +1237,840 @@ func Test_handler_RoleTokenProxy(t *testing.T) {
 	}
 }
 
-func Test_flushAndClose(t *testing.T) {
+func Test_handler_AccessToken(t *testing.T) {
+	type fields struct {
+		access service.AccessProvider
+		authz  service.Authorizer
+	}
 	type args struct {
-		readCloser io.ReadCloser
+		w http.ResponseWriter
+		r *http.Request
+	}
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
 	}
 	type testcase struct {
 		name      string
+		fields    fields
 		args      args
+		want      want
 		wantError error
 	}
 	tests := []testcase{
 		{
-			name: "Check flushAndClose, readCloser is nil",
+			name:   "Check handler AccessToken, on decode request body error",
+			fields: fields{},
 			args: args{
-				readCloser: nil,
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1000", strings.NewReader("body-1000")),
 			},
-			wantError: nil,
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("invalid character 'b' looking for beginning of value"),
 		},
 		{
-			name: "Check flushAndClose, flush & close success",
-			args: args{
-				readCloser: &readCloserMock{
-					readMock: func(p []byte) (n int, err error) {
-						return 0, io.EOF
-					},
-					closeMock: func() error {
-						return nil
-					},
+			name: "Check handler AccessToken, on access error",
+			fields: fields{
+				access: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+					return nil, fmt.Errorf("get-access-token-error-1016")
 				},
 			},
-			wantError: nil,
-		},
-		{
-			name: "Check flushAndClose, flush fail",
 			args: args{
-				readCloser: &readCloserMock{
-					readMock: func(p []byte) (n int, err error) {
-						return 0, fmt.Errorf("read-error-1332")
-					},
-					closeMock: func() error {
-						return nil
-					},
-				},
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1021", strings.NewReader(`{"domain":"domain"}`)),
 			},
-			wantError: fmt.Errorf("read-error-1332"),
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("get-access-token-error-1016"),
 		},
 		{
-			name: "Check flushAndClose, close fail",
+			name: "Check handler AccessToken, request got access token",
+			fields: fields{
+				access: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+					if domain != "domain-1044" || role != "role-1045" || proxyForPrincipal != "proxy_for_principal-1046" || expiresIn != 1047 {
+						return nil, fmt.Errorf("unexpected arguments: %v, %v, %v, %v", domain, role, proxyForPrincipal, expiresIn)
+					}
+					return &service.AccessTokenResponse{
+						AccessToken: "access-token-1036",
+						TokenType:   "Bearer",
+						ExpiresIn:   1038,
+						Scope:       "domain-1044:role.role-1045",
+					}, nil
+				},
+			},
 			args: args{
-				readCloser: &readCloserMock{
-					readMock: func(p []byte) (n int, err error) {
-						return 0, io.EOF
-					},
-					closeMock: func() error {
-						return fmt.Errorf("close-error-1349")
-					},
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1043", strings.NewReader(`{
+					"domain":"domain-1044",
+					"role":"role-1045",
+					"proxy_for_principal":"proxy_for_principal-1046",
+					"expiry": 1047
+				}`)),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
 				},
+				body: []byte(`{"access_token":"access-token-1036","token_type":"Bearer","expires_in":1038,"scope":"domain-1044:role.role-1045"}` + "\n"),
 			},
-			wantError: fmt.Errorf("close-error-1349"),
 		},
 		{
-			name: "Check flushAndClose, flush & close fail",
-			args: args{
-				readCloser: &readCloserMock{
-					readMock: func(p []byte) (n int, err error) {
-						return 0, fmt.Errorf("read-error-1360")
-					},
-					closeMock: func() error {
-						return fmt.Errorf("close-error-1363")
+			name: "Check handler AccessToken, denied by policy",
+			fields: fields{
+				access: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+					return nil, fmt.Errorf("access token must not be requested")
+				},
+				authz: &authorizerMock{
+					role: func(c *service.Client, domain, role, proxyForPrincipal string) error {
+						if domain != "domain-1066" || role != "role-1066" {
+							return fmt.Errorf("unexpected domain: %s, role: %s", domain, role)
+						}
+						return service.ErrPolicyDenied
 					},
 				},
 			},
-			wantError: fmt.Errorf("read-error-1360"),
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1066", strings.NewReader(`{"domain":"domain-1066","role":"role-1066"}`)),
+			},
+			want: want{
+				code:   http.StatusForbidden,
+				header: map[string]string{},
+				body:   []byte(service.ErrPolicyDenied.Error() + "\n"),
+			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			gotError := flushAndClose(tt.args.readCloser)
+
+			var err error
+			h := &handler{
+				access: tt.fields.access,
+				authz:  tt.fields.authz,
+			}
+
+			gotError := h.AccessToken(tt.args.w, tt.args.r)
 			if !reflect.DeepEqual(gotError, tt.wantError) {
-				t.Errorf("flushAndClose() error = %v, want %v", gotError, tt.wantError)
+				if gotError == nil || tt.wantError == nil || gotError.Error() != tt.wantError.Error() {
+					err = &NotEqualError{"error", gotError, tt.wantError}
+				}
 			}
-		})
+			if err != nil {
+				t.Errorf("handler.AccessToken() %v", err)
+				return
+			}
+
+			err = EqualResponse(tt.args.w, tt.want.code, tt.want.header, tt.want.body)
+			if err != nil {
+				t.Errorf("handler.AccessToken() %v", err)
+				return
+			}
+
+			// check if the response's body is closed
+			if tt.args.r.Body != nil {
+				byteRead, err := tt.args.r.Body.Read(make([]byte, 64))
+				if byteRead != 0 || err != io.EOF {
+					t.Errorf("handler.AccessToken() request not closed, %v bytes read, err %v", byteRead, err)
+					return
+				}
+			}
+		})
+	}
+}
+
+func Test_handler_AccessTokenProxy(t *testing.T) {
+	type fields struct {
+		proxy  *httputil.ReverseProxy
+		access service.AccessProvider
+		cfg    config.Proxy
+	}
+	type args struct {
+		w http.ResponseWriter
+		r *http.Request
+	}
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		fields    fields
+		args      args
+		want      want
+		wantError error
+	}
+
+	// mock proxy, mirror header, prepends prefix to response
+	mirrorProxy := func(prefix string) *httputil.ReverseProxy {
+		return &httputil.ReverseProxy{
+			Director: func(*http.Request) {},
+			Transport: &roundTripperMock{
+				roundTripMock: func(request *http.Request) (response *http.Response, err error) {
+					var reqBody []byte
+					if request.Body != nil {
+						reqBody, err = ioutil.ReadAll(request.Body)
+					}
+					if err != nil {
+						return nil, err
+					}
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Header:     request.Header,
+						Body:       ioutil.NopCloser(strings.NewReader(prefix + "-" + string(reqBody))),
+					}, nil
+				},
+			},
+		}
+	}
+	newRequest := func(body io.Reader) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "http://url-1140", body)
+		request.Header.Set("Athenz-Role", "athenz-role-1141")
+		request.Header.Set("Athenz-Domain", "athenz-domain-1142")
+		request.Header.Set("Athenz-Proxy-Principal", "athenz-proxy-principal-1143")
+		return request
+	}
+	access := func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+		return &service.AccessTokenResponse{
+			AccessToken: strings.Join([]string{
+				"access-token-1148",
+				domain,
+				role,
+				proxyForPrincipal,
+			}, "-"),
+			TokenType: "Bearer",
+			ExpiresIn: 1154,
+		}, nil
+	}
+	wantToken := strings.Join([]string{
+		"access-token-1148",
+		"athenz-domain-1142",
+		"athenz-role-1141",
+		"athenz-proxy-principal-1143",
+	}, "-")
+
+	tests := []testcase{
+		{
+			name: "Check handler AccessTokenProxy, on access error",
+			fields: fields{
+				access: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+					return nil, fmt.Errorf("get-access-token-error-1169")
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: newRequest(nil),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("get-access-token-error-1169"),
+		},
+		{
+			name: "Check handler AccessTokenProxy, request got access token with default header and proxied",
+			fields: fields{
+				proxy:  mirrorProxy("proxied-1186"),
+				access: access,
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: newRequest(strings.NewReader("body-1191")),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Authorization": "Bearer " + wantToken,
+				},
+				body: []byte(`proxied-1186-body-1191`),
+			},
+		},
+		{
+			name: "Check handler AccessTokenProxy, request got access token with configured header and proxied",
+			fields: fields{
+				proxy:  mirrorProxy("proxied-1204"),
+				access: access,
+				cfg: config.Proxy{
+					AccessAuthHeaderName: "access-header-1207",
+					AccessAuthScheme:     "scheme-1208",
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: newRequest(nil),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"access-header-1207": "scheme-1208 " + wantToken,
+				},
+				body: []byte(`proxied-1204-`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var err error
+			h := &handler{
+				proxy:  tt.fields.proxy,
+				access: tt.fields.access,
+				cfg:    tt.fields.cfg,
+			}
+
+			gotError := h.AccessTokenProxy(tt.args.w, tt.args.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				err = &NotEqualError{"error", gotError, tt.wantError}
+			}
+			if err != nil {
+				t.Errorf("handler.AccessTokenProxy() %v", err)
+				return
+			}
+
+			err = EqualResponse(tt.args.w, tt.want.code, tt.want.header, tt.want.body)
+			if err != nil {
+				t.Errorf("handler.AccessTokenProxy() %v", err)
+				return
+			}
+		})
+	}
+}
+
+func Test_handler_SvcCert(t *testing.T) {
+	type fields struct {
+		svcCert service.SvcCertProvider
+		authz   service.Authorizer
+	}
+	type args struct {
+		w http.ResponseWriter
+		r *http.Request
+	}
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		fields    fields
+		args      args
+		want      want
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name:   "Check handler SvcCert, service certificate disabled",
+			fields: fields{},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-1302", nil),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: ErrSvcCertDisabled,
+		},
+		{
+			name: "Check handler SvcCert, on svcCert error",
+			fields: fields{
+				svcCert: func(ctx context.Context) (*service.SvcCert, error) {
+					return nil, fmt.Errorf("get-svc-cert-error-1315")
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-1320", nil),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("get-svc-cert-error-1315"),
+		},
+		{
+			name: "Check handler SvcCert, request got service certificate",
+			fields: fields{
+				svcCert: func(ctx context.Context) (*service.SvcCert, error) {
+					return &service.SvcCert{
+						Cert:       "cert-1334",
+						Chain:      "chain-1335",
+						ExpiryTime: 1336,
+					}, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-1342", nil),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"cert":"cert-1334","chain":"chain-1335","expiryTime":1336}` + "\n"),
+			},
+		},
+		{
+			name: "Check handler SvcCert, denied by policy",
+			fields: fields{
+				svcCert: func(ctx context.Context) (*service.SvcCert, error) {
+					return nil, fmt.Errorf("service certificate must not be requested")
+				},
+				authz: &authorizerMock{
+					svcCert: func(c *service.Client) error {
+						if c.Cred == nil || c.Cred.UID != 1000 {
+							return fmt.Errorf("unexpected client: %v", c)
+						}
+						return service.ErrPolicyDenied
+					},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodGet, "http://url-1360", nil).WithContext(
+					service.WithPeerCred(context.Background(), &service.PeerCred{UID: 1000, GID: 1000, PID: 1360}),
+				),
+			},
+			want: want{
+				code:   http.StatusForbidden,
+				header: map[string]string{},
+				body:   []byte(service.ErrPolicyDenied.Error() + "\n"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var err error
+			h := &handler{
+				svcCert: tt.fields.svcCert,
+				authz:   tt.fields.authz,
+			}
+
+			gotError := h.SvcCert(tt.args.w, tt.args.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				if gotError == nil || tt.wantError == nil || gotError.Error() != tt.wantError.Error() {
+					err = &NotEqualError{"error", gotError, tt.wantError}
+				}
+			}
+			if err != nil {
+				t.Errorf("handler.SvcCert() %v", err)
+				return
+			}
+
+			err = EqualResponse(tt.args.w, tt.want.code, tt.want.header, tt.want.body)
+			if err != nil {
+				t.Errorf("handler.SvcCert() %v", err)
+				return
+			}
+		})
+	}
+}
+
+func Test_handler_RoleCert(t *testing.T) {
+	type fields struct {
+		roleCert service.RoleCertProvider
+		authz    service.Authorizer
+	}
+	type args struct {
+		w http.ResponseWriter
+		r *http.Request
+	}
+	type want struct {
+		code   int
+		header map[string]string
+		body   []byte
+	}
+	type testcase struct {
+		name      string
+		fields    fields
+		args      args
+		want      want
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name:   "Check handler RoleCert, role certificate disabled",
+			fields: fields{},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1402", strings.NewReader(`{}`)),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: ErrRoleCertDisabled,
+		},
+		{
+			name: "Check handler RoleCert, on decode request body error",
+			fields: fields{
+				roleCert: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiry int64) (*service.RoleCert, error) {
+					return nil, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1420", strings.NewReader("body-1420")),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("invalid character 'b' looking for beginning of value"),
+		},
+		{
+			name: "Check handler RoleCert, on roleCert error",
+			fields: fields{
+				roleCert: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiry int64) (*service.RoleCert, error) {
+					return nil, fmt.Errorf("get-role-cert-error-1433")
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1438", strings.NewReader(`{"domain":"domain","role":"role"}`)),
+			},
+			want: want{
+				code:   http.StatusOK,
+				header: map[string]string{},
+				body:   []byte{},
+			},
+			wantError: fmt.Errorf("get-role-cert-error-1433"),
+		},
+		{
+			name: "Check handler RoleCert, request got role certificate",
+			fields: fields{
+				roleCert: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiry int64) (*service.RoleCert, error) {
+					if domain != "domain-1462" || role != "role-1463" || proxyForPrincipal != "proxy_for_principal-1464" || expiry != 1465 {
+						return nil, fmt.Errorf("unexpected arguments: %v, %v, %v, %v", domain, role, proxyForPrincipal, expiry)
+					}
+					return &service.RoleCert{
+						Cert:       "role-cert-1455",
+						ExpiryTime: 1456,
+					}, nil
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1461", strings.NewReader(`{
+					"domain":"domain-1462",
+					"role":"role-1463",
+					"proxy_for_principal":"proxy_for_principal-1464",
+					"expiry": 1465
+				}`)),
+			},
+			want: want{
+				code: http.StatusOK,
+				header: map[string]string{
+					"Content-type": "application/json; charset=utf-8",
+				},
+				body: []byte(`{"cert":"role-cert-1455","expiryTime":1456}` + "\n"),
+			},
+		},
+		{
+			name: "Check handler RoleCert, denied by policy",
+			fields: fields{
+				roleCert: func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiry int64) (*service.RoleCert, error) {
+					return nil, fmt.Errorf("role certificate must not be requested")
+				},
+				authz: &authorizerMock{
+					role: func(c *service.Client, domain, role, proxyForPrincipal string) error {
+						if domain != "domain-1480" || role != "role-1480" || proxyForPrincipal != "user.alice" {
+							return fmt.Errorf("unexpected domain: %s, role: %s, proxyForPrincipal: %s", domain, role, proxyForPrincipal)
+						}
+						return service.ErrPolicyDenied
+					},
+				},
+			},
+			args: args{
+				w: httptest.NewRecorder(),
+				r: httptest.NewRequest(http.MethodPost, "http://url-1480", strings.NewReader(`{"domain":"domain-1480","role":"role-1480","proxy_for_principal":"user.alice"}`)),
+			},
+			want: want{
+				code:   http.StatusForbidden,
+				header: map[string]string{},
+				body:   []byte(service.ErrPolicyDenied.Error() + "\n"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var err error
+			h := &handler{
+				roleCert: tt.fields.roleCert,
+				authz:    tt.fields.authz,
+			}
+
+			gotError := h.RoleCert(tt.args.w, tt.args.r)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				if gotError == nil || tt.wantError == nil || gotError.Error() != tt.wantError.Error() {
+					err = &NotEqualError{"error", gotError, tt.wantError}
+				}
+			}
+			if err != nil {
+				t.Errorf("handler.RoleCert() %v", err)
+				return
+			}
+
+			err = EqualResponse(tt.args.w, tt.want.code, tt.want.header, tt.want.body)
+			if err != nil {
+				t.Errorf("handler.RoleCert() %v", err)
+				return
+			}
+
+			// check if the response's body is closed
+			if tt.args.r.Body != nil {
+				byteRead, err := tt.args.r.Body.Read(make([]byte, 64))
+				if byteRead != 0 || err != io.EOF {
+					t.Errorf("handler.RoleCert() request not closed, %v bytes read, err %v", byteRead, err)
+					return
+				}
+			}
+		})
+	}
+}
+
+func Test_handler_Reload(t *testing.T) {
+	h := &handler{
+		cfg: config.Proxy{
+			PrincipalAuthHeaderName: "principal-header-1",
+			RoleAuthHeaderName:      "role-header-1",
+		},
+		token: func() (string, error) {
+			return "ntoken", nil
+		},
+		proxy: &httputil.ReverseProxy{
+			Director: func(*http.Request) {},
+			Transport: &roundTripperMock{
+				roundTripMock: func(request *http.Request) (response *http.Response, err error) {
+					return &http.Response{
+						StatusCode: http.StatusOK,
+						Body:       ioutil.NopCloser(strings.NewReader(request.Header.Get("principal-header-2"))),
+					}, nil
+				},
+			},
+		},
+	}
+
+	want := config.Proxy{
+		PrincipalAuthHeaderName: "principal-header-2",
+		RoleAuthHeaderName:      "role-header-2",
+	}
+	h.Reload(want)
+
+	if got := h.proxyConfig(); !reflect.DeepEqual(got, want) {
+		t.Errorf("handler.Reload() cfg = %+v, want %+v", got, want)
+		return
+	}
+
+	w := httptest.NewRecorder()
+	if err := h.NTokenProxy(w, httptest.NewRequest(http.MethodGet, "http://url-1700", nil)); err != nil {
+		t.Errorf("handler.Reload() proxy error: %v", err)
+		return
+	}
+	if got := w.Body.String(); got != "ntoken" {
+		t.Errorf("handler.Reload() proxy request header = %q, want %q", got, "ntoken")
+	}
+}
+
+func Test_observeProxyResponse(t *testing.T) {
+	type test struct {
+		name string
+		res  *http.Response
+		want string
+	}
+	tests := []test{
+		{
+			name: "Check observeProxyResponse records the status code of the proxy",
+			res: &http.Response{
+				StatusCode: http.StatusAccepted,
+				Request:    httptest.NewRequest(http.MethodGet, "http://url-1560", nil).WithContext(context.WithValue(context.Background(), proxyNameKey{}, "proxy-1560")),
+			},
+			want: `athenz_client_sidecar_proxy_upstream_responses_total{code="202",proxy="proxy-1560"} 1`,
+		},
+		{
+			name: "Check observeProxyResponse ignores the request without proxy name",
+			res: &http.Response{
+				StatusCode: http.StatusAccepted,
+				Request:    httptest.NewRequest(http.MethodGet, "http://url-1568", nil),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := observeProxyResponse(tt.res); err != nil {
+				t.Errorf("observeProxyResponse() error: %v", err)
+				return
+			}
+			if tt.want == "" {
+				return
+			}
+
+			rec := httptest.NewRecorder()
+			metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("observeProxyResponse() metrics does not contain %q", tt.want)
+			}
+		})
+	}
+}
+
+func Test_handleProxyError(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "http://url-1590", nil).WithContext(context.WithValue(context.Background(), proxyNameKey{}, "proxy-1590"))
+
+	handleProxyError(w, r, fmt.Errorf("proxy-error-1592"))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("handleProxyError() code = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `athenz_client_sidecar_proxy_upstream_responses_total{code="error",proxy="proxy-1590"} 1`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("handleProxyError() metrics does not contain %q", want)
+	}
+}
+
+func Test_flushAndClose(t *testing.T) {
+	type args struct {
+		readCloser io.ReadCloser
+	}
+	type testcase struct {
+		name      string
+		args      args
+		wantError error
+	}
+	tests := []testcase{
+		{
+			name: "Check flushAndClose, readCloser is nil",
+			args: args{
+				readCloser: nil,
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check flushAndClose, flush & close success",
+			args: args{
+				readCloser: &readCloserMock{
+					readMock: func(p []byte) (n int, err error) {
+						return 0, io.EOF
+					},
+					closeMock: func() error {
+						return nil
+					},
+				},
+			},
+			wantError: nil,
+		},
+		{
+			name: "Check flushAndClose, flush fail",
+			args: args{
+				readCloser: &readCloserMock{
+					readMock: func(p []byte) (n int, err error) {
+						return 0, fmt.Errorf("read-error-1332")
+					},
+					closeMock: func() error {
+						return nil
+					},
+				},
+			},
+			wantError: fmt.Errorf("read-error-1332"),
+		},
+		{
+			name: "Check flushAndClose, close fail",
+			args: args{
+				readCloser: &readCloserMock{
+					readMock: func(p []byte) (n int, err error) {
+						return 0, io.EOF
+					},
+					closeMock: func() error {
+						return fmt.Errorf("close-error-1349")
+					},
+				},
+			},
+			wantError: fmt.Errorf("close-error-1349"),
+		},
+		{
+			name: "Check flushAndClose, flush & close fail",
+			args: args{
+				readCloser: &readCloserMock{
+					readMock: func(p []byte) (n int, err error) {
+						return 0, fmt.Errorf("read-error-1360")
+					},
+					closeMock: func() error {
+						return fmt.Errorf("close-error-1363")
+					},
+				},
+			},
+			wantError: fmt.Errorf("read-error-1360"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotError := flushAndClose(tt.args.readCloser)
+			if !reflect.DeepEqual(gotError, tt.wantError) {
+				t.Errorf("flushAndClose() error = %v, want %v", gotError, tt.wantError)
+			}
+		})
+	}
+}
+
+func Test_newClient(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://url-1700", nil)
+	r.RemoteAddr = "[::1]:12345"
+	r.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{
+			{
+				{
+					Subject: pkix.Name{
+						CommonName: "client.example",
+					},
+					DNSNames: []string{"client.alt.example"},
+				},
+			},
+		},
+	}
+
+	got := newClient(r)
+	if !got.IP.Equal(net.ParseIP("::1")) {
+		t.Errorf("newClient() IP = %v, want ::1", got.IP)
+	}
+	if want := []string{"client.example", "client.alt.example"}; !reflect.DeepEqual(got.Names, want) {
+		t.Errorf("newClient() Names = %v, want %v", got.Names, want)
+	}
+	if got.Cred != nil {
+		t.Errorf("newClient() Cred = %v, want nil", got.Cred)
+	}
+
+	// the client connecting via the unix domain socket
+	cred := &service.PeerCred{UID: 1000, GID: 1001, PID: 1720}
+	r = httptest.NewRequest(http.MethodGet, "http://url-1720", nil).WithContext(service.WithPeerCred(context.Background(), cred))
+	r.RemoteAddr = "@"
+	got = newClient(r)
+	if !reflect.DeepEqual(got.Cred, cred) {
+		t.Errorf("newClient() Cred = %v, want %v", got.Cred, cred)
 	}
 }
@@ -22,6 +22,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"syscall"
 
 	"github.com/kpango/glg"
@@ -36,6 +37,22 @@ var Version string
 type params struct {
 	configFilePath string
 	showVersion    bool
+	validateOnly   bool
+	overrides      overrideList
+}
+
+// overrideList represents the repeated command line flag of the configuration overrides.
+type overrideList []string
+
+// String returns the overrides joined with ",".
+func (o *overrideList) String() string {
+	return strings.Join(*o, ",")
+}
+
+// Set appends the "key=value" override.
+func (o *overrideList) Set(v string) error {
+	*o = append(*o, v)
+	return nil
 }
 
 func parseParams() (*params, error) {
@@ -49,6 +66,13 @@ func parseParams() (*params, error) {
 		"version",
 		false,
 		"show athenz-client-sidecar version")
+	f.BoolVar(&p.validateOnly,
+		"validate",
+		false,
+		"validate the client config yaml file and exit")
+	f.Var(&p.overrides,
+		"set",
+		"override the client config field by key=value of the dot separated yaml keys, e.g. -set server.port=8081 (repeatable)")
 
 	err := f.Parse(os.Args[1:])
 	if err != nil {
@@ -58,7 +82,26 @@ func parseParams() (*params, error) {
 	return p, nil
 }
 
-func run(cfg config.Config) []error {
+// loadConfig returns the client sidecar configuration read from the path and overridden by overrides, or any error if the configuration is invalid.
+func loadConfig(path string, overrides []string) (*config.Config, error) {
+	cfg, err := config.New(path, overrides...)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Version != config.GetVersion() {
+		return nil, errors.New("invalid athenz client proxy configuration version")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// run starts the client sidecar daemon and blocks until it stops.
+// The configuration is reloaded from configFilePath and overridden by overrides again when SIGHUP is received.
+func run(cfg config.Config, configFilePath string, overrides []string) []error {
 	if !cfg.EnableColorLogging {
 		glg.Get().DisableColor()
 	}
@@ -76,11 +119,24 @@ func run(cfg config.Config) []error {
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGTERM, syscall.SIGINT)
 
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	defer signal.Stop(hupCh)
+
 	for {
 		select {
 		case <-sigCh:
 			cancel()
 			glg.Warn("athenz client server shutdown...")
+		case <-hupCh:
+			glg.Info("athenz client server reloading config...")
+			newCfg, err := loadConfig(configFilePath, overrides)
+			if err == nil {
+				err = daemon.Reload(*newCfg)
+			}
+			if err != nil {
+				glg.Errorf("reload config failed, keep running with the current config: %v", err)
+			}
 		case errs := <-ech:
 			return errs
 		}
@@ -115,18 +171,18 @@ func main() {
 		return
 	}
 
-	cfg, err := config.New(p.configFilePath)
+	cfg, err := loadConfig(p.configFilePath, p.overrides)
 	if err != nil {
 		glg.Fatal(err)
 		return
 	}
 
-	if cfg.Version != config.GetVersion() {
-		glg.Fatal(errors.New("invalid athenz client proxy configuration version"))
+	if p.validateOnly {
+		glg.Infof("athenz-client-sidecar config %s is valid", p.configFilePath)
 		return
 	}
 
-	errs := run(*cfg)
+	errs := run(*cfg, p.configFilePath, p.overrides)
 	if errs != nil && len(errs) > 0 {
 		glg.Fatal(errs)
 		return
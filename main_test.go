@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/pkg/errors"
@@ -65,6 +66,37 @@ func TestParseParams(t *testing.T) {
 				checkErr: false,
 			}
 		}(),
+		func() test {
+			return test{
+				name: "check parseParams set overrides",
+				beforeFunc: func() {
+					os.Args = []string{"", "-set", "server.port=8081", "-set", "roletoken.athenz_url=https://zts.athenz.io/zts/v1"}
+				},
+				checkFunc: func(p *params) error {
+					want := []string{"server.port=8081", "roletoken.athenz_url=https://zts.athenz.io/zts/v1"}
+					if !reflect.DeepEqual([]string(p.overrides), want) {
+						return errors.Errorf("unexpected overrides. got: %v, want: %v", p.overrides, want)
+					}
+					return nil
+				},
+				checkErr: false,
+			}
+		}(),
+		func() test {
+			return test{
+				name: "check parseParams set validate flag",
+				beforeFunc: func() {
+					os.Args = []string{"", "-f", "/dummy/path", "-validate"}
+				},
+				checkFunc: func(p *params) error {
+					if p.validateOnly != true {
+						return errors.Errorf("unexpected validateOnly flag. got: %v, want: true", p.validateOnly)
+					}
+					return nil
+				},
+				checkErr: false,
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -112,7 +144,7 @@ func Test_run(t *testing.T) {
 					},
 				},
 				checkFunc: func(cfg config.Config) error {
-					got := run(cfg)
+					got := run(cfg, "", nil)
 					want := "RefreshInterval: time: invalid duration dummy: Invalid config"
 					if len(got) != 1 {
 						return errors.New("len(got) != 1")
@@ -135,7 +167,7 @@ func Test_run(t *testing.T) {
 					},
 				},
 				checkFunc: func(cfg config.Config) error {
-					got := run(cfg)
+					got := run(cfg, "", nil)
 					want := "invalid token refresh duration dummy, time: invalid duration dummy"
 					if len(got) != 1 {
 						return errors.New("len(got) != 1")
@@ -157,6 +189,58 @@ func Test_run(t *testing.T) {
 	}
 }
 
+func Test_loadConfig(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		overrides []string
+		wantErr   bool
+	}{
+		{
+			name: "load valid config",
+			path: "./config/assets/valid_config.yaml",
+		},
+		{
+			name:      "load valid config with overrides",
+			path:      "./config/assets/valid_config.yaml",
+			overrides: []string{"server.port=8081"},
+		},
+		{
+			name:      "load config with unknown override",
+			path:      "./config/assets/valid_config.yaml",
+			overrides: []string{"server.unknown=1"},
+			wantErr:   true,
+		},
+		{
+			name:    "load invalid config",
+			path:    "./config/assets/invalid_config.yaml",
+			wantErr: true,
+		},
+		{
+			name:    "load config with unknown field",
+			path:    "./config/assets/unknown_field_config.yaml",
+			wantErr: true,
+		},
+		{
+			name:    "config not exists",
+			path:    "./config/assets/not_exists.yaml",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadConfig(tt.path, tt.overrides)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got.Version != config.GetVersion() {
+				t.Errorf("loadConfig() version = %v, want %v", got.Version, config.GetVersion())
+			}
+		})
+	}
+}
+
 func Test_getVersion(t *testing.T) {
 	tests := []struct {
 		name string
@@ -0,0 +1,136 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// namespace represents the prefix of all the metric names exported by client sidecar.
+	namespace = "athenz_client_sidecar"
+
+	// codeError represents the status code label value when no HTTP response is received.
+	codeError = "error"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	ztsRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "zts_request_duration_seconds",
+		Help:      "Latency of the requests to Athenz ZTS server.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api", "code"})
+
+	cacheLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Number of the token cache lookups, partitioned by the result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	cacheRefreshFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_refresh_failures_total",
+		Help:      "Number of the failures when refreshing the token cache.",
+	}, []string{"cache"})
+
+	cacheEvictionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_evictions_total",
+		Help:      "Number of the entries evicted from the token cache, partitioned by the reason (idle or capacity).",
+	}, []string{"cache", "reason"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the requests handled by client sidecar, partitioned by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	proxyUpstreamResponsesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_upstream_responses_total",
+		Help:      "Number of the responses from the proxy destination, partitioned by the status code.",
+	}, []string{"proxy", "code"})
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		ztsRequestDuration,
+		cacheLookupsTotal,
+		cacheRefreshFailuresTotal,
+		cacheEvictionsTotal,
+		httpRequestDuration,
+		proxyUpstreamResponsesTotal,
+	)
+}
+
+// Handler returns a http.Handler to expose the metrics in Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveZTSRequest records the latency of the request to ZTS API since start.
+// The code should be 0 when the request failed without any HTTP response.
+func ObserveZTSRequest(api string, code int, start time.Time) {
+	ztsRequestDuration.WithLabelValues(api, codeLabel(code)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveCacheLookup records the result of the lookup of the cache.
+func ObserveCacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	cacheLookupsTotal.WithLabelValues(cache, result).Inc()
+}
+
+// IncCacheRefreshFailure increments the number of the failures when refreshing the cache.
+func IncCacheRefreshFailure(cache string) {
+	cacheRefreshFailuresTotal.WithLabelValues(cache).Inc()
+}
+
+// IncCacheEviction increments the number of the entries evicted from the cache by the reason.
+func IncCacheEviction(cache, reason string) {
+	cacheEvictionsTotal.WithLabelValues(cache, reason).Inc()
+}
+
+// ObserveHTTPRequest records the latency of the request handled by the route since start.
+func ObserveHTTPRequest(route, method string, code int, start time.Time) {
+	httpRequestDuration.WithLabelValues(route, method, codeLabel(code)).Observe(time.Since(start).Seconds())
+}
+
+// ObserveProxyResponse records the status code returned from the proxy destination.
+// The code should be 0 when the proxy failed without any HTTP response.
+func ObserveProxyResponse(proxy string, code int) {
+	proxyUpstreamResponsesTotal.WithLabelValues(proxy, codeLabel(code)).Inc()
+}
+
+func codeLabel(code int) string {
+	if code == 0 {
+		return codeError
+	}
+	return strconv.Itoa(code)
+}
@@ -0,0 +1,160 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHandler(t *testing.T) {
+	ObserveZTSRequest("handlerTest", http.StatusOK, time.Now())
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Handler() status code = %d, want %d", rec.Code, http.StatusOK)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		`athenz_client_sidecar_zts_request_duration_seconds_count{api="handlerTest",code="200"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Handler() body does not contain %q", want)
+		}
+	}
+}
+
+func TestObserveZTSRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		want string
+	}{
+		{
+			name: "record the status code",
+			code: http.StatusNotFound,
+			want: `athenz_client_sidecar_zts_request_duration_seconds_count{api="ztsTest",code="404"} 1`,
+		},
+		{
+			name: "record the request without response",
+			code: 0,
+			want: `athenz_client_sidecar_zts_request_duration_seconds_count{api="ztsTest",code="error"} 1`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ObserveZTSRequest("ztsTest", tt.code, time.Now())
+
+			rec := httptest.NewRecorder()
+			Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if !strings.Contains(rec.Body.String(), tt.want) {
+				t.Errorf("ObserveZTSRequest() metrics does not contain %q", tt.want)
+			}
+		})
+	}
+}
+
+func TestObserveCacheLookup(t *testing.T) {
+	tests := []struct {
+		name   string
+		hit    bool
+		result string
+	}{
+		{
+			name:   "record cache hit",
+			hit:    true,
+			result: "hit",
+		},
+		{
+			name:   "record cache miss",
+			hit:    false,
+			result: "miss",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cacheLookupsTotal.WithLabelValues("cacheTest", tt.result)
+			before := testutil.ToFloat64(c)
+			ObserveCacheLookup("cacheTest", tt.hit)
+			if got := testutil.ToFloat64(c) - before; got != 1 {
+				t.Errorf("ObserveCacheLookup() increased %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestIncCacheRefreshFailure(t *testing.T) {
+	c := cacheRefreshFailuresTotal.WithLabelValues("refreshTest")
+	IncCacheRefreshFailure("refreshTest")
+	IncCacheRefreshFailure("refreshTest")
+	if got := testutil.ToFloat64(c); got != 2 {
+		t.Errorf("IncCacheRefreshFailure() = %v, want 2", got)
+	}
+}
+
+func TestIncCacheEviction(t *testing.T) {
+	c := cacheEvictionsTotal.WithLabelValues("evictionTest", "idle")
+	IncCacheEviction("evictionTest", "idle")
+	if got := testutil.ToFloat64(c); got != 1 {
+		t.Errorf("IncCacheEviction() = %v, want 1", got)
+	}
+}
+
+func TestObserveHTTPRequest(t *testing.T) {
+	ObserveHTTPRequest("routeTest", http.MethodPost, http.StatusInternalServerError, time.Now())
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	want := `athenz_client_sidecar_http_request_duration_seconds_count{code="500",method="POST",route="routeTest"} 1`
+	if !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("ObserveHTTPRequest() metrics does not contain %q", want)
+	}
+}
+
+func TestObserveProxyResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		code int
+		want string
+	}{
+		{
+			name: "record the upstream status code",
+			code: http.StatusBadRequest,
+			want: "400",
+		},
+		{
+			name: "record the upstream error",
+			code: 0,
+			want: codeError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ObserveProxyResponse("proxyTest", tt.code)
+			if got := testutil.ToFloat64(proxyUpstreamResponsesTotal.WithLabelValues("proxyTest", tt.want)); got != 1 {
+				t.Errorf("ObserveProxyResponse() = %v, want 1", got)
+			}
+		})
+	}
+}
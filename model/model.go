@@ -37,11 +37,99 @@ type RoleRequest struct {
 	MaxExpiry int64 `json:"max_expiry"`
 }
 
+// Validate returns an error if the request does not follow the Athenz naming rules.
+func (r RoleRequest) Validate() error {
+	return validate(service.ValidateDomain(r.Domain), service.ValidateRoles(r.Role), service.ValidatePrincipal(r.ProxyForPrincipal))
+}
+
 // RoleResponse represent the basic information of the role token.
 type RoleResponse = service.RoleToken
 
+// AccessRequest represent the request information to get the access token.
+type AccessRequest struct {
+	// Domain represent the domain field of the request.
+	Domain string `json:"domain"`
+
+	// Role represent the role field of the request.
+	Role string `json:"role"`
+
+	// ProxyForPrincipal represent the ProxyForPrincipal field of the request.
+	ProxyForPrincipal string `json:"proxy_for_principal"`
+
+	// Expiry represent the Expiry field of the request.
+	Expiry int64 `json:"expiry"`
+}
+
+// Validate returns an error if the request does not follow the Athenz naming rules.
+func (r AccessRequest) Validate() error {
+	return validate(service.ValidateDomain(r.Domain), service.ValidateRoles(r.Role), service.ValidatePrincipal(r.ProxyForPrincipal))
+}
+
+// AccessResponse represent the basic information of the access token.
+type AccessResponse = service.AccessTokenResponse
+
+// SvcCertResponse represent the service certificate, its CA certificate chain and expiry time.
+type SvcCertResponse = service.SvcCert
+
+// RoleCertRequest represent the request information to get the role certificate.
+type RoleCertRequest struct {
+	// Domain represent the domain field of the request.
+	Domain string `json:"domain"`
+
+	// Role represent the role field of the request.
+	Role string `json:"role"`
+
+	// ProxyForPrincipal represent the ProxyForPrincipal field of the request.
+	ProxyForPrincipal string `json:"proxy_for_principal"`
+
+	// Expiry represent the Expiry field of the request.
+	Expiry int64 `json:"expiry"`
+}
+
+// Validate returns an error if the request does not follow the Athenz naming rules.
+func (r RoleCertRequest) Validate() error {
+	return validate(service.ValidateDomain(r.Domain), service.ValidateRole(r.Role), service.ValidatePrincipal(r.ProxyForPrincipal))
+}
+
+// RoleCertResponse represent the role certificate and its expiry time.
+type RoleCertResponse = service.RoleCert
+
 // NTokenResponse represent the response information of get N-token request.
 type NTokenResponse struct {
 	// NToken represent the N-token generated.
 	NToken string `json:"token"`
 }
+
+// RoleCacheRequest represent the request information to refresh or invalidate the cached role token.
+type RoleCacheRequest struct {
+	// Domain represent the domain field of the request.
+	Domain string `json:"domain"`
+
+	// Role represent the role field of the request.
+	Role string `json:"role"`
+
+	// ProxyForPrincipal represent the ProxyForPrincipal field of the request.
+	ProxyForPrincipal string `json:"proxy_for_principal"`
+
+	// All represent the All field of the request, to invalidate all the cached role tokens.
+	All bool `json:"all"`
+}
+
+// RoleCacheEntry represent the information of a cached role token without the token itself.
+type RoleCacheEntry = service.RoleCacheEntry
+
+// RoleCacheInvalidateResponse represent the response information of the role token cache invalidation.
+type RoleCacheInvalidateResponse struct {
+	// Invalidated represent the number of the invalidated role tokens.
+	Invalidated int `json:"invalidated"`
+}
+
+// validate returns the first error of errs.
+func validate(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
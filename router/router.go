@@ -16,19 +16,39 @@ limitations under the License.
 package router
 
 import (
+	"bufio"
 	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/kpango/glg"
+	"github.com/pkg/errors"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
 	"github.com/yahoojapan/athenz-client-sidecar/handler"
+	"github.com/yahoojapan/athenz-client-sidecar/metrics"
+	"github.com/yahoojapan/athenz-client-sidecar/service"
 )
 
+// statusRecorder is a http.ResponseWriter wrapper to record the status code of the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+// timeoutWriter is a http.ResponseWriter wrapper to stop the handler writing the response after the routing timeout.
+type timeoutWriter struct {
+	http.ResponseWriter
+	mu          sync.Mutex
+	wroteHeader bool
+	timedOut    bool
+}
+
 //New returns Routed ServeMux
 func New(cfg config.Server, h handler.Handler) *http.ServeMux {
 
@@ -42,7 +62,23 @@ func New(cfg config.Server, h handler.Handler) *http.ServeMux {
 	}
 
 	for _, route := range NewRoutes(h) {
-		mux.Handle(route.Pattern, routing(route.Methods, dur, route.HandlerFunc))
+		mux.Handle(route.Pattern, instrument(route.Pattern, routing(route.Methods, dur, route.HandlerFunc)))
+	}
+
+	return mux
+}
+
+// NewAdmin returns Routed ServeMux of the admin server
+func NewAdmin(cfg config.Server, h handler.AdminHandler) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	dur, err := time.ParseDuration(cfg.Timeout)
+	if err != nil {
+		dur = time.Second * 3
+	}
+
+	for _, route := range NewAdminRoutes(h) {
+		mux.Handle(route.Pattern, instrument(route.Pattern, routing(route.Methods, dur, route.HandlerFunc)))
 	}
 
 	return mux
@@ -56,9 +92,12 @@ func routing(m []string, t time.Duration, h handler.Func) http.Handler {
 				ctx, cancel := context.WithTimeout(r.Context(), t)
 				defer cancel()
 				start := time.Now()
+				tw := &timeoutWriter{
+					ResponseWriter: w,
+				}
 				ech := make(chan error)
 				go func() {
-					ech <- h(w, r.WithContext(ctx))
+					ech <- h(tw, r.WithContext(ctx))
 					close(ech)
 				}()
 
@@ -66,16 +105,26 @@ func routing(m []string, t time.Duration, h handler.Func) http.Handler {
 					select {
 					case err := <-ech:
 						if err != nil {
-							http.Error(w,
+							code := http.StatusInternalServerError
+							// the client error of athenz server is returned with the same status code
+							if e, ok := errors.Cause(err).(*service.ZTSError); ok {
+								code = e.StatusCode
+							}
+							http.Error(tw,
 								fmt.Sprintf("Error: %s\t%s",
 									err.Error(),
-									http.StatusText(http.StatusInternalServerError)),
-								http.StatusInternalServerError)
-							glg.Error(err)
+									http.StatusText(code)),
+								code)
+							if cred := service.PeerCredFromContext(r.Context()); cred != nil {
+								glg.Errorf("%v, peer: %s", err, cred)
+							} else {
+								glg.Error(err)
+							}
 						}
 						return
 					case <-ctx.Done():
 						glg.Errorf("Handler Time Out: %v", time.Since(start))
+						tw.timeout()
 						return
 					}
 				}
@@ -97,3 +146,120 @@ func routing(m []string, t time.Duration, h handler.Func) http.Handler {
 			http.StatusMethodNotAllowed)
 	})
 }
+
+// instrument returns a http.Handler that records the latency and the status code of the requests handled by h for the route.
+func instrument(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{
+			ResponseWriter: w,
+			code:           http.StatusOK,
+		}
+		defer func() {
+			metrics.ObserveHTTPRequest(route, r.Method, rec.code, start)
+		}()
+		h.ServeHTTP(rec, r)
+	})
+}
+
+// WriteHeader records the status code and writes it to the underlying http.ResponseWriter.
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher to support streaming responses from the proxy handlers.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker to support the protocol upgrade, e.g. WebSocket, through the proxy handlers.
+// The status code is recorded as 101 Switching Protocols since the response is written to the hijacked connection.
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the underlying http.ResponseWriter does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		s.code = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Write writes b to the underlying http.ResponseWriter, or returns http.ErrHandlerTimeout after the routing timeout.
+func (t *timeoutWriter) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	t.wroteHeader = true
+	return t.ResponseWriter.Write(b)
+}
+
+// WriteHeader writes code to the underlying http.ResponseWriter unless the routing timeout has passed.
+func (t *timeoutWriter) WriteHeader(code int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timedOut {
+		return
+	}
+	t.wroteHeader = true
+	t.ResponseWriter.WriteHeader(code)
+}
+
+// Flush implements http.Flusher to support streaming responses from the proxy handlers.
+func (t *timeoutWriter) Flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timedOut {
+		return
+	}
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker to support the protocol upgrade through the proxy handlers.
+func (t *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	h, ok := t.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the underlying http.ResponseWriter does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		t.wroteHeader = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (t *timeoutWriter) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// timeout writes 504 Gateway Timeout unless the handler has written the response, and stops the handler writing the response afterward.
+func (t *timeoutWriter) timeout() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.timedOut = true
+	if !t.wroteHeader {
+		http.Error(t.ResponseWriter,
+			fmt.Sprintf("Error: %s",
+				http.StatusText(http.StatusGatewayTimeout)),
+			http.StatusGatewayTimeout)
+	}
+}
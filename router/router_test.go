@@ -30,6 +30,7 @@ import (
 	"github.com/kpango/glg"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
 	"github.com/yahoojapan/athenz-client-sidecar/handler"
+	"github.com/yahoojapan/athenz-client-sidecar/service"
 )
 
 func TestNew(t *testing.T) {
@@ -39,7 +40,7 @@ func TestNew(t *testing.T) {
 		RoleAuthHeaderName:      "X-test-role-header",
 		BufferSize:              1024,
 	}
-	h := handler.New(proxyConfig, nil, nil, nil)
+	h := handler.New(proxyConfig, nil, nil, nil, nil, nil, nil, nil)
 
 	type args struct {
 		cfg config.Server
@@ -214,6 +215,46 @@ func Test_routing(t *testing.T) {
 				},
 			}
 		}(),
+		func() test {
+			err := &service.ZTSError{
+				StatusCode: http.StatusForbidden,
+				Message:    "not a member",
+			}
+			want := "Error: " + err.Error() + "\t" + http.StatusText(http.StatusForbidden) + "\n"
+			wantStatusCode := http.StatusForbidden
+
+			return test{
+				name: "Check whether Handler returns the status code of athenz server error",
+				args: args{
+					m: []string{
+						http.MethodGet,
+					},
+					t: time.Second * 10,
+					h: func(rw http.ResponseWriter, r *http.Request) error {
+						return err
+					},
+				},
+				checkFunc: func(server http.Handler) error {
+
+					request := httptest.NewRequest(http.MethodGet, "/", nil)
+					record := httptest.NewRecorder()
+					server.ServeHTTP(record, request)
+					response := record.Result()
+
+					defer response.Body.Close()
+
+					byteArray, _ := ioutil.ReadAll(response.Body)
+					got := string(byteArray)
+					gotStatusCode := response.StatusCode
+
+					if got != want || gotStatusCode != wantStatusCode {
+						return fmt.Errorf("Handler could not handle the request: request: %v  got response: %v  want: %v  got statuscode: %d  want statuscode: %d", request, got, want, gotStatusCode, wantStatusCode)
+					}
+
+					return nil
+				},
+			}
+		}(),
 		func() test {
 			testStr := "testhoge"
 			want := "Method: GET" + "\t" + http.StatusText(http.StatusMethodNotAllowed) + "\n"
@@ -327,6 +368,44 @@ func Test_routing(t *testing.T) {
 				},
 			}
 		}(),
+		func() test {
+			wantStatusCode := http.StatusGatewayTimeout
+			release := make(chan struct{})
+			done := make(chan error, 1)
+
+			return test{
+				name: "Check whether Handler returns 'Gateway Timeout' status when timeout",
+				args: args{
+					m: []string{
+						http.MethodGet,
+					},
+					t: time.Millisecond * 50,
+					h: func(rw http.ResponseWriter, r *http.Request) error {
+						<-release
+						_, err := rw.Write([]byte("testhoge"))
+						done <- err
+						return nil
+					},
+				},
+				checkFunc: func(server http.Handler) error {
+					request := httptest.NewRequest(http.MethodGet, "/", nil)
+					record := httptest.NewRecorder()
+					h := instrument("/", server)
+					h.ServeHTTP(record, request)
+					close(release)
+
+					// the response written by the handler after the timeout is discarded
+					if err := <-done; err != http.ErrHandlerTimeout {
+						return fmt.Errorf("Handler could write the response after timeout: got error: %v  want: %v", err, http.ErrHandlerTimeout)
+					}
+					if record.Code != wantStatusCode || strings.Contains(record.Body.String(), "testhoge") {
+						return fmt.Errorf("Handler did not return timeout: got statuscode: %d  want statuscode: %d  got response: %v", record.Code, wantStatusCode, record.Body.String())
+					}
+
+					return nil
+				},
+			}
+		}(),
 		func() test {
 			testStr := "testhoge"
 			want := "Handler Time Out:"
@@ -425,3 +504,90 @@ func Test_routing(t *testing.T) {
 		})
 	}
 }
+
+func Test_instrument(t *testing.T) {
+	type test struct {
+		name     string
+		h        http.Handler
+		wantCode int
+	}
+	tests := []test{
+		{
+			name: "Check instrument records the default status code",
+			h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("ok"))
+			}),
+			wantCode: http.StatusOK,
+		},
+		{
+			name: "Check instrument records the status code written by handler",
+			h: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "error", http.StatusInternalServerError)
+			}),
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotCode int
+			h := instrument("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				tt.h.ServeHTTP(w, r)
+				gotCode = w.(*statusRecorder).code
+			}))
+
+			record := httptest.NewRecorder()
+			h.ServeHTTP(record, httptest.NewRequest(http.MethodGet, "/test", nil))
+
+			if gotCode != tt.wantCode || record.Code != tt.wantCode {
+				t.Errorf("instrument() recorded code: %d, response code: %d, want: %d", gotCode, record.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func Test_statusRecorder_Hijack(t *testing.T) {
+	codeCh := make(chan int, 1)
+	srv := httptest.NewServer(instrument("/proxy/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := w.(*statusRecorder)
+		defer func() {
+			codeCh <- rec.code
+		}()
+		if rec.Unwrap() == w {
+			t.Error("Unwrap() returns the wrapper itself")
+		}
+		conn, rw, err := rec.Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\n")
+		rw.Flush()
+	})))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/proxy/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("response code = %v, want %v", res.StatusCode, http.StatusSwitchingProtocols)
+	}
+	if got := <-codeCh; got != http.StatusSwitchingProtocols {
+		t.Errorf("recorded code = %v, want %v", got, http.StatusSwitchingProtocols)
+	}
+
+	// the underlying http.ResponseWriter does not support hijacking
+	rec := &statusRecorder{
+		ResponseWriter: httptest.NewRecorder(),
+		code:           http.StatusOK,
+	}
+	if _, _, err := rec.Hijack(); err == nil {
+		t.Error("Hijack() without http.Hijacker error = nil")
+	}
+}
@@ -46,6 +46,30 @@ func NewRoutes(h handler.Handler) []Route {
 			"/roletoken",
 			h.RoleToken,
 		},
+		{
+			"AccessToken Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/accesstoken",
+			h.AccessToken,
+		},
+		{
+			"SvcCert Handler",
+			[]string{
+				http.MethodGet,
+			},
+			"/svccert",
+			h.SvcCert,
+		},
+		{
+			"RoleCert Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/rolecert",
+			h.RoleCert,
+		},
 		{
 			"RoleToken proxy Handler",
 			[]string{
@@ -54,6 +78,14 @@ func NewRoutes(h handler.Handler) []Route {
 			"/proxy/roletoken",
 			h.RoleTokenProxy,
 		},
+		{
+			"AccessToken proxy Handler",
+			[]string{
+				"*",
+			},
+			"/proxy/accesstoken",
+			h.AccessTokenProxy,
+		},
 		{
 			"NToken proxy Handler",
 			[]string{
@@ -64,3 +96,33 @@ func NewRoutes(h handler.Handler) []Route {
 		},
 	}
 }
+
+// NewAdminRoutes returns the routes of the admin server.
+func NewAdminRoutes(h handler.AdminHandler) []Route {
+	return []Route{
+		{
+			"RoleToken cache Handler",
+			[]string{
+				http.MethodGet,
+			},
+			"/roletoken/cache",
+			h.RoleTokenCache,
+		},
+		{
+			"RoleToken cache refresh Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/roletoken/cache/refresh",
+			h.RefreshRoleTokenCache,
+		},
+		{
+			"RoleToken cache invalidate Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/roletoken/cache/invalidate",
+			h.InvalidateRoleTokenCache,
+		},
+	}
+}
@@ -16,12 +16,20 @@ limitations under the License.
 package router
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/yahoojapan/athenz-client-sidecar/config"
 	"github.com/yahoojapan/athenz-client-sidecar/handler"
+	"github.com/yahoojapan/athenz-client-sidecar/service"
 )
 
 func TestNewRoutes(t *testing.T) {
@@ -42,7 +50,7 @@ func TestNewRoutes(t *testing.T) {
 				RoleAuthHeaderName:      "X-test-role-header",
 				BufferSize:              1024,
 			}
-			h := handler.New(proxyConfig, nil, nil, nil)
+			h := handler.New(proxyConfig, nil, nil, nil, nil, nil, nil, nil)
 
 			return test{
 				name: "Run NewRoutes successfully",
@@ -66,6 +74,30 @@ func TestNewRoutes(t *testing.T) {
 						"/roletoken",
 						h.RoleToken,
 					},
+					{
+						"AccessToken Handler",
+						[]string{
+							http.MethodPost,
+						},
+						"/accesstoken",
+						h.AccessToken,
+					},
+					{
+						"SvcCert Handler",
+						[]string{
+							http.MethodGet,
+						},
+						"/svccert",
+						h.SvcCert,
+					},
+					{
+						"RoleCert Handler",
+						[]string{
+							http.MethodPost,
+						},
+						"/rolecert",
+						h.RoleCert,
+					},
 					{
 						"RoleToken proxy Handler",
 						[]string{
@@ -74,6 +106,14 @@ func TestNewRoutes(t *testing.T) {
 						"/proxy/roletoken",
 						h.RoleTokenProxy,
 					},
+					{
+						"AccessToken proxy Handler",
+						[]string{
+							"*",
+						},
+						"/proxy/accesstoken",
+						h.AccessTokenProxy,
+					},
 					{
 						"NToken proxy Handler",
 						[]string{
@@ -108,3 +148,112 @@ func TestNewRoutes(t *testing.T) {
 		})
 	}
 }
+
+func TestNewAdminRoutes(t *testing.T) {
+	h := handler.NewAdmin(nil)
+	want := []Route{
+		{
+			"RoleToken cache Handler",
+			[]string{
+				http.MethodGet,
+			},
+			"/roletoken/cache",
+			h.RoleTokenCache,
+		},
+		{
+			"RoleToken cache refresh Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/roletoken/cache/refresh",
+			h.RefreshRoleTokenCache,
+		},
+		{
+			"RoleToken cache invalidate Handler",
+			[]string{
+				http.MethodPost,
+			},
+			"/roletoken/cache/invalidate",
+			h.InvalidateRoleTokenCache,
+		},
+	}
+
+	got := NewAdminRoutes(h)
+	if len(got) != len(want) {
+		t.Fatalf("NewAdminRoutes() = %v, want %v", got, want)
+	}
+	for i, gotValue := range got {
+		wantValue := want[i]
+		if gotValue.Name != wantValue.Name ||
+			!reflect.DeepEqual(gotValue.Methods, wantValue.Methods) ||
+			gotValue.Pattern != wantValue.Pattern ||
+			reflect.ValueOf(gotValue.HandlerFunc).Pointer() != reflect.ValueOf(wantValue.HandlerFunc).Pointer() {
+			t.Errorf("got and want unmatched: got: %v  want: %v", gotValue, wantValue)
+		}
+	}
+}
+
+func TestNewRoutes_policy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// only uid 4242 is allowed to get the credentials
+	path := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(path, []byte(`
+clients:
+  - uids: [4242]
+    ntoken: true
+    service_cert: true
+    domains: ["*"]
+    roles: ["*"]
+    proxy_for_principals: ["*"]
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := service.NewPolicyService(config.Policy{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errCalled := fmt.Errorf("credential must not be requested")
+	h := handler.New(config.Proxy{}, nil,
+		func() (string, error) {
+			return "", errCalled
+		},
+		func(ctx context.Context, domain string, role string, proxyForPrincipal string, minExpiry int64, maxExpiry int64) (*service.RoleToken, error) {
+			return nil, errCalled
+		},
+		func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (*service.AccessTokenResponse, error) {
+			return nil, errCalled
+		},
+		func(ctx context.Context) (*service.SvcCert, error) {
+			return nil, errCalled
+		},
+		func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiry int64) (*service.RoleCert, error) {
+			return nil, errCalled
+		},
+		policy.GetAuthorizer())
+
+	// every route issues a credential, so the local process denied by the policy must get nothing from any of them
+	for _, route := range NewRoutes(h) {
+		t.Run(route.Pattern, func(t *testing.T) {
+			method := route.Methods[0]
+			if method == "*" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, "http://localhost"+route.Pattern, strings.NewReader(`{"domain":"domain","role":"role"}`))
+			r.Header.Set("Athenz-Domain", "domain")
+			r.Header.Set("Athenz-Role", "role")
+			r = r.WithContext(service.WithPeerCred(r.Context(), &service.PeerCred{UID: 1000, GID: 1000, PID: 1}))
+			w := httptest.NewRecorder()
+
+			route.HandlerFunc(w, r)
+			if w.Code != http.StatusForbidden {
+				t.Errorf("%s status = %d, want %d", route.Pattern, w.Code, http.StatusForbidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,420 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/kpango/gache"
+	"github.com/kpango/glg"
+	ntokend "github.com/kpango/ntokend"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+	"github.com/yahoojapan/athenz-client-sidecar/metrics"
+	"golang.org/x/sync/singleflight"
+)
+
+// AccessService represent a interface to automatically refresh the access token, and a access token provider function pointer.
+type AccessService interface {
+	StartAccessUpdater(context.Context) <-chan error
+	RefreshAccessTokenCache(ctx context.Context) <-chan error
+	GetAccessProvider() AccessProvider
+}
+
+// accessService represent the implementation of athenz AccessService
+type accessService struct {
+	cfg                   config.Access
+	token                 ntokend.TokenProvider
+	athenzURL             string
+	athenzPrincipleHeader string
+	tokenCache            gache.Gache
+	group                 singleflight.Group
+	expiry                time.Duration
+	httpClient            *http.Client
+
+	refreshInterval  time.Duration
+	errRetryMaxCount int
+	errRetryInterval time.Duration
+}
+
+type accessCacheData struct {
+	token             *AccessTokenResponse
+	domain            string
+	role              string
+	proxyForPrincipal string
+	expiresIn         int64
+	expiry            int64
+}
+
+// AccessTokenResponse represents the AccessTokenResponse from postAccessTokenRequest.
+type AccessTokenResponse struct {
+	// AccessToken represents the access token (JWT).
+	AccessToken string `json:"access_token"`
+
+	// TokenType represents the token type (always "Bearer").
+	TokenType string `json:"token_type"`
+
+	// ExpiresIn represents the remaining lifetime of the access token in seconds.
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+
+	// Scope represents the scope granted in the access token.
+	Scope string `json:"scope,omitempty"`
+
+	// RefreshToken represents the refresh token if requested.
+	RefreshToken string `json:"refresh_token,omitempty"`
+
+	// IDToken represents the ID token if requested.
+	IDToken string `json:"id_token,omitempty"`
+}
+
+// AccessProvider represent a function pointer to get the access token.
+type AccessProvider func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiresIn int64) (*AccessTokenResponse, error)
+
+var (
+	// ErrAccessTokenRequestFailed represent an error when failed to fetch the access token from AccessProvider.
+	ErrAccessTokenRequestFailed = errors.New("Failed to fetch AccessToken")
+
+	// ErrInvalidAccessTokenExpiry represent an error when the lifetime of the fetched access token is unknown.
+	ErrInvalidAccessTokenExpiry = errors.New("Invalid expires_in of AccessToken")
+)
+
+const (
+	// scopeSeparator is the separator of the access token scope.
+	scopeSeparator = " "
+
+	// roleScopeSeparator is the separator between the domain name and role name in the access token scope.
+	roleScopeSeparator = ":role."
+
+	// domainScopeSeparator is the separator between the domain name in the access token scope.
+	domainScopeSeparator = ":domain"
+)
+
+// NewAccessService returns a AccessService to update and get the access token from athenz.
+func NewAccessService(cfg config.Access, token ntokend.TokenProvider) (AccessService, error) {
+	var (
+		err              error
+		exp              = defaultTokenExpiry
+		refreshInterval  = defaultRefreshInterval
+		errRetryInterval = defaultErrRetryInterval
+	)
+
+	if cfg.TokenExpiry != "" {
+		if exp, err = time.ParseDuration(cfg.TokenExpiry); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "TokenExpiry: "+err.Error())
+		}
+	}
+	if cfg.RefreshInterval != "" {
+		if refreshInterval, err = time.ParseDuration(cfg.RefreshInterval); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "RefreshInterval: "+err.Error())
+		}
+	}
+	if cfg.ErrRetryInterval != "" {
+		if errRetryInterval, err = time.ParseDuration(cfg.ErrRetryInterval); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryInterval: "+err.Error())
+		}
+	}
+
+	// if user set the expiry time and refresh duration > expiry time then return error
+	if exp != 0 && refreshInterval > exp {
+		return nil, errors.Wrap(ErrInvalidSetting, "refresh interval > token expiry time")
+	}
+
+	errRetryMaxCount := defaultErrRetryMaxCount
+	if cfg.ErrRetryMaxCount > 0 {
+		errRetryMaxCount = cfg.ErrRetryMaxCount
+	} else if cfg.ErrRetryMaxCount != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0")
+	}
+
+	return &accessService{
+		cfg:                   cfg,
+		token:                 token,
+		athenzURL:             cfg.AthenzURL,
+		athenzPrincipleHeader: cfg.PrincipalAuthHeaderName,
+		tokenCache:            gache.New(),
+		expiry:                exp,
+		httpClient:            newAthenzHTTPClient(cfg.AthenzRootCA),
+		refreshInterval:       refreshInterval,
+		errRetryMaxCount:      errRetryMaxCount,
+		errRetryInterval:      errRetryInterval,
+	}, nil
+}
+
+// StartAccessUpdater returns AccessService.
+// This function will periodically refresh the access token.
+func (a *accessService) StartAccessUpdater(ctx context.Context) <-chan error {
+	glg.Info("Starting access token updater")
+
+	ech := make(chan error, 100)
+	go func() {
+		defer close(ech)
+
+		ticker := time.NewTicker(a.refreshInterval)
+		for {
+			select {
+			case <-ctx.Done():
+				glg.Info("Stopping access token updater")
+				ticker.Stop()
+				ech <- ctx.Err()
+				return
+			case <-ticker.C:
+				for err := range a.RefreshAccessTokenCache(ctx) {
+					ech <- errors.Wrap(err, "error update access token")
+				}
+			}
+		}
+	}()
+
+	a.tokenCache.StartExpired(ctx, expiryCheckInterval)
+	a.tokenCache.EnableExpiredHook().SetExpiredHook(func(ctx context.Context, k string) {
+		glg.Warnf("the following access token cache is expired, key: %v", k)
+	})
+	return ech
+}
+
+// GetAccessProvider returns a function pointer to get the access token.
+func (a *accessService) GetAccessProvider() AccessProvider {
+	return a.getAccessToken
+}
+
+// getAccessToken returns AccessTokenResponse struct or error.
+// This function will return the access token stored inside the cache, or fetch the access token from athenz when corresponding access token cannot be found in the cache.
+func (a *accessService) getAccessToken(ctx context.Context, domain, role, proxyForPrincipal string, expiresIn int64) (*AccessTokenResponse, error) {
+	tok, ok := a.getCache(domain, role, proxyForPrincipal, expiresIn)
+	metrics.ObserveCacheLookup("accesstoken", ok)
+	if !ok {
+		return a.updateAccessToken(ctx, domain, role, proxyForPrincipal, expiresIn)
+	}
+	return tok, nil
+}
+
+// RefreshAccessTokenCache returns the error channel when it is updated.
+func (a *accessService) RefreshAccessTokenCache(ctx context.Context) <-chan error {
+	glg.Info("RefreshAccessTokenCache started")
+
+	echan := make(chan error, a.tokenCache.Len()*(a.errRetryMaxCount+1))
+	go func() {
+		defer close(echan)
+
+		a.tokenCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+			domain, role, principal := decode(key)
+			cd := val.(*accessCacheData)
+
+			for err := range a.updateAccessTokenWithRetry(ctx, domain, role, principal, cd.expiresIn) {
+				metrics.IncCacheRefreshFailure("accesstoken")
+				echan <- err
+			}
+			return true
+		})
+	}()
+
+	return echan
+}
+
+func (a *accessService) updateAccessTokenWithRetry(ctx context.Context, domain, role, proxyForPrincipal string, expiresIn int64) <-chan error {
+	glg.Debugf("updateAccessTokenWithRetry started, domain: %s, role: %s, proxyForPrincipal: %s, expiresIn: %d", domain, role, proxyForPrincipal, expiresIn)
+
+	echan := make(chan error, a.errRetryMaxCount+1)
+	go func() {
+		defer close(echan)
+
+		for i := 0; i <= a.errRetryMaxCount; i++ {
+			_, err := a.updateAccessToken(ctx, domain, role, proxyForPrincipal, expiresIn)
+			if err == nil {
+				glg.Debug("update success")
+				return
+			}
+			echan <- err
+			if i == a.errRetryMaxCount {
+				return
+			}
+			t := time.NewTimer(a.errRetryInterval)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	return echan
+}
+
+// updateAccessToken returns AccessTokenResponse struct or error.
+// This function ask athenz to generate access token and return, or return any error when generating the access token.
+func (a *accessService) updateAccessToken(ctx context.Context, domain, role, proxyForPrincipal string, expiresIn int64) (*AccessTokenResponse, error) {
+	// the access tokens requested with different lifetime are cached and shared separately
+	key := accessKey(domain, role, proxyForPrincipal, expiresIn)
+	expTimeDelta := fastime.Now().Add(time.Minute)
+
+	at, err, _ := a.group.Do(key, func() (interface{}, error) {
+		at, e := a.fetchAccessToken(ctx, domain, role, proxyForPrincipal, expiresIn)
+		if e != nil {
+			return nil, e
+		}
+
+		if at.ExpiresIn <= 0 {
+			// expires_in is optional, the access token is assumed to have the requested lifetime
+			at.ExpiresIn = a.requestExpiry(expiresIn)
+			if at.ExpiresIn <= 0 {
+				return nil, ErrInvalidAccessTokenExpiry
+			}
+		}
+		expTime := fastime.Now().Add(time.Duration(at.ExpiresIn) * time.Second)
+		if !expTime.After(expTimeDelta) {
+			// the non-positive duration never expires in the cache
+			glg.Debugf("access token is not cached for the short lifetime, domain: %s, role: %s, proxyForPrincipal: %s, expires in: %d", domain, role, proxyForPrincipal, at.ExpiresIn)
+			return at, nil
+		}
+		a.tokenCache.SetWithExpire(key, &accessCacheData{
+			token:             at,
+			domain:            domain,
+			role:              role,
+			proxyForPrincipal: proxyForPrincipal,
+			expiresIn:         expiresIn,
+			expiry:            expTime.Unix(),
+		}, expTime.Sub(expTimeDelta))
+
+		glg.Debugf("access token is cached, domain: %s, role: %s, proxyForPrincipal: %s, expiry time: %v", domain, role, proxyForPrincipal, expTime.Unix())
+		return at, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return at.(*AccessTokenResponse), err
+}
+
+// fetchAccessToken fetch the access token from Athenz server, and return the decoded access token and any error if occurred.
+func (a *accessService) fetchAccessToken(ctx context.Context, domain, role, proxyForPrincipal string, expiresIn int64) (*AccessTokenResponse, error) {
+	glg.Debugf("get access token, domain: %s, role: %s, proxyForPrincipal: %s, expiresIn: %d", domain, role, proxyForPrincipal, expiresIn)
+
+	// get the n-token
+	tok, err := a.token()
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare request object
+	req, err := a.createPostAccessTokenRequest(domain, role, proxyForPrincipal, expiresIn, tok)
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, err
+	}
+	glg.Debugf("request url: %v", req.URL)
+
+	res, err := doZTSRequest(ctx, a.httpClient, nil, "accesstoken", req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer flushAndClose(res.Body)
+	if res.StatusCode != http.StatusOK {
+		buf := new(bytes.Buffer)
+		if _, err := buf.ReadFrom(res.Body); err != nil {
+			glg.Debugf("cannot read response body, err: %v", err)
+		}
+		glg.Debugf("error return from server, response:%+v, body: %v", res, buf.String())
+		return nil, ErrAccessTokenRequestFailed
+	}
+
+	var data *AccessTokenResponse
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// getCache returns the cached access token, the ExpiresIn field of the returned token is re-calculated from the current time.
+func (a *accessService) getCache(domain, role, principal string, expiresIn int64) (*AccessTokenResponse, bool) {
+	val, ok := a.tokenCache.Get(accessKey(domain, role, principal, expiresIn))
+	if !ok {
+		return nil, false
+	}
+
+	cd := val.(*accessCacheData)
+	tok := *cd.token
+	tok.ExpiresIn = cd.expiry - fastime.Now().Unix()
+	return &tok, ok
+}
+
+func (a *accessService) createPostAccessTokenRequest(domain, role, proxyForPrincipal string, expiresIn int64, token string) (*http.Request, error) {
+	u := fmt.Sprintf("https://%s/oauth2/token", strings.TrimPrefix(strings.TrimPrefix(a.athenzURL, "https://"), "http://"))
+
+	// create URL query
+	q := url.Values{}
+	q.Add("grant_type", "client_credentials")
+	q.Add("scope", createScope(domain, role))
+
+	if exp := a.requestExpiry(expiresIn); exp > 0 {
+		q.Add("expires_in", strconv.FormatInt(exp, 10))
+	}
+
+	if proxyForPrincipal != "" {
+		q.Add("proxy_for_principal", proxyForPrincipal)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(q.Encode()))
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	// set authenication token
+	req.Header.Set(a.athenzPrincipleHeader, token)
+
+	return req, nil
+}
+
+// requestExpiry returns the lifetime in seconds of the access token to request, expiresIn or the default token expiry.
+func (a *accessService) requestExpiry(expiresIn int64) int64 {
+	if expiresIn > 0 {
+		return expiresIn
+	}
+	return int64(a.expiry / time.Second)
+}
+
+// accessKey returns the cache key of the access token requested with expiresIn.
+func accessKey(domain, role, principal string, expiresIn int64) string {
+	return encode(domain, role, principal) + expirySeparater + strconv.FormatInt(expiresIn, 10)
+}
+
+// createScope returns the access token scope of the given domain and roles.
+// e.g. "domain:role.role1 domain:role.role2", or "domain:domain" when role is empty.
+func createScope(domain, role string) string {
+	if role == "" {
+		return domain + domainScopeSeparator
+	}
+
+	roles := strings.Split(role, roleSeparater)
+	scopes := make([]string, 0, len(roles))
+	for _, r := range roles {
+		scopes = append(scopes, domain+roleScopeSeparator+r)
+	}
+	return strings.Join(scopes, scopeSeparator)
+}
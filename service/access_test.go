@@ -0,0 +1,493 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/kpango/gache"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+func TestNewAccessService(t *testing.T) {
+	type test struct {
+		name      string
+		cfg       config.Access
+		checkFunc func(got AccessService) error
+		wantErr   error
+	}
+	tests := []test{
+		{
+			name: "NewAccessService return correct",
+			cfg: config.Access{
+				TokenExpiry:             "5s",
+				AthenzURL:               "dummy",
+				PrincipalAuthHeaderName: "dummyAuthHeader",
+				RefreshInterval:         "1s",
+			},
+			checkFunc: func(got AccessService) error {
+				a := got.(*accessService)
+				if a.athenzURL != "dummy" ||
+					a.athenzPrincipleHeader != "dummyAuthHeader" ||
+					a.expiry != time.Second*5 ||
+					a.refreshInterval != time.Second ||
+					a.errRetryMaxCount != defaultErrRetryMaxCount ||
+					a.errRetryInterval != defaultErrRetryInterval ||
+					a.httpClient != http.DefaultClient {
+					return fmt.Errorf("got: %+v", a)
+				}
+				return nil
+			},
+		},
+		{
+			name: "NewAccessService return error with TokenExpiry of invalid format",
+			cfg: config.Access{
+				TokenExpiry: "1x",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `TokenExpiry: time: unknown unit "x" in duration "1x"`),
+		},
+		{
+			name: "NewAccessService return error when refresh interval > token expiry",
+			cfg: config.Access{
+				RefreshInterval: "60s",
+				TokenExpiry:     "1s",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "refresh interval > token expiry time"),
+		},
+		{
+			name: "NewAccessService return error with ErrRetryMaxCount < 0",
+			cfg: config.Access{
+				ErrRetryMaxCount: -1,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewAccessService(tt.cfg, nil)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("error not the same, want: %v, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewAccessService() error: %v", err)
+				return
+			}
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("NewAccessService() %v", err)
+			}
+		})
+	}
+}
+
+func Test_accessService_getAccessToken(t *testing.T) {
+	type test struct {
+		name      string
+		service   func() *accessService
+		domain    string
+		role      string
+		principal string
+		expiresIn int64
+		want      *AccessTokenResponse
+		wantErr   error
+		afterFunc func()
+	}
+	tests := []test{
+		func() test {
+			tokenCache := gache.New()
+			tokenCache.Set(accessKey("dummyDomain", "dummyRole", "", 0), &accessCacheData{
+				token: &AccessTokenResponse{
+					AccessToken: "cachedToken",
+					TokenType:   "Bearer",
+				},
+				expiry: fastime.Now().Add(time.Hour).Unix(),
+			})
+			return test{
+				name: "getAccessToken return the cached token",
+				service: func() *accessService {
+					return &accessService{
+						tokenCache: tokenCache,
+					}
+				},
+				domain: "dummyDomain",
+				role:   "dummyRole",
+				want: &AccessTokenResponse{
+					AccessToken: "cachedToken",
+					TokenType:   "Bearer",
+					ExpiresIn:   3600,
+				},
+			}
+		}(),
+		func() test {
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"access_token":"newToken","token_type":"Bearer","expires_in":3600}`)
+			}))
+			tokenCache := gache.New()
+			return test{
+				name: "getAccessToken fetch the token when it is not cached",
+				service: func() *accessService {
+					return &accessService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						tokenCache:            tokenCache,
+						httpClient:            dummyServer.Client(),
+					}
+				},
+				domain: "dummyDomain",
+				role:   "dummyRole",
+				want: &AccessTokenResponse{
+					AccessToken: "newToken",
+					TokenType:   "Bearer",
+					ExpiresIn:   3600,
+				},
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+		func() test {
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"access_token":"newToken","token_type":"Bearer","expires_in":600}`)
+			}))
+			tokenCache := gache.New()
+			tokenCache.Set(accessKey("dummyDomain", "dummyRole", "", 0), &accessCacheData{
+				token: &AccessTokenResponse{
+					AccessToken: "cachedToken",
+					TokenType:   "Bearer",
+				},
+				expiry: fastime.Now().Add(time.Hour).Unix(),
+			})
+			return test{
+				name: "getAccessToken fetch the token when it is cached with the other expiry",
+				service: func() *accessService {
+					return &accessService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						tokenCache:            tokenCache,
+						httpClient:            dummyServer.Client(),
+					}
+				},
+				domain:    "dummyDomain",
+				role:      "dummyRole",
+				expiresIn: 600,
+				want: &AccessTokenResponse{
+					AccessToken: "newToken",
+					TokenType:   "Bearer",
+					ExpiresIn:   600,
+				},
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+		func() test {
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"access_token":"newToken","token_type":"Bearer"}`)
+			}))
+			return test{
+				name: "getAccessToken assume the requested lifetime when expires_in is missing",
+				service: func() *accessService {
+					return &accessService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						tokenCache:            gache.New(),
+						expiry:                time.Hour,
+						httpClient:            dummyServer.Client(),
+					}
+				},
+				domain: "dummyDomain",
+				role:   "dummyRole",
+				want: &AccessTokenResponse{
+					AccessToken: "newToken",
+					TokenType:   "Bearer",
+					ExpiresIn:   3600,
+				},
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+		func() test {
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, `{"access_token":"newToken","token_type":"Bearer","expires_in":-1}`)
+			}))
+			return test{
+				name: "getAccessToken return error when the lifetime is unknown",
+				service: func() *accessService {
+					return &accessService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						tokenCache:            gache.New(),
+						httpClient:            dummyServer.Client(),
+					}
+				},
+				domain:  "dummyDomain",
+				role:    "dummyRole",
+				wantErr: ErrInvalidAccessTokenExpiry,
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+		func() test {
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			}))
+			return test{
+				name: "getAccessToken return error when athenz return error",
+				service: func() *accessService {
+					return &accessService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						tokenCache:            gache.New(),
+						httpClient:            dummyServer.Client(),
+					}
+				},
+				domain:  "dummyDomain",
+				role:    "dummyRole",
+				wantErr: ErrAccessTokenRequestFailed,
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.afterFunc != nil {
+				defer tt.afterFunc()
+			}
+			got, err := tt.service().getAccessToken(context.Background(), tt.domain, tt.role, tt.principal, tt.expiresIn)
+			if err != tt.wantErr {
+				t.Errorf("getAccessToken() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			// ExpiresIn is calculated from the current time, allow 1 second delta
+			if got != nil && tt.want != nil && tt.want.ExpiresIn-got.ExpiresIn <= 1 {
+				got.ExpiresIn = tt.want.ExpiresIn
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getAccessToken() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_accessService_RefreshAccessTokenCache(t *testing.T) {
+	t.Run("RefreshAccessTokenCache update the cached token", func(t *testing.T) {
+		dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"access_token":"newToken","token_type":"Bearer","expires_in":3600}`)
+		}))
+		defer dummyServer.Close()
+
+		tokenCache := gache.New()
+		tokenCache.Set(accessKey("dummyDomain", "dummyRole", "dummyProxy", 0), &accessCacheData{
+			token: &AccessTokenResponse{
+				AccessToken: "oldToken",
+			},
+			domain:            "dummyDomain",
+			role:              "dummyRole",
+			proxyForPrincipal: "dummyProxy",
+		})
+
+		a := &accessService{
+			token: func() (string, error) {
+				return "dummyNToken", nil
+			},
+			athenzURL:             dummyServer.URL,
+			athenzPrincipleHeader: "Athenz-Principal",
+			tokenCache:            tokenCache,
+			httpClient:            dummyServer.Client(),
+			errRetryMaxCount:      1,
+			errRetryInterval:      time.Millisecond,
+		}
+
+		for err := range a.RefreshAccessTokenCache(context.Background()) {
+			t.Errorf("RefreshAccessTokenCache() error: %v", err)
+		}
+
+		val, ok := tokenCache.Get(accessKey("dummyDomain", "dummyRole", "dummyProxy", 0))
+		if !ok {
+			t.Error("token is not cached")
+			return
+		}
+		if got := val.(*accessCacheData).token.AccessToken; got != "newToken" {
+			t.Errorf("RefreshAccessTokenCache() cached token = %v, want newToken", got)
+		}
+	})
+}
+
+func Test_accessService_updateAccessTokenWithRetry(t *testing.T) {
+	dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer dummyServer.Close()
+
+	newService := func(errRetryMaxCount int) *accessService {
+		return &accessService{
+			token: func() (string, error) {
+				return "dummyNToken", nil
+			},
+			athenzURL:             dummyServer.URL,
+			athenzPrincipleHeader: "Athenz-Principal",
+			tokenCache:            gache.New(),
+			httpClient:            dummyServer.Client(),
+			errRetryMaxCount:      errRetryMaxCount,
+			errRetryInterval:      time.Hour,
+		}
+	}
+
+	t.Run("updateAccessTokenWithRetry does not wait after the last attempt", func(t *testing.T) {
+		got := 0
+		for range newService(0).updateAccessTokenWithRetry(context.Background(), "dummyDomain", "dummyRole", "", 0) {
+			got++
+		}
+		if got != 1 {
+			t.Errorf("updateAccessTokenWithRetry() errors = %v, want 1", got)
+		}
+	})
+
+	t.Run("updateAccessTokenWithRetry stops waiting when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		echan := newService(3).updateAccessTokenWithRetry(ctx, "dummyDomain", "dummyRole", "", 0)
+		<-echan
+		cancel()
+		for range echan {
+		}
+	})
+}
+
+func Test_accessService_createPostAccessTokenRequest(t *testing.T) {
+	type test struct {
+		name              string
+		expiry            time.Duration
+		domain            string
+		role              string
+		proxyForPrincipal string
+		expiresIn         int64
+		wantBody          string
+	}
+	tests := []test{
+		{
+			name:     "createPostAccessTokenRequest with domain scope",
+			domain:   "dummyDomain",
+			wantBody: "grant_type=client_credentials&scope=dummyDomain%3Adomain",
+		},
+		{
+			name:              "createPostAccessTokenRequest with roles, proxy for principal and expiry",
+			expiry:            time.Minute,
+			domain:            "dummyDomain",
+			role:              "role1,role2",
+			proxyForPrincipal: "dummyProxy",
+			wantBody:          "expires_in=60&grant_type=client_credentials&proxy_for_principal=dummyProxy&scope=dummyDomain%3Arole.role1+dummyDomain%3Arole.role2",
+		},
+		{
+			name:      "createPostAccessTokenRequest with user specified expiry",
+			expiry:    time.Minute,
+			domain:    "dummyDomain",
+			role:      "role1",
+			expiresIn: 120,
+			wantBody:  "expires_in=120&grant_type=client_credentials&scope=dummyDomain%3Arole.role1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &accessService{
+				athenzURL:             "https://dummy/zts/v1",
+				athenzPrincipleHeader: "Athenz-Principal",
+				expiry:                tt.expiry,
+			}
+			req, err := a.createPostAccessTokenRequest(tt.domain, tt.role, tt.proxyForPrincipal, tt.expiresIn, "dummyNToken")
+			if err != nil {
+				t.Errorf("createPostAccessTokenRequest() error: %v", err)
+				return
+			}
+			if req.Method != http.MethodPost || req.URL.String() != "https://dummy/zts/v1/oauth2/token" {
+				t.Errorf("createPostAccessTokenRequest() = %v %v", req.Method, req.URL)
+			}
+			if got := req.Header.Get("Athenz-Principal"); got != "dummyNToken" {
+				t.Errorf("createPostAccessTokenRequest() n-token header = %v", got)
+			}
+			if got := req.Header.Get("Content-Type"); got != "application/x-www-form-urlencoded" {
+				t.Errorf("createPostAccessTokenRequest() content type = %v", got)
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("createPostAccessTokenRequest() body = %v, want %v", string(body), tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_createScope(t *testing.T) {
+	tests := []struct {
+		name   string
+		domain string
+		role   string
+		want   string
+	}{
+		{
+			name:   "domain scope when role is empty",
+			domain: "dummyDomain",
+			want:   "dummyDomain:domain",
+		},
+		{
+			name:   "single role scope",
+			domain: "dummyDomain",
+			role:   "role1",
+			want:   "dummyDomain:role.role1",
+		},
+		{
+			name:   "multiple role scope",
+			domain: "dummyDomain",
+			role:   "role1,role2",
+			want:   "dummyDomain:role.role1 dummyDomain:role.role2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := createScope(tt.domain, tt.role); got != tt.want {
+				t.Errorf("createScope() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
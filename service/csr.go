@@ -0,0 +1,134 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidPrivateKey represents an error that the private key cannot be parsed.
+	ErrInvalidPrivateKey = errors.New("Invalid private key")
+
+	// ErrInvalidCertificate represents an error that the certificate cannot be parsed.
+	ErrInvalidCertificate = errors.New("Invalid certificate")
+)
+
+// csrTemplate represents the information to generate the certificate signing request.
+type csrTemplate struct {
+	commonName string
+	dnsNames   []string
+	emails     []string
+	uris       []*url.URL
+}
+
+// loadPrivateKey returns the private key read from the PEM file on the path.
+// PKCS#1 RSA, SEC 1 EC and PKCS#8 encoded private keys are supported.
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePrivateKey(b)
+}
+
+// parsePrivateKey returns the private key decoded from the PEM encoded data.
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrInvalidPrivateKey
+	}
+
+	var (
+		k   interface{}
+		err error
+	)
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		k, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		k, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		k, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.Wrap(ErrInvalidPrivateKey, "unsupported PEM type "+block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := k.(crypto.Signer)
+	if !ok {
+		return nil, ErrInvalidPrivateKey
+	}
+	return signer, nil
+}
+
+// createCSR returns the PEM encoded certificate signing request signed by the key.
+func createCSR(key crypto.Signer, t csrTemplate) (string, error) {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{
+			CommonName: t.commonName,
+		},
+		DNSNames:       t.dnsNames,
+		EmailAddresses: t.emails,
+		URIs:           t.uris,
+	}, key)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE REQUEST",
+		Bytes: der,
+	})), nil
+}
+
+// certNotAfter returns the expiry time of the first certificate in the PEM encoded data.
+func certNotAfter(cert string) (time.Time, error) {
+	block, _ := pem.Decode([]byte(cert))
+	if block == nil {
+		return time.Time{}, ErrInvalidCertificate
+	}
+
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return c.NotAfter, nil
+}
+
+// nextCertRefresh returns the duration to wait before refreshing the certificate expiring at notAfter.
+// The certificate is refreshed refreshBefore the expiry, or at half of the remaining lifetime if the remaining lifetime is shorter than refreshBefore.
+func nextCertRefresh(now, notAfter time.Time, refreshBefore time.Duration) time.Duration {
+	remain := notAfter.Sub(now)
+	if remain <= 0 {
+		return 0
+	}
+	if remain <= refreshBefore {
+		return remain / 2
+	}
+	return remain - refreshBefore
+}
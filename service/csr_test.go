@@ -0,0 +1,216 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_loadPrivateKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{
+			name: "load RSA private key",
+			path: "./assets/dummyServer.key",
+		},
+		{
+			name:    "file not exists",
+			path:    "./assets/notExists.key",
+			wantErr: true,
+		},
+		{
+			name:    "file is not a private key",
+			path:    "./assets/dummyServer.crt",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadPrivateKey(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadPrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr && got == nil {
+				t.Error("loadPrivateKey() returns nil key")
+			}
+		})
+	}
+}
+
+func Test_parsePrivateKey(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDER, _ := x509.MarshalECPrivateKey(ecKey)
+	pkcs8DER, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{
+			name: "parse EC private key",
+			data: pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER}),
+		},
+		{
+			name: "parse PKCS#8 private key",
+			data: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8DER}),
+		},
+		{
+			name:    "not PEM encoded",
+			data:    []byte("dummy"),
+			wantErr: ErrInvalidPrivateKey,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePrivateKey(tt.data)
+			if err != tt.wantErr {
+				t.Errorf("parsePrivateKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr == nil && !reflect.DeepEqual(got.Public(), ecKey.Public()) {
+				t.Errorf("parsePrivateKey() public key not match")
+			}
+		})
+	}
+}
+
+func Test_createCSR(t *testing.T) {
+	key, err := loadPrivateKey("./assets/dummyServer.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := csrTemplate{
+		commonName: "dummyDomain.dummyService",
+		dnsNames:   []string{"dummyService.dummyDomain.athenz.cloud"},
+		emails:     []string{"dummyDomain.dummyService@athenz.cloud"},
+		uris: []*url.URL{
+			{Scheme: "spiffe", Host: "dummyDomain", Path: "/sa/dummyService"},
+		},
+	}
+
+	got, err := createCSR(key, tmpl)
+	if err != nil {
+		t.Errorf("createCSR() error = %v", err)
+		return
+	}
+
+	block, _ := pem.Decode([]byte(got))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		t.Errorf("createCSR() returns invalid PEM: %v", got)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Errorf("createCSR() returns invalid CSR: %v", err)
+		return
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Errorf("createCSR() returns invalid signature: %v", err)
+	}
+	if csr.Subject.CommonName != tmpl.commonName ||
+		!reflect.DeepEqual(csr.DNSNames, tmpl.dnsNames) ||
+		!reflect.DeepEqual(csr.EmailAddresses, tmpl.emails) ||
+		len(csr.URIs) != 1 || csr.URIs[0].String() != "spiffe://dummyDomain/sa/dummyService" {
+		t.Errorf("createCSR() = %+v, want %+v", csr, tmpl)
+	}
+}
+
+func Test_certNotAfter(t *testing.T) {
+	cert, err := ioutil.ReadFile("./assets/dummyServer.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cert    string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name: "get expiry time of the certificate",
+			cert: string(cert),
+			want: time.Date(2028, time.September, 15, 3, 12, 42, 0, time.UTC),
+		},
+		{
+			name:    "invalid certificate",
+			cert:    "dummy",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := certNotAfter(tt.cert)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("certNotAfter() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("certNotAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_nextCertRefresh(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name          string
+		notAfter      time.Time
+		refreshBefore time.Duration
+		want          time.Duration
+	}{
+		{
+			name:          "refresh before the expiry",
+			notAfter:      now.Add(time.Hour * 48),
+			refreshBefore: time.Hour * 24,
+			want:          time.Hour * 24,
+		},
+		{
+			name:          "refresh at half of the remaining lifetime",
+			notAfter:      now.Add(time.Hour),
+			refreshBefore: time.Hour * 24,
+			want:          time.Minute * 30,
+		},
+		{
+			name:          "already expired",
+			notAfter:      now.Add(-time.Hour),
+			refreshBefore: time.Hour * 24,
+			want:          0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextCertRefresh(now, tt.notAfter, tt.refreshBefore); got != tt.want {
+				t.Errorf("nextCertRefresh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
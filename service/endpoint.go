@@ -0,0 +1,134 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+)
+
+// endpointPool represent the athenz server endpoints to send the requests to.
+// The healthy endpoints are tried first in the order of the strategy, and the unhealthy endpoints are tried as the last resort.
+type endpointPool struct {
+	endpoints         []*endpoint
+	roundRobin        bool
+	unhealthyDuration time.Duration
+
+	// next represents the index of the first endpoint of the next round robin order, accessed atomically.
+	next uint32
+}
+
+// endpoint represent an athenz server endpoint.
+type endpoint struct {
+	host string
+
+	// breaker stops the requests to the endpoint when it is down, nil implies disabled.
+	breaker *circuitBreaker
+
+	// unhealthyUntil represents the time in unix nano until the endpoint is regarded as unhealthy, accessed atomically.
+	unhealthyUntil int64
+}
+
+var (
+	// ErrNoAthenzEndpoint represent an error when no athenz URL is configured.
+	ErrNoAthenzEndpoint = errors.New("no athenz URL is configured")
+)
+
+const (
+	// endpointPriority represents the strategy to try the endpoints in the configured order.
+	endpointPriority = "priority"
+	// endpointRoundRobin represents the strategy to rotate the first endpoint on each request.
+	endpointRoundRobin = "round_robin"
+
+	// defaultUnhealthyDuration represents the default duration to avoid the endpoint after it fails.
+	defaultUnhealthyDuration = time.Second * 30
+)
+
+// newEndpointPool returns the endpoint pool of urls chosen by strategy.
+// The URL scheme is ignored since the requests are always sent by HTTPS.
+func newEndpointPool(urls []string, strategy string, unhealthyDuration time.Duration) (*endpointPool, error) {
+	p := &endpointPool{
+		endpoints:         make([]*endpoint, 0, len(urls)),
+		unhealthyDuration: defaultUnhealthyDuration,
+	}
+
+	switch strategy {
+	case "", endpointPriority:
+	case endpointRoundRobin:
+		p.roundRobin = true
+	default:
+		return nil, errors.Wrapf(ErrInvalidSetting, "AthenzURLStrategy: unknown strategy %q", strategy)
+	}
+
+	if unhealthyDuration > 0 {
+		p.unhealthyDuration = unhealthyDuration
+	} else if unhealthyDuration != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "UnhealthyDuration < 0")
+	}
+
+	for _, u := range urls {
+		p.endpoints = append(p.endpoints, &endpoint{
+			host: strings.TrimPrefix(strings.TrimPrefix(u, "https://"), "http://"),
+		})
+	}
+	return p, nil
+}
+
+// order returns the endpoints in the order to try for a request.
+func (p *endpointPool) order() []*endpoint {
+	n := len(p.endpoints)
+	if n == 0 {
+		return nil
+	}
+
+	start := 0
+	if p.roundRobin {
+		start = int((atomic.AddUint32(&p.next, 1) - 1) % uint32(n))
+	}
+
+	now := fastime.Now().UnixNano()
+	eps := make([]*endpoint, 0, n)
+	var unhealthy []*endpoint
+	for i := 0; i < n; i++ {
+		e := p.endpoints[(start+i)%n]
+		if atomic.LoadInt64(&e.unhealthyUntil) > now {
+			unhealthy = append(unhealthy, e)
+			continue
+		}
+		eps = append(eps, e)
+	}
+	return append(eps, unhealthy...)
+}
+
+// markUnhealthy makes e to be tried after the healthy endpoints until the unhealthy duration passes.
+func (p *endpointPool) markUnhealthy(e *endpoint) {
+	now := fastime.Now()
+	if atomic.SwapInt64(&e.unhealthyUntil, now.Add(p.unhealthyDuration).UnixNano()) <= now.UnixNano() {
+		glg.Warnf("athenz server is marked as unhealthy, host: %s", e.host)
+	}
+}
+
+// markHealthy makes e to be tried in the order of the strategy again.
+func (p *endpointPool) markHealthy(e *endpoint) {
+	if atomic.SwapInt64(&e.unhealthyUntil, 0) != 0 {
+		glg.Infof("athenz server is recovered, host: %s", e.host)
+	}
+}
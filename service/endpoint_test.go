@@ -0,0 +1,156 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newTestEndpointPool returns the endpoint pool of urls with the default settings.
+func newTestEndpointPool(urls ...string) *endpointPool {
+	p, _ := newEndpointPool(urls, endpointPriority, 0)
+	return p
+}
+
+func Test_newEndpointPool(t *testing.T) {
+	type args struct {
+		urls              []string
+		strategy          string
+		unhealthyDuration time.Duration
+	}
+	type test struct {
+		name    string
+		args    args
+		want    *endpointPool
+		wantErr error
+	}
+	tests := []test{
+		{
+			name: "newEndpointPool return the priority pool",
+			args: args{
+				urls: []string{"https://zts1.athenz.io:4443/zts/v1", "http://zts2.athenz.io/zts/v1", "zts3.athenz.io"},
+			},
+			want: &endpointPool{
+				endpoints: []*endpoint{
+					{host: "zts1.athenz.io:4443/zts/v1"},
+					{host: "zts2.athenz.io/zts/v1"},
+					{host: "zts3.athenz.io"},
+				},
+				unhealthyDuration: defaultUnhealthyDuration,
+			},
+		},
+		{
+			name: "newEndpointPool return the round robin pool",
+			args: args{
+				urls:              []string{"zts1.athenz.io"},
+				strategy:          endpointRoundRobin,
+				unhealthyDuration: time.Minute,
+			},
+			want: &endpointPool{
+				endpoints: []*endpoint{
+					{host: "zts1.athenz.io"},
+				},
+				roundRobin:        true,
+				unhealthyDuration: time.Minute,
+			},
+		},
+		{
+			name: "newEndpointPool return error with unknown strategy",
+			args: args{
+				strategy: "random",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "AthenzURLStrategy: unknown strategy \"random\""),
+		},
+		{
+			name: "newEndpointPool return error with negative unhealthy duration",
+			args: args{
+				unhealthyDuration: -time.Second,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "UnhealthyDuration < 0"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newEndpointPool(tt.args.urls, tt.args.strategy, tt.args.unhealthyDuration)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("newEndpointPool() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("newEndpointPool() error = %v", err)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("newEndpointPool() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_endpointPool_order(t *testing.T) {
+	hosts := func(eps []*endpoint) []string {
+		hs := make([]string, 0, len(eps))
+		for _, e := range eps {
+			hs = append(hs, e.host)
+		}
+		return hs
+	}
+
+	// priority
+	p := newTestEndpointPool("a", "b", "c")
+	for i := 0; i < 2; i++ {
+		if got, want := hosts(p.order()), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("order() with priority = %v, want %v", got, want)
+		}
+	}
+
+	// the unhealthy endpoint is tried last
+	p.markUnhealthy(p.endpoints[0])
+	if got, want := hosts(p.order()), []string{"b", "c", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order() with unhealthy endpoint = %v, want %v", got, want)
+	}
+	p.markHealthy(p.endpoints[0])
+	if got, want := hosts(p.order()), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order() with recovered endpoint = %v, want %v", got, want)
+	}
+
+	// the unhealthy endpoint is tried in order again after the unhealthy duration
+	p.unhealthyDuration = time.Millisecond * 10
+	p.markUnhealthy(p.endpoints[0])
+	time.Sleep(time.Millisecond * 20)
+	if got, want := hosts(p.order()), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order() after unhealthy duration = %v, want %v", got, want)
+	}
+
+	// round robin
+	p, _ = newEndpointPool([]string{"a", "b", "c"}, endpointRoundRobin, 0)
+	for _, want := range [][]string{{"a", "b", "c"}, {"b", "c", "a"}, {"c", "a", "b"}, {"a", "b", "c"}} {
+		if got := hosts(p.order()); !reflect.DeepEqual(got, want) {
+			t.Errorf("order() with round robin = %v, want %v", got, want)
+		}
+	}
+
+	// no endpoint
+	if got := newTestEndpointPool().order(); len(got) != 0 {
+		t.Errorf("order() without endpoint = %v, want empty", got)
+	}
+}
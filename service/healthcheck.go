@@ -0,0 +1,143 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"sort"
+	"sync"
+
+	ntokend "github.com/kpango/ntokend"
+)
+
+// HealthCheck represents a function to check the status of a component, and returns nil if the component is healthy.
+type HealthCheck func() error
+
+// HealthChecker represents a registry of the liveness and readiness checks.
+type HealthChecker interface {
+	// RegisterLiveness registers the check to both liveness and readiness.
+	RegisterLiveness(name string, check HealthCheck)
+	// RegisterReadiness registers the check to readiness only.
+	RegisterReadiness(name string, check HealthCheck)
+	// Liveness runs the liveness checks and returns the result.
+	Liveness() HealthStatus
+	// Readiness runs the liveness and readiness checks and returns the result.
+	Readiness() HealthStatus
+}
+
+// HealthStatus represents the result of the health checks.
+type HealthStatus struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// CheckResult represents the result of a health check.
+type CheckResult struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthChecker represents the implementation of HealthChecker.
+type healthChecker struct {
+	mu        sync.RWMutex
+	liveness  map[string]HealthCheck
+	readiness map[string]HealthCheck
+}
+
+const (
+	// StatusOK represents the status of the healthy check.
+	StatusOK = "ok"
+
+	// StatusFail represents the status of the failed check.
+	StatusFail = "fail"
+)
+
+// NewHealthChecker returns an empty HealthChecker.
+func NewHealthChecker() HealthChecker {
+	return &healthChecker{
+		liveness:  make(map[string]HealthCheck),
+		readiness: make(map[string]HealthCheck),
+	}
+}
+
+// RegisterLiveness registers the check to both liveness and readiness. The check registered with the same name will be replaced.
+func (h *healthChecker) RegisterLiveness(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.liveness[name] = check
+}
+
+// RegisterReadiness registers the check to readiness only. The check registered with the same name will be replaced.
+func (h *healthChecker) RegisterReadiness(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.readiness[name] = check
+}
+
+// Liveness runs the liveness checks and returns the result.
+func (h *healthChecker) Liveness() HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return runChecks(h.liveness)
+}
+
+// Readiness runs the liveness and readiness checks and returns the result.
+func (h *healthChecker) Readiness() HealthStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	checks := make(map[string]HealthCheck, len(h.liveness)+len(h.readiness))
+	for name, check := range h.liveness {
+		checks[name] = check
+	}
+	for name, check := range h.readiness {
+		checks[name] = check
+	}
+	return runChecks(checks)
+}
+
+// runChecks runs all the checks, and returns the results sorted by the check name.
+// The status is StatusFail if any of the checks failed.
+func runChecks(checks map[string]HealthCheck) HealthStatus {
+	hs := HealthStatus{
+		Status: StatusOK,
+		Checks: make([]CheckResult, 0, len(checks)),
+	}
+	for name, check := range checks {
+		r := CheckResult{
+			Name:   name,
+			Status: StatusOK,
+		}
+		if err := check(); err != nil {
+			r.Status = StatusFail
+			r.Error = err.Error()
+			hs.Status = StatusFail
+		}
+		hs.Checks = append(hs.Checks, r)
+	}
+	sort.Slice(hs.Checks, func(i, j int) bool {
+		return hs.Checks[i].Name < hs.Checks[j].Name
+	})
+	return hs
+}
+
+// TokenCheck returns a HealthCheck which fails when the n-token cannot be provided.
+func TokenCheck(token ntokend.TokenProvider) HealthCheck {
+	return func() error {
+		_, err := token()
+		return err
+	}
+}
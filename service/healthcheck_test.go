@@ -0,0 +1,189 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	ntokend "github.com/kpango/ntokend"
+)
+
+func Test_healthChecker_Liveness(t *testing.T) {
+	type test struct {
+		name string
+		hc   func() HealthChecker
+		want HealthStatus
+	}
+	tests := []test{
+		{
+			name: "Liveness return ok without any check",
+			hc:   NewHealthChecker,
+			want: HealthStatus{
+				Status: StatusOK,
+				Checks: []CheckResult{},
+			},
+		},
+		{
+			name: "Liveness run only liveness checks",
+			hc: func() HealthChecker {
+				hc := NewHealthChecker()
+				hc.RegisterLiveness("live", func() error {
+					return nil
+				})
+				hc.RegisterReadiness("ready", func() error {
+					return fmt.Errorf("dummy error")
+				})
+				return hc
+			},
+			want: HealthStatus{
+				Status: StatusOK,
+				Checks: []CheckResult{
+					{Name: "live", Status: StatusOK},
+				},
+			},
+		},
+		{
+			name: "Liveness return fail when liveness check failed",
+			hc: func() HealthChecker {
+				hc := NewHealthChecker()
+				hc.RegisterLiveness("live", func() error {
+					return fmt.Errorf("dummy error")
+				})
+				return hc
+			},
+			want: HealthStatus{
+				Status: StatusFail,
+				Checks: []CheckResult{
+					{Name: "live", Status: StatusFail, Error: "dummy error"},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hc().Liveness(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Liveness() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_healthChecker_Readiness(t *testing.T) {
+	type test struct {
+		name string
+		hc   func() HealthChecker
+		want HealthStatus
+	}
+	tests := []test{
+		{
+			name: "Readiness run both liveness and readiness checks sorted by name",
+			hc: func() HealthChecker {
+				hc := NewHealthChecker()
+				hc.RegisterReadiness("ready", func() error {
+					return nil
+				})
+				hc.RegisterLiveness("live", func() error {
+					return nil
+				})
+				return hc
+			},
+			want: HealthStatus{
+				Status: StatusOK,
+				Checks: []CheckResult{
+					{Name: "live", Status: StatusOK},
+					{Name: "ready", Status: StatusOK},
+				},
+			},
+		},
+		{
+			name: "Readiness return fail when any check failed",
+			hc: func() HealthChecker {
+				hc := NewHealthChecker()
+				hc.RegisterLiveness("live", func() error {
+					return nil
+				})
+				hc.RegisterReadiness("ready", func() error {
+					return fmt.Errorf("dummy error")
+				})
+				return hc
+			},
+			want: HealthStatus{
+				Status: StatusFail,
+				Checks: []CheckResult{
+					{Name: "live", Status: StatusOK},
+					{Name: "ready", Status: StatusFail, Error: "dummy error"},
+				},
+			},
+		},
+		{
+			name: "Readiness replace the check registered with the same name",
+			hc: func() HealthChecker {
+				hc := NewHealthChecker()
+				hc.RegisterReadiness("ready", func() error {
+					return fmt.Errorf("dummy error")
+				})
+				hc.RegisterReadiness("ready", func() error {
+					return nil
+				})
+				return hc
+			},
+			want: HealthStatus{
+				Status: StatusOK,
+				Checks: []CheckResult{
+					{Name: "ready", Status: StatusOK},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hc().Readiness(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Readiness() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   ntokend.TokenProvider
+		wantErr error
+	}{
+		{
+			name: "TokenCheck return nil when the n-token is provided",
+			token: func() (string, error) {
+				return "dummyToken", nil
+			},
+		},
+		{
+			name: "TokenCheck return error when the n-token is not provided",
+			token: func() (string, error) {
+				return "", ntokend.ErrTokenNotFound
+			},
+			wantErr: ntokend.ErrTokenNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := TokenCheck(tt.token)(); err != tt.wantErr {
+				t.Errorf("TokenCheck() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	// ErrInvalidName represent an error when the domain, role or principal name does not follow the Athenz naming rules.
+	ErrInvalidName = errors.New("invalid name")
+
+	// compoundNamePattern represents the Athenz compound name, the simple names joined with ".", e.g. "domain.sub_domain".
+	compoundNamePattern = regexp.MustCompile(`^([a-zA-Z0-9_][a-zA-Z0-9_-]*\.)*[a-zA-Z0-9_][a-zA-Z0-9_-]*$`)
+)
+
+const (
+	// maxNameLength represents the maximum length of a domain, role or principal name.
+	maxNameLength = 256
+)
+
+// ValidateDomain returns an error if domain is empty or not a valid Athenz domain name.
+func ValidateDomain(domain string) error {
+	if domain == "" {
+		return errors.Wrap(ErrInvalidName, "domain is required")
+	}
+	if !isCompoundName(domain) {
+		return errors.Wrapf(ErrInvalidName, "domain %q must consist of [a-zA-Z0-9_-] separated by \".\"", domain)
+	}
+	return nil
+}
+
+// ValidateRoles returns an error if role is not empty and not a valid Athenz role name, or the list of them separated by ",".
+func ValidateRoles(role string) error {
+	if role == "" {
+		return nil
+	}
+	for _, r := range strings.Split(role, roleSeparater) {
+		if !isCompoundName(r) {
+			return errors.Wrapf(ErrInvalidName, "role %q must consist of [a-zA-Z0-9_-] separated by \".\"", r)
+		}
+	}
+	return nil
+}
+
+// ValidateRole returns an error if role is empty or not a valid Athenz role name.
+func ValidateRole(role string) error {
+	if role == "" {
+		return errors.Wrap(ErrInvalidName, "role is required")
+	}
+	if !isCompoundName(role) {
+		return errors.Wrapf(ErrInvalidName, "role %q must consist of [a-zA-Z0-9_-] separated by \".\"", role)
+	}
+	return nil
+}
+
+// ValidatePrincipal returns an error if principal is not empty and not a valid Athenz principal name, e.g. "domain.service".
+func ValidatePrincipal(principal string) error {
+	if principal == "" {
+		return nil
+	}
+	if !isCompoundName(principal) {
+		return errors.Wrapf(ErrInvalidName, "proxy for principal %q must consist of [a-zA-Z0-9_-] separated by \".\"", principal)
+	}
+	return nil
+}
+
+// isCompoundName returns whether name is an Athenz compound name within the maximum length.
+func isCompoundName(name string) bool {
+	return len(name) <= maxNameLength && compoundNamePattern.MatchString(name)
+}
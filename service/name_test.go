@@ -0,0 +1,179 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestValidateDomain(t *testing.T) {
+	type test struct {
+		name    string
+		domain  string
+		wantErr bool
+	}
+	tests := []test{
+		{
+			name:   "ValidateDomain accept simple name",
+			domain: "sys_auth-1",
+		},
+		{
+			name:   "ValidateDomain accept sub domain",
+			domain: "domain.shopping.sub_domain",
+		},
+		{
+			name:    "ValidateDomain reject empty",
+			wantErr: true,
+		},
+		{
+			name:    "ValidateDomain reject path",
+			domain:  "../../admin",
+			wantErr: true,
+		},
+		{
+			name:    "ValidateDomain reject query",
+			domain:  "domain?role=admin",
+			wantErr: true,
+		},
+		{
+			name:    "ValidateDomain reject slash",
+			domain:  "domain/role",
+			wantErr: true,
+		},
+		{
+			name:    "ValidateDomain reject leading hyphen",
+			domain:  "-domain",
+			wantErr: true,
+		},
+		{
+			name:    "ValidateDomain reject empty label",
+			domain:  "domain..sub",
+			wantErr: true,
+		},
+		{
+			name:    "ValidateDomain reject too long name",
+			domain:  strings.Repeat("a", maxNameLength+1),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateDomain(tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDomain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && errors.Cause(err) != ErrInvalidName {
+				t.Errorf("ValidateDomain() error = %v, want %v", err, ErrInvalidName)
+			}
+		})
+	}
+}
+
+func TestValidateRoles(t *testing.T) {
+	type test struct {
+		name    string
+		role    string
+		wantErr bool
+	}
+	tests := []test{
+		{
+			name: "ValidateRoles accept empty",
+		},
+		{
+			name: "ValidateRoles accept role list",
+			role: "admin,users.readers",
+		},
+		{
+			name:    "ValidateRoles reject empty role in list",
+			role:    "admin,",
+			wantErr: true,
+		},
+		{
+			name:    "ValidateRoles reject invalid role in list",
+			role:    "admin,users&x=1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRoles(tt.role); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRoles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRole(t *testing.T) {
+	type test struct {
+		name    string
+		role    string
+		wantErr bool
+	}
+	tests := []test{
+		{
+			name: "ValidateRole accept role",
+			role: "users.readers",
+		},
+		{
+			name:    "ValidateRole reject empty",
+			wantErr: true,
+		},
+		{
+			name:    "ValidateRole reject role list",
+			role:    "admin,users",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateRole(tt.role); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateRole() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePrincipal(t *testing.T) {
+	type test struct {
+		name      string
+		principal string
+		wantErr   bool
+	}
+	tests := []test{
+		{
+			name: "ValidatePrincipal accept empty",
+		},
+		{
+			name:      "ValidatePrincipal accept service principal",
+			principal: "domain.travel.travel-site",
+		},
+		{
+			name:      "ValidatePrincipal reject query",
+			principal: "domain.service&role=admin",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidatePrincipal(tt.principal); (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePrincipal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -22,3 +22,27 @@ func WithServerHandler(h http.Handler) Option {
 		s.srvHandler = h
 	}
 }
+
+// WithHealthChecker set the health checker for the liveness and readiness check to server.
+func WithHealthChecker(hc HealthChecker) Option {
+	return func(s *server) {
+		s.hc = hc
+	}
+}
+
+// WithAdminHandler set the handler of the admin server to server.
+func WithAdminHandler(h http.Handler) Option {
+	return func(s *server) {
+		s.admHandler = h
+	}
+}
+
+// RoleOption represents the functional option implementation for the role token service.
+type RoleOption func(*roleService)
+
+// WithRoleCacheKey set the private key path to derive the encryption key of the role token cache snapshot to the role token service.
+func WithRoleCacheKey(path string) RoleOption {
+	return func(r *roleService) {
+		r.snapshotKeyPath = path
+	}
+}
@@ -86,3 +86,118 @@ func TestWithServerHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestWithHealthChecker(t *testing.T) {
+	type args struct {
+		hc HealthChecker
+	}
+	type test struct {
+		name      string
+		args      args
+		checkFunc func(Option) error
+	}
+	tests := []test{
+		func() test {
+			hc := NewHealthChecker()
+			return test{
+				name: "set success",
+				args: args{
+					hc: hc,
+				},
+				checkFunc: func(o Option) error {
+					srv := &server{}
+					o(srv)
+					if srv.hc != hc {
+						return errors.New("value cannot set")
+					}
+					return nil
+				},
+			}
+		}(),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithHealthChecker(tt.args.hc)
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("WithHealthChecker() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestWithRoleCacheKey(t *testing.T) {
+	type args struct {
+		path string
+	}
+	type test struct {
+		name      string
+		args      args
+		checkFunc func(RoleOption) error
+	}
+	tests := []test{
+		{
+			name: "set success",
+			args: args{
+				path: "./assets/dummyServer.key",
+			},
+			checkFunc: func(o RoleOption) error {
+				r := &roleService{}
+				o(r)
+				if r.snapshotKeyPath != "./assets/dummyServer.key" {
+					return errors.New("value cannot set")
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithRoleCacheKey(tt.args.path)
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("WithRoleCacheKey() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestWithAdminHandler(t *testing.T) {
+	type args struct {
+		h http.Handler
+	}
+	type test struct {
+		name      string
+		args      args
+		checkFunc func(Option) error
+	}
+	tests := []test{
+		func() test {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(999)
+			})
+			return test{
+				name: "set success",
+				args: args{
+					h: h,
+				},
+				checkFunc: func(o Option) error {
+					srv := &server{}
+					o(srv)
+					r := &httptest.ResponseRecorder{}
+					srv.admHandler.ServeHTTP(r, nil)
+					if r.Code != 999 {
+						return errors.New("value cannot set")
+					}
+					return nil
+				},
+			}
+		}(),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithAdminHandler(tt.args.h)
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("WithAdminHandler() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+)
+
+// PeerCred represent the credentials of the local process connecting to the unix domain socket, retrieved by SO_PEERCRED.
+type PeerCred struct {
+	// UID represent the user ID of the peer process.
+	UID uint32
+	// GID represent the group ID of the peer process.
+	GID uint32
+	// PID represent the process ID of the peer process.
+	PID int32
+}
+
+// peerCredKey represent the context key of the peer credentials.
+type peerCredKey struct{}
+
+var (
+	// ErrPeerCredUnsupported represent an error when the peer credentials cannot be retrieved on the platform.
+	ErrPeerCredUnsupported = errors.New("peer credentials are not supported on this platform")
+)
+
+// WithPeerCred returns a copy of ctx carrying the peer credentials.
+func WithPeerCred(ctx context.Context, cred *PeerCred) context.Context {
+	return context.WithValue(ctx, peerCredKey{}, cred)
+}
+
+// PeerCredFromContext returns the peer credentials carried by ctx, or nil if the request is not sent via the unix domain socket.
+func PeerCredFromContext(ctx context.Context) *PeerCred {
+	cred, _ := ctx.Value(peerCredKey{}).(*PeerCred)
+	return cred
+}
+
+// String returns the peer credentials for the logs.
+func (p *PeerCred) String() string {
+	if p == nil {
+		return "unknown"
+	}
+	return fmt.Sprintf("uid=%d,gid=%d,pid=%d", p.UID, p.GID, p.PID)
+}
+
+// connContext attaches the peer credentials to the context of the connection accepted from the unix domain socket.
+// It is called once per connection, so the credentials are retrieved at accept time.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	uc, ok := c.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	cred, err := peerCred(uc)
+	if err != nil {
+		glg.Warnf("cannot get the peer credentials of the unix socket connection: %v", err)
+		return ctx
+	}
+	glg.Debugf("accepted unix socket connection from %s", cred)
+	return WithPeerCred(ctx, cred)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func Test_connContext(t *testing.T) {
+	// the connection other than unix domain socket does not have the peer credentials
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	if got := PeerCredFromContext(connContext(context.Background(), c1)); got != nil {
+		t.Errorf("connContext() with pipe = %v, want nil", got)
+	}
+
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is only supported on linux")
+	}
+
+	dir, err := ioutil.TempDir("", "peercred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("unix", filepath.Join(dir, "test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	cli, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	got := PeerCredFromContext(connContext(context.Background(), conn))
+	want := &PeerCred{
+		UID: uint32(os.Getuid()),
+		GID: uint32(os.Getgid()),
+		PID: int32(os.Getpid()),
+	}
+	if got == nil || *got != *want {
+		t.Errorf("connContext() peer credentials = %v, want %v", got, want)
+	}
+}
+
+func TestPeerCred_String(t *testing.T) {
+	var nilCred *PeerCred
+	if got := nilCred.String(); got != "unknown" {
+		t.Errorf("PeerCred.String() = %v, want unknown", got)
+	}
+	if got, want := (&PeerCred{UID: 1, GID: 2, PID: 3}).String(), "uid=1,gid=2,pid=3"; got != want {
+		t.Errorf("PeerCred.String() = %v, want %v", got, want)
+	}
+}
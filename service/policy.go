@@ -0,0 +1,353 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// PolicyService represent a interface to authorize the clients to request the tokens by the policy file.
+type PolicyService interface {
+	GetAuthorizer() Authorizer
+	Reload(cfg config.Policy) error
+	PrepareReload(cfg config.Policy) (func(), error)
+}
+
+// Authorizer represent a interface to authorize the client to request the tokens, and returns ErrPolicyDenied if it is not allowed.
+type Authorizer interface {
+	// AuthorizeNToken authorizes the client to request the n-token.
+	AuthorizeNToken(c *Client) error
+	// AuthorizeSvcCert authorizes the client to request the service certificate.
+	AuthorizeSvcCert(c *Client) error
+	// AuthorizeRole authorizes the client to request the role token, the access token or the role certificate of the domain and the roles.
+	AuthorizeRole(c *Client, domain, role, proxyForPrincipal string) error
+}
+
+// Client represent the identity of the client requesting to client sidecar.
+type Client struct {
+	// IP represent the remote address of the client.
+	IP net.IP
+	// Names represent the common name and the DNS names of the verified client certificate.
+	Names []string
+	// Cred represent the peer credentials of the client connecting via the unix domain socket, nil for the TCP clients.
+	Cred *PeerCred
+}
+
+// policyService represent the implementation of PolicyService.
+type policyService struct {
+	// rules represents the policy rules, nil implies all the requests are allowed.
+	rules []*policyRule
+
+	// mu guards rules which can be replaced by Reload.
+	mu sync.RWMutex
+}
+
+// policyFile represent the content of the policy file.
+type policyFile struct {
+	Clients []policyRule `yaml:"clients"`
+}
+
+// policyRule represent the tokens allowed for the matched clients.
+type policyRule struct {
+	// Name represent the name of the rule, used in the logs.
+	Name string `yaml:"name"`
+
+	// ClientNames represent the patterns of the client certificate name. (empty implies any)
+	ClientNames []string `yaml:"client_names"`
+	// Addresses represent the IP addresses or CIDRs of the client. (empty implies any)
+	Addresses []string `yaml:"addresses"`
+	// UIDs represent the user names or IDs of the peer process connecting via the unix domain socket. (empty implies any)
+	UIDs []string `yaml:"uids"`
+	// GIDs represent the group names or IDs of the peer process connecting via the unix domain socket. (empty implies any)
+	GIDs []string `yaml:"gids"`
+
+	// NToken represent whether the n-token is allowed.
+	NToken bool `yaml:"ntoken"`
+	// ServiceCert represent whether the service certificate is allowed.
+	ServiceCert bool `yaml:"service_cert"`
+
+	// Domains represent the patterns of the domain.
+	Domains []string `yaml:"domains"`
+	// Roles represent the patterns of the role, each requested role must match one of them. "*" also allows the request without role.
+	Roles []string `yaml:"roles"`
+	// ProxyForPrincipals represent the patterns of the proxy for principal. The request without proxy for principal is always allowed.
+	ProxyForPrincipals []string `yaml:"proxy_for_principals"`
+
+	nets []*net.IPNet
+	uids []uint32
+	gids []uint32
+}
+
+var (
+	// ErrPolicyDenied represent an error when the client is not allowed to request the token by the policy.
+	ErrPolicyDenied = errors.New("denied by policy")
+)
+
+// NewPolicyService returns a PolicyService loading the policy file of cfg, or any error occurred.
+// All the requests are allowed if the policy file is not configured.
+func NewPolicyService(cfg config.Policy) (PolicyService, error) {
+	rules, err := loadPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &policyService{
+		rules: rules,
+	}, nil
+}
+
+// GetAuthorizer returns the Authorizer to authorize the client.
+func (p *policyService) GetAuthorizer() Authorizer {
+	return p
+}
+
+// Reload loads the policy file again and replaces the policy rules. The current rules are kept if the new policy file is invalid.
+func (p *policyService) Reload(cfg config.Policy) error {
+	apply, err := p.PrepareReload(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareReload loads the policy file again and returns the function to replace the policy rules. The current rules are kept if the new policy file is invalid.
+func (p *policyService) PrepareReload(cfg config.Policy) (func(), error) {
+	rules, err := loadPolicy(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.rules = rules
+	}, nil
+}
+
+// AuthorizeNToken returns nil if any rule matching the client allows the n-token, otherwise returns ErrPolicyDenied.
+func (p *policyService) AuthorizeNToken(c *Client) error {
+	if p.authorize(c, func(r *policyRule) bool {
+		return r.NToken
+	}) {
+		return nil
+	}
+	return errors.Wrapf(ErrPolicyDenied, "client %s is not allowed to request n-token", c)
+}
+
+// AuthorizeSvcCert returns nil if any rule matching the client allows the service certificate, otherwise returns ErrPolicyDenied.
+func (p *policyService) AuthorizeSvcCert(c *Client) error {
+	if p.authorize(c, func(r *policyRule) bool {
+		return r.ServiceCert
+	}) {
+		return nil
+	}
+	return errors.Wrapf(ErrPolicyDenied, "client %s is not allowed to request service certificate", c)
+}
+
+// AuthorizeRole returns nil if any rule matching the client allows the role token, the access token or the role certificate request, otherwise returns ErrPolicyDenied.
+func (p *policyService) AuthorizeRole(c *Client, domain, role, proxyForPrincipal string) error {
+	if p.authorize(c, func(r *policyRule) bool {
+		return r.allow(domain, role, proxyForPrincipal)
+	}) {
+		return nil
+	}
+	return errors.Wrapf(ErrPolicyDenied, "client %s is not allowed to request domain: %s, role: %s, proxyForPrincipal: %s", c, domain, role, proxyForPrincipal)
+}
+
+// authorize returns whether any rule matching the client satisfies allow.
+func (p *policyService) authorize(c *Client, allow func(*policyRule) bool) bool {
+	p.mu.RLock()
+	rules := p.rules
+	p.mu.RUnlock()
+
+	if rules == nil {
+		return true
+	}
+	for _, r := range rules {
+		if r.matchClient(c) && allow(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the identity of the client for the logs.
+func (c *Client) String() string {
+	if c == nil {
+		return "unknown"
+	}
+	s := c.IP.String()
+	if c.Cred != nil {
+		s = "unix (" + c.Cred.String() + ")"
+	}
+	if len(c.Names) > 0 {
+		s += " (" + strings.Join(c.Names, ",") + ")"
+	}
+	return s
+}
+
+// loadPolicy returns the policy rules of the policy file, or nil if it is not configured.
+func loadPolicy(cfg config.Policy) ([]*policyRule, error) {
+	p := config.GetActualValue(cfg.Path)
+	if p == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read policy file")
+	}
+	var f policyFile
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.SetStrict(true)
+	if err := dec.Decode(&f); err != nil && err != io.EOF {
+		return nil, errors.Wrap(ErrInvalidSetting, "Policy: "+err.Error())
+	}
+
+	// the empty policy denies all the requests
+	rules := make([]*policyRule, 0, len(f.Clients))
+	for i := range f.Clients {
+		r := &f.Clients[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("clients[%d]", i)
+		}
+		if err := r.init(); err != nil {
+			return nil, errors.Wrapf(ErrInvalidSetting, "Policy %s: %v", r.Name, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// init parses the addresses, resolves the user and the group IDs, and checks the patterns of the rule.
+func (r *policyRule) init() error {
+	for _, a := range r.Addresses {
+		if !strings.Contains(a, "/") {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return errors.Errorf("invalid address %q", a)
+			}
+			bits := net.IPv4len * 8
+			if ip.To4() == nil {
+				bits = net.IPv6len * 8
+			}
+			r.nets = append(r.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(a)
+		if err != nil {
+			return err
+		}
+		r.nets = append(r.nets, n)
+	}
+
+	for _, u := range r.UIDs {
+		id, err := lookupID(u, lookupUser)
+		if err != nil || id < 0 {
+			return errors.Errorf("invalid uid %q", u)
+		}
+		r.uids = append(r.uids, uint32(id))
+	}
+	for _, g := range r.GIDs {
+		id, err := lookupID(g, lookupGroup)
+		if err != nil || id < 0 {
+			return errors.Errorf("invalid gid %q", g)
+		}
+		r.gids = append(r.gids, uint32(id))
+	}
+
+	for _, patterns := range [][]string{r.ClientNames, r.Domains, r.Roles, r.ProxyForPrincipals} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Wrapf(err, "pattern %q", pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// matchClient returns whether the rule is applied to the client.
+func (r *policyRule) matchClient(c *Client) bool {
+	if c == nil {
+		return len(r.ClientNames) == 0 && len(r.nets) == 0 && len(r.uids) == 0 && len(r.gids) == 0
+	}
+	if len(r.ClientNames) > 0 && !matchAny(r.ClientNames, c.Names...) {
+		return false
+	}
+	if len(r.uids) > 0 && (c.Cred == nil || !containsID(r.uids, c.Cred.UID)) {
+		return false
+	}
+	if len(r.gids) > 0 && (c.Cred == nil || !containsID(r.gids, c.Cred.GID)) {
+		return false
+	}
+	if len(r.nets) > 0 {
+		for _, n := range r.nets {
+			if c.IP != nil && n.Contains(c.IP) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// allow returns whether the rule allows the role token, the access token or the role certificate request.
+func (r *policyRule) allow(domain, role, proxyForPrincipal string) bool {
+	if !matchAny(r.Domains, domain) {
+		return false
+	}
+	if proxyForPrincipal != "" && !matchAny(r.ProxyForPrincipals, proxyForPrincipal) {
+		return false
+	}
+	for _, ro := range strings.Split(role, roleSeparater) {
+		if !matchAny(r.Roles, ro) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny returns whether any of the names matches any of the patterns.
+func matchAny(patterns []string, names ...string) bool {
+	for _, p := range patterns {
+		for _, n := range names {
+			if ok, _ := path.Match(p, n); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// containsID returns whether ids contains id.
+func containsID(ids []uint32, id uint32) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
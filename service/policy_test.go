@@ -0,0 +1,375 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+// writePolicy writes the policy file to dir and returns its path.
+func writePolicy(t *testing.T, dir, policy string) string {
+	p := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(p, []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewPolicyService(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type test struct {
+		name    string
+		policy  string
+		wantErr bool
+	}
+	tests := []test{
+		{
+			name: "NewPolicyService load policy",
+			policy: `
+clients:
+  - name: frontend
+    client_names: ["frontend.*"]
+    addresses: ["127.0.0.1", "10.0.0.0/8", "::1"]
+    domains: ["domain.shopping"]
+    roles: ["users"]
+  - name: batch
+    uids: [0, "root"]
+    gids: ["0"]
+    ntoken: true
+`,
+		},
+		{
+			name: "NewPolicyService load empty policy",
+		},
+		{
+			name: "NewPolicyService return error with unknown field",
+			policy: `
+clients:
+  - name: frontend
+    domain: ["domain.shopping"]
+`,
+			wantErr: true,
+		},
+		{
+			name: "NewPolicyService return error with invalid address",
+			policy: `
+clients:
+  - addresses: ["localhost"]
+`,
+			wantErr: true,
+		},
+		{
+			name: "NewPolicyService return error with unknown user",
+			policy: `
+clients:
+  - uids: ["no-such-user-for-policy-test"]
+`,
+			wantErr: true,
+		},
+		{
+			name: "NewPolicyService return error with invalid pattern",
+			policy: `
+clients:
+  - roles: ["[users"]
+`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPolicyService(config.Policy{
+				Path: writePolicy(t, dir, tt.policy),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewPolicyService() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := NewPolicyService(config.Policy{Path: filepath.Join(dir, "not_found.yaml")}); err == nil {
+		t.Error("NewPolicyService() with not found file error = nil")
+	}
+}
+
+func Test_policyService_authorize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyService(config.Policy{
+		Path: writePolicy(t, dir, `
+clients:
+  - name: frontend
+    client_names: ["frontend.*"]
+    domains: ["domain.shopping"]
+    roles: ["users", "readers.*"]
+    proxy_for_principals: ["user.*"]
+  - name: local
+    addresses: ["127.0.0.0/8"]
+    domains: ["domain.local.*"]
+    roles: ["*"]
+  - name: batch
+    uids: [1000]
+    gids: [1001, 1002]
+    ntoken: true
+    domains: ["domain.batch"]
+    roles: ["*"]
+  - name: identity
+    client_names: ["identity.*"]
+    service_cert: true
+`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authz := p.GetAuthorizer()
+
+	frontend := &Client{
+		IP:    net.ParseIP("192.168.0.1"),
+		Names: []string{"frontend.example"},
+	}
+	local := &Client{
+		IP: net.ParseIP("127.0.0.1"),
+	}
+	batch := &Client{
+		Cred: &PeerCred{UID: 1000, GID: 1002, PID: 123},
+	}
+	type test struct {
+		name      string
+		client    *Client
+		ntoken    bool
+		svcCert   bool
+		domain    string
+		role      string
+		principal string
+		wantErr   bool
+	}
+	tests := []test{
+		{
+			name:   "authorize allow role list",
+			client: frontend,
+			domain: "domain.shopping",
+			role:   "users,readers.all",
+		},
+		{
+			name:      "authorize allow proxy for principal",
+			client:    frontend,
+			domain:    "domain.shopping",
+			role:      "users",
+			principal: "user.alice",
+		},
+		{
+			name:    "authorize deny role not allowed",
+			client:  frontend,
+			domain:  "domain.shopping",
+			role:    "users,admin",
+			wantErr: true,
+		},
+		{
+			name:    "authorize deny request without role",
+			client:  frontend,
+			domain:  "domain.shopping",
+			wantErr: true,
+		},
+		{
+			name:      "authorize deny proxy for principal not allowed",
+			client:    frontend,
+			domain:    "domain.shopping",
+			role:      "users",
+			principal: "service.backend",
+			wantErr:   true,
+		},
+		{
+			name:    "authorize deny domain not allowed",
+			client:  frontend,
+			domain:  "domain.local.test",
+			role:    "users",
+			wantErr: true,
+		},
+		{
+			name:   "authorize allow by address",
+			client: local,
+			domain: "domain.local.test",
+		},
+		{
+			name:    "authorize deny unknown client",
+			client:  &Client{IP: net.ParseIP("192.168.0.1")},
+			domain:  "domain.local.test",
+			wantErr: true,
+		},
+		{
+			name:   "authorize allow by uid and gid",
+			client: batch,
+			domain: "domain.batch",
+			role:   "writers",
+		},
+		{
+			name:   "authorize allow n-token by uid and gid",
+			client: batch,
+			ntoken: true,
+		},
+		{
+			name:    "authorize deny n-token not allowed",
+			client:  frontend,
+			ntoken:  true,
+			wantErr: true,
+		},
+		{
+			name:    "authorize allow service certificate",
+			client:  &Client{Names: []string{"identity.example"}},
+			svcCert: true,
+		},
+		{
+			name:    "authorize deny service certificate not allowed",
+			client:  batch,
+			svcCert: true,
+			wantErr: true,
+		},
+		{
+			name:    "authorize deny gid not allowed",
+			client:  &Client{Cred: &PeerCred{UID: 1000, GID: 1000}},
+			ntoken:  true,
+			wantErr: true,
+		},
+		{
+			name:    "authorize deny TCP client by uid rule",
+			client:  &Client{IP: net.ParseIP("192.168.0.1")},
+			domain:  "domain.batch",
+			role:    "writers",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			switch {
+			case tt.ntoken:
+				err = authz.AuthorizeNToken(tt.client)
+			case tt.svcCert:
+				err = authz.AuthorizeSvcCert(tt.client)
+			default:
+				err = authz.AuthorizeRole(tt.client, tt.domain, tt.role, tt.principal)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("authorize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && errors.Cause(err) != ErrPolicyDenied {
+				t.Errorf("authorize() error = %v, want %v", err, ErrPolicyDenied)
+			}
+		})
+	}
+}
+
+func Test_policyService_Reload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// all the requests are allowed without the policy file
+	p, err := NewPolicyService(config.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.GetAuthorizer().AuthorizeRole(&Client{}, "domain", "role", ""); err != nil {
+		t.Errorf("authorize() without policy error = %v", err)
+	}
+	if err := p.GetAuthorizer().AuthorizeNToken(&Client{}); err != nil {
+		t.Errorf("AuthorizeNToken() without policy error = %v", err)
+	}
+
+	cfg := config.Policy{
+		Path: writePolicy(t, dir, "clients: []\n"),
+	}
+	// the prepared policy is not applied until the returned function is called
+	apply, err := p.PrepareReload(cfg)
+	if err != nil {
+		t.Fatalf("PrepareReload() error = %v", err)
+	}
+	if err := p.GetAuthorizer().AuthorizeRole(&Client{}, "domain", "role", ""); err != nil {
+		t.Errorf("authorize() before applying the prepared policy error = %v", err)
+	}
+	apply()
+	if err := p.GetAuthorizer().AuthorizeRole(&Client{}, "domain", "role", ""); err == nil {
+		t.Error("authorize() after applying the prepared policy error = nil")
+	}
+
+	if err := p.Reload(cfg); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if err := p.GetAuthorizer().AuthorizeRole(&Client{}, "domain", "role", ""); err == nil {
+		t.Error("authorize() with empty policy error = nil")
+	}
+
+	// the invalid policy file does not replace the current one
+	writePolicy(t, dir, "clients: [")
+	if err := p.Reload(cfg); err == nil {
+		t.Error("Reload() with invalid policy error = nil")
+	}
+	if err := p.GetAuthorizer().AuthorizeRole(&Client{}, "domain", "role", ""); err == nil {
+		t.Error("authorize() after invalid reload error = nil")
+	}
+}
+
+func TestClient_String(t *testing.T) {
+	tests := []struct {
+		name   string
+		client *Client
+		want   string
+	}{
+		{
+			name: "String return unknown for nil",
+			want: "unknown",
+		},
+		{
+			name: "String return address and names",
+			client: &Client{
+				IP:    net.ParseIP("192.0.2.1"),
+				Names: []string{"client.example"},
+			},
+			want: "192.0.2.1 (client.example)",
+		},
+		{
+			name: "String return peer credentials",
+			client: &Client{
+				Cred: &PeerCred{UID: 1000, GID: 1001, PID: 123},
+			},
+			want: "unix (uid=1000,gid=1001,pid=123)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.client.String(); got != tt.want {
+				t.Errorf("Client.String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
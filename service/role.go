@@ -24,11 +24,16 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kpango/fastime"
@@ -37,6 +42,7 @@ import (
 	ntokend "github.com/kpango/ntokend"
 	"github.com/pkg/errors"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
+	"github.com/yahoojapan/athenz-client-sidecar/metrics"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -45,13 +51,26 @@ type RoleService interface {
 	StartRoleUpdater(context.Context) <-chan error
 	RefreshRoleTokenCache(ctx context.Context) <-chan error
 	GetRoleProvider() RoleProvider
+	PrefetchCheck() HealthCheck
+	RefreshCheck() HealthCheck
+	Reload(cfg config.Role) error
+	PrepareReload(cfg config.Role) (func(), error)
+	RoleCacheAdmin
+}
+
+// RoleCacheAdmin represent a interface to inspect and manipulate the role token cache for the administration.
+type RoleCacheAdmin interface {
+	CacheEntries() []RoleCacheEntry
+	RefreshCacheEntry(ctx context.Context, domain, role, proxyForPrincipal string) (*RoleCacheEntry, error)
+	InvalidateCacheEntry(domain, role, proxyForPrincipal string) bool
+	InvalidateAllCacheEntries() int
 }
 
 // roleService represent the implementation of athenz RoleService
 type roleService struct {
 	cfg                   config.Role
 	token                 ntokend.TokenProvider
-	athenzURL             string
+	endpoints             *endpointPool
 	athenzPrincipleHeader string
 	domainRoleCache       gache.Gache
 	group                 singleflight.Group
@@ -61,6 +80,48 @@ type roleService struct {
 	refreshInterval  time.Duration
 	errRetryMaxCount int
 	errRetryInterval time.Duration
+	serveStale       bool
+
+	// errRetryMaxInterval represents the maximum backoff of the retry, 0 implies the refresh interval.
+	errRetryMaxInterval time.Duration
+	refreshRatio        float64
+	refreshJitter       float64
+
+	refreshConcurrency int
+	refreshDeadline    time.Duration
+
+	// inflight limits the number of the concurrent requests to athenz server, nil implies unlimited.
+	inflight chan struct{}
+
+	// prefetch represents the role tokens to keep in the cache even if they are not requested.
+	prefetch []config.PrefetchRole
+
+	// idleTTL represents the duration after the last access to drop the role token from the cache, 0 implies disabled.
+	idleTTL time.Duration
+	// maxCacheEntries represents the maximum number of the cached role tokens, 0 implies unlimited.
+	maxCacheEntries int
+
+	// negativeCacheTTL represents the duration to return the cached client error of athenz server without requesting again, 0 implies disabled.
+	negativeCacheTTL time.Duration
+
+	// mu guards the settings above which can be replaced by Reload.
+	mu sync.RWMutex
+
+	// snapshot persists the role token cache across restarts, nil implies disabled. It is not reloaded.
+	snapshot        *cacheSnapshot
+	snapshotKeyPath string
+
+	// prefetched represents the first prefetch of the role tokens is finished when it is 1, accessed atomically.
+	prefetched int32
+
+	// lastRefresh represents the refreshResult of the last refresh pass.
+	lastRefresh atomic.Value
+
+	// evictMu serializes the LRU eviction.
+	evictMu sync.Mutex
+
+	// negativeCache caches the client errors of athenz server, nil implies disabled.
+	negativeCache gache.Gache
 }
 
 type cacheData struct {
@@ -70,12 +131,58 @@ type cacheData struct {
 	proxyForPrincipal string
 	minExpiry         int64
 	maxExpiry         int64
+
+	// failures represent the number of the consecutive failures to refresh the token, used in the serve stale mode, accessed atomically.
+	failures int32
+	// nextRetry represent the time in unix nano to retry refreshing the stale token in the background, accessed atomically.
+	nextRetry int64
+
+	// refreshAt represent the time in unix nano to refresh the token by the role token updater, accessed atomically.
+	refreshAt int64
+
+	// lastAccess represent the time in unix nano when the token is requested last time, accessed atomically. 0 implies unknown.
+	lastAccess int64
+
+	// refreshedAt represent the time when the token is fetched from athenz server. 0 implies unknown.
+	refreshedAt int64
+	// lastError represent the error message of the last failure to refresh the token, it is cleared by the successful refresh.
+	lastError atomic.Value
+}
+
+// refreshResult represent the result of a refresh pass, err is nil if it succeeded.
+type refreshResult struct {
+	err error
+}
+
+// refreshJob represent the retry state of a role token in a refresh pass.
+type refreshJob struct {
+	key      string
+	data     *cacheData
+	attempts int
 }
 
 // RoleToken represent the basic information of the role token.
 type RoleToken struct {
 	Token      string `json:"token"`
 	ExpiryTime int64  `json:"expiryTime"`
+
+	// Stale represent the role token cannot be refreshed from athenz server and the cached one is returned.
+	Stale bool `json:"stale,omitempty"`
+}
+
+// RoleCacheEntry represent the information of a cached role token for the administration, the token itself is redacted.
+type RoleCacheEntry struct {
+	Domain            string `json:"domain"`
+	Role              string `json:"role"`
+	ProxyForPrincipal string `json:"proxyForPrincipal"`
+	ExpiryTime        int64  `json:"expiryTime"`
+
+	// LastRefresh represent the time in unix seconds when the role token is fetched from athenz server, 0 implies unknown.
+	LastRefresh int64 `json:"lastRefresh"`
+	// LastAccess represent the time in unix seconds when the role token is requested last time, 0 implies unknown.
+	LastAccess int64 `json:"lastAccess"`
+	// LastError represent the last error to refresh the role token, empty if the last refresh succeeded.
+	LastError string `json:"lastError,omitempty"`
 }
 
 // RoleProvider represent a function pointer to get the role token.
@@ -87,6 +194,12 @@ var (
 
 	// ErrInvalidSetting represent an error when the config file is invalid.
 	ErrInvalidSetting = errors.New("Invalid config")
+
+	// ErrRoleTokenPrefetching represent an error when the first prefetch of the role tokens is not finished.
+	ErrRoleTokenPrefetching = errors.New("role token prefetch is not finished")
+
+	// ErrRoleCacheEntryNotFound represent an error when the role token is not found in the cache.
+	ErrRoleCacheEntryNotFound = errors.New("role token is not cached")
 )
 
 const (
@@ -99,21 +212,73 @@ const (
 	// defaultErrRetryMaxCount represents the default maximum error retry count.
 	defaultErrRetryMaxCount = 5
 
+	// defaultNegativeCacheTTL represents the default duration to cache the client error of athenz server.
+	defaultNegativeCacheTTL = time.Second * 10
+
 	// defaultErrRetryInterval represents the default error retry interval.
 	defaultErrRetryInterval = time.Second * 5
 
 	// cacheKeySeparater is the separater of the internal cache key name.
 	cacheKeySeparater = ";"
 
+	// expirySeparater is the separater of the expiry in the internal cache key name of the role token cached separately for the incompatible expiry.
+	expirySeparater = "@"
+
 	// roleSeparater is the separater of the role names
 	roleSeparater = ","
 
 	// expiryCheckInterval represents default cache expiration check interval
 	expiryCheckInterval = time.Minute
+
+	// defaultRefreshRatio represents the default fraction of the remaining lifetime of the role token to wait before refreshing it.
+	defaultRefreshRatio = 0.75
+
+	// defaultRefreshJitter represents the default maximum fraction of the refresh delay to randomly subtract.
+	defaultRefreshJitter = 0.1
+
+	// defaultRefreshConcurrency represents the default number of the workers to refresh the role tokens.
+	defaultRefreshConcurrency = 4
+
+	// defaultRefreshDeadline represents the default deadline of a refresh pass of the role token cache.
+	defaultRefreshDeadline = time.Minute * 5
+
+	// minRefreshDelay represents the minimum delay to refresh the role token, to avoid refreshing a short-lived role token continuously.
+	minRefreshDelay = time.Second
+
+	// expiryMargin represents the margin before the role token expiry to remove the token from the cache.
+	// In the serve stale mode, the token is kept until the actual expiry but flagged as stale in the margin.
+	expiryMargin = time.Minute
 )
 
 // NewRoleService returns a RoleService to update and get the role token from athenz.
-func NewRoleService(cfg config.Role, token ntokend.TokenProvider) (RoleService, error) {
+// When the cache snapshot is enabled, the role token cache is restored from the snapshot file.
+func NewRoleService(cfg config.Role, token ntokend.TokenProvider, opts ...RoleOption) (RoleService, error) {
+	r, err := newRoleService(cfg, token)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	if config.GetActualValue(cfg.CacheSnapshot.Path) != "" {
+		if r.snapshotKeyPath == "" {
+			return nil, errors.Wrap(ErrInvalidSetting, "CacheSnapshot: private key is required")
+		}
+		key, err := loadPrivateKey(r.snapshotKeyPath)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "CacheSnapshot: "+err.Error())
+		}
+		if r.snapshot, err = newCacheSnapshot(cfg.CacheSnapshot, key); err != nil {
+			return nil, err
+		}
+		r.loadCacheSnapshot()
+	}
+	return r, nil
+}
+
+// newRoleService returns the role token service without the cache snapshot.
+func newRoleService(cfg config.Role, token ntokend.TokenProvider) (*roleService, error) {
 	var (
 		err              error
 		exp              = defaultTokenExpiry
@@ -149,65 +314,171 @@ func NewRoleService(cfg config.Role, token ntokend.TokenProvider) (RoleService,
 		return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0")
 	}
 
-	var cp *x509.CertPool
-	var httpClient *http.Client
-	if len(cfg.AthenzRootCA) > 0 {
-		certPath := config.GetActualValue(cfg.AthenzRootCA)
-		_, err := os.Stat(certPath)
-		if !os.IsNotExist(err) {
-			cp, err = NewX509CertPool(certPath)
-			if err != nil {
-				cp = nil
-			}
+	refreshRatio := defaultRefreshRatio
+	if cfg.RefreshRatio > 0 && cfg.RefreshRatio <= 1 {
+		refreshRatio = cfg.RefreshRatio
+	} else if cfg.RefreshRatio != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "RefreshRatio is out of range [0, 1]")
+	}
+
+	var errRetryMaxInterval time.Duration
+	if cfg.ErrRetryMaxInterval != "" {
+		if errRetryMaxInterval, err = time.ParseDuration(cfg.ErrRetryMaxInterval); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxInterval: "+err.Error())
 		}
 	}
-	if cp != nil {
-		httpClient = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					RootCAs: cp,
-				},
-			},
+
+	if _, err = newCircuitBreaker(cfg.CircuitBreaker); err != nil {
+		return nil, err
+	}
+
+	var unhealthyDuration time.Duration
+	if cfg.UnhealthyDuration != "" {
+		if unhealthyDuration, err = time.ParseDuration(cfg.UnhealthyDuration); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "UnhealthyDuration: "+err.Error())
+		}
+	}
+	endpoints, err := newEndpointPool(cfg.AthenzURL, cfg.AthenzURLStrategy, unhealthyDuration)
+	if err != nil {
+		return nil, err
+	}
+	// each endpoint has its own circuit breaker, so that the failures of an endpoint do not stop the fail over to the others
+	for _, ep := range endpoints.endpoints {
+		if ep.breaker, err = newCircuitBreaker(cfg.CircuitBreaker); err != nil {
+			return nil, err
+		}
+	}
+
+	refreshDeadline := defaultRefreshDeadline
+	if cfg.RefreshDeadline != "" {
+		if refreshDeadline, err = time.ParseDuration(cfg.RefreshDeadline); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "RefreshDeadline: "+err.Error())
+		}
+	}
+
+	refreshConcurrency := defaultRefreshConcurrency
+	if cfg.RefreshConcurrency > 0 {
+		refreshConcurrency = cfg.RefreshConcurrency
+	} else if cfg.RefreshConcurrency != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "RefreshConcurrency < 0")
+	}
+
+	var idleTTL time.Duration
+	if cfg.IdleTTL != "" {
+		if idleTTL, err = time.ParseDuration(cfg.IdleTTL); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "IdleTTL: "+err.Error())
+		}
+	}
+	if cfg.MaxCacheEntries < 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "MaxCacheEntries < 0")
+	}
+
+	negativeCacheTTL := defaultNegativeCacheTTL
+	if cfg.NegativeCacheTTL != "" {
+		if negativeCacheTTL, err = time.ParseDuration(cfg.NegativeCacheTTL); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "NegativeCacheTTL: "+err.Error())
+		}
+		if negativeCacheTTL < 0 {
+			return nil, errors.Wrap(ErrInvalidSetting, "NegativeCacheTTL < 0")
 		}
-	} else {
-		httpClient = http.DefaultClient
+	}
+
+	var inflight chan struct{}
+	if cfg.MaxInflightRequests > 0 {
+		inflight = make(chan struct{}, cfg.MaxInflightRequests)
+	} else if cfg.MaxInflightRequests != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "MaxInflightRequests < 0")
+	}
+
+	refreshJitter := defaultRefreshJitter
+	if cfg.RefreshJitter > 0 && cfg.RefreshJitter < 1 {
+		refreshJitter = cfg.RefreshJitter
+	} else if cfg.RefreshJitter != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "RefreshJitter is out of range [0, 1)")
 	}
 
 	return &roleService{
 		cfg:                   cfg,
 		token:                 token,
-		athenzURL:             cfg.AthenzURL,
+		endpoints:             endpoints,
 		athenzPrincipleHeader: cfg.PrincipalAuthHeaderName,
 		domainRoleCache:       gache.New(),
 		expiry:                exp,
-		httpClient:            httpClient,
+		httpClient:            newAthenzHTTPClient(cfg.AthenzRootCA),
 		refreshInterval:       refreshInterval,
 		errRetryMaxCount:      errRetryMaxCount,
 		errRetryInterval:      errRetryInterval,
+		serveStale:            cfg.ServeStale,
+		errRetryMaxInterval:   errRetryMaxInterval,
+		refreshRatio:          refreshRatio,
+		refreshJitter:         refreshJitter,
+		refreshConcurrency:    refreshConcurrency,
+		refreshDeadline:       refreshDeadline,
+		inflight:              inflight,
+		prefetch:              cfg.Prefetch,
+		idleTTL:               idleTTL,
+		maxCacheEntries:       cfg.MaxCacheEntries,
+		negativeCacheTTL:      negativeCacheTTL,
+		negativeCache:         gache.New(),
 	}, nil
 }
 
 // StartRoleUpdater returns RoleService.
-// This function will periodically refresh the role token.
+// This function will refresh each role token when its refresh time scheduled by nextRefreshDelay comes.
 func (r *roleService) StartRoleUpdater(ctx context.Context) <-chan error {
 	glg.Info("Starting role token updater")
 
+	// schedule the role tokens cached without the refresh time
+	refreshAt := fastime.Now().Add(r.getRefreshInterval()).UnixNano()
+	r.foreachCacheData(ctx, func(key string, cd *cacheData) bool {
+		atomic.CompareAndSwapInt64(&cd.refreshAt, 0, refreshAt)
+		return true
+	})
+
+	var snapshotDone chan struct{}
+	if r.snapshot != nil {
+		snapshotDone = make(chan struct{})
+		go func() {
+			defer close(snapshotDone)
+			r.snapshot.run(ctx, r.cacheSnapshotEntries)
+		}()
+	}
+
 	ech := make(chan error, 100)
 	go func() {
 		defer close(ech)
 
-		ticker := time.NewTicker(r.refreshInterval)
+		// fetch the prefetch role tokens with retries before the service becomes ready
+		for err := range r.prefetchRoleTokens(ctx, true) {
+			ech <- err
+		}
+		atomic.StoreInt32(&r.prefetched, 1)
+
+		timer := time.NewTimer(r.untilNextRefresh(ctx))
 		for {
 			select {
 			case <-ctx.Done():
 				glg.Info("Stopping role token updater")
-				ticker.Stop()
+				timer.Stop()
+				if snapshotDone != nil {
+					// wait for the last snapshot written on shutdown
+					<-snapshotDone
+				}
 				ech <- ctx.Err()
 				return
-			case <-ticker.C:
-				for err := range r.RefreshRoleTokenCache(ctx) {
+			case <-timer.C:
+				now := fastime.Now().UnixNano()
+				due := func(cd *cacheData) bool {
+					return atomic.LoadInt64(&cd.refreshAt) <= now
+				}
+				for err := range r.refreshRoleTokenCache(ctx, due) {
 					ech <- errors.Wrap(err, "error update role token")
 				}
+				// the prefetch role tokens failed or expired are fetched again
+				for err := range r.prefetchRoleTokens(ctx, false) {
+					ech <- err
+				}
+				timer.Reset(r.untilNextRefresh(ctx))
 			}
 		}
 	}()
@@ -219,59 +490,340 @@ func (r *roleService) StartRoleUpdater(ctx context.Context) <-chan error {
 	return ech
 }
 
+// Reload replaces the settings of the role token service by cfg, while the role token cache is kept.
+// It returns error without changing any setting if cfg is invalid.
+// The new refresh interval will be applied after the next refresh.
+func (r *roleService) Reload(cfg config.Role) error {
+	apply, err := r.PrepareReload(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareReload validates cfg and returns the function to replace the settings of the role token service by cfg.
+// It returns error without changing any setting if cfg is invalid, so that the caller can validate the other settings before applying cfg.
+func (r *roleService) PrepareReload(cfg config.Role) (func(), error) {
+	n, err := newRoleService(cfg, r.token)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		r.reload(n)
+	}, nil
+}
+
+// reload replaces the settings of the role token service by the settings of n.
+func (r *roleService) reload(n *roleService) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !reflect.DeepEqual(r.cfg.AthenzURL, n.cfg.AthenzURL) || r.cfg.AthenzURLStrategy != n.cfg.AthenzURLStrategy || r.cfg.UnhealthyDuration != n.cfg.UnhealthyDuration || r.cfg.CircuitBreaker != n.cfg.CircuitBreaker {
+		r.endpoints = n.endpoints
+	}
+	r.athenzPrincipleHeader = n.athenzPrincipleHeader
+	r.expiry = n.expiry
+	if r.httpClient == nil || r.cfg.AthenzRootCA != n.cfg.AthenzRootCA {
+		// the connections to athenz server are kept unless the root CA is changed
+		if r.httpClient != nil && r.httpClient != http.DefaultClient {
+			r.httpClient.CloseIdleConnections()
+		}
+		r.httpClient = n.httpClient
+	}
+	r.refreshInterval = n.refreshInterval
+	r.errRetryMaxCount = n.errRetryMaxCount
+	r.errRetryInterval = n.errRetryInterval
+	r.serveStale = n.serveStale
+	r.errRetryMaxInterval = n.errRetryMaxInterval
+	r.prefetch = n.prefetch
+	r.idleTTL = n.idleTTL
+	r.maxCacheEntries = n.maxCacheEntries
+	r.negativeCacheTTL = n.negativeCacheTTL
+	r.refreshRatio = n.refreshRatio
+	r.refreshJitter = n.refreshJitter
+	r.refreshConcurrency = n.refreshConcurrency
+	r.refreshDeadline = n.refreshDeadline
+	if cap(r.inflight) != cap(n.inflight) {
+		// the requests in flight release the previous one
+		r.inflight = n.inflight
+	}
+	r.cfg = n.cfg
+}
+
 // GetRoleProvider returns a function pointer to get the role token.
 func (r *roleService) GetRoleProvider() RoleProvider {
 	return r.getRoleToken
 }
 
+// RefreshCheck returns a HealthCheck which fails when none of the role tokens is refreshed in the last refresh pass.
+// It recovers by the next successful pass, and the client error (4xx) of athenz server and the cancellation do not fail the check.
+func (r *roleService) RefreshCheck() HealthCheck {
+	return func() error {
+		res, _ := r.lastRefresh.Load().(refreshResult)
+		return res.err
+	}
+}
+
+// PrefetchCheck returns a HealthCheck which fails until the first prefetch of the role tokens is finished.
+func (r *roleService) PrefetchCheck() HealthCheck {
+	return func() error {
+		if atomic.LoadInt32(&r.prefetched) == 0 {
+			return ErrRoleTokenPrefetching
+		}
+		return nil
+	}
+}
+
 // getRoleToken returns RoleToken struct or error.
 // This function will return the role token stored inside the cache, or fetch the role token from athenz when corresponding role token cannot be found in the cache.
+// The cached role token is also replaced by the one fetched from athenz when its remaining lifetime does not satisfy minExpiry and maxExpiry,
+// unless the fetched one does not satisfy the expiry of the cached one, in which case the fetched one is returned without being cached.
+// In the serve stale mode, the stale role token is returned with Stale flag, and it is refreshed in the background.
 func (r *roleService) getRoleToken(ctx context.Context, domain, role, proxyForPrincipal string, minExpiry, maxExpiry int64) (*RoleToken, error) {
-	tok, ok := r.getCache(domain, role, proxyForPrincipal)
+	key := encode(domain, role, proxyForPrincipal)
+	cd, ok := r.loadCacheData(key)
+	now := fastime.Now()
+	satisfied := ok && satisfyExpiry(cd.token, now, minExpiry, maxExpiry)
+	if ok && !satisfied {
+		// the role token requested with the incompatible expiry is cached separately
+		if ecd, eok := r.loadCacheData(expiryKey(key, minExpiry, maxExpiry)); eok && satisfyExpiry(ecd.token, now, minExpiry, maxExpiry) {
+			key, cd, satisfied = expiryKey(key, minExpiry, maxExpiry), ecd, true
+		}
+	}
+	defer r.touch(key)
+
+	metrics.ObserveCacheLookup("roletoken", satisfied)
 	if !ok {
 		return r.updateRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry)
 	}
-	return tok, nil
+
+	r.mu.RLock()
+	serveStale := r.serveStale
+	r.mu.RUnlock()
+
+	if !satisfied {
+		tok, err := r.updateRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry)
+		if err == nil || !serveStale {
+			return tok, err
+		}
+		glg.Warnf("failed to fetch role token satisfying the expiry, return the stale one, domain: %s, role: %s, proxyForPrincipal: %s, error: %v", domain, role, proxyForPrincipal, err)
+		return staleRoleToken(cd.token), nil
+	}
+
+	if !serveStale || (atomic.LoadInt32(&cd.failures) == 0 && now.Before(time.Unix(cd.token.ExpiryTime, 0).Add(-expiryMargin))) {
+		return cd.token, nil
+	}
+
+	if now.UnixNano() >= atomic.LoadInt64(&cd.nextRetry) {
+		go func() {
+			if _, err := r.updateRoleToken(context.Background(), cd.domain, cd.role, cd.proxyForPrincipal, cd.minExpiry, cd.maxExpiry); err != nil {
+				glg.Warnf("failed to refresh stale role token, domain: %s, role: %s, proxyForPrincipal: %s, error: %v", cd.domain, cd.role, cd.proxyForPrincipal, err)
+			}
+		}()
+	}
+
+	return staleRoleToken(cd.token), nil
 }
 
-// refreshRoleTokenCache returns the error channel when it is updated.
+// RefreshRoleTokenCache refreshes all the cached role tokens and returns the error channel when it is updated.
 func (r *roleService) RefreshRoleTokenCache(ctx context.Context) <-chan error {
+	return r.refreshRoleTokenCache(ctx, func(*cacheData) bool {
+		return true
+	})
+}
+
+// refreshRoleTokenCache refreshes the cached role tokens selected by filter, and returns the error channel when it is updated.
+// The role tokens are refreshed by the bounded workers, and the failed one is retried after the exponential backoff without blocking the others.
+// The role token failed to refresh after all the retries, or not refreshed before the pass deadline, is rescheduled after the refresh interval.
+func (r *roleService) refreshRoleTokenCache(ctx context.Context, filter func(*cacheData) bool) <-chan error {
 	glg.Info("refreshRoleTokenCache started")
 
-	echan := make(chan error, r.domainRoleCache.Len()*(r.errRetryMaxCount+1))
+	r.mu.RLock()
+	errRetryMaxCount, concurrency, deadline := r.errRetryMaxCount, r.refreshConcurrency, r.refreshDeadline
+	r.mu.RUnlock()
+	retryBackoff := r.retryBackoff()
+	if concurrency <= 0 {
+		concurrency = defaultRefreshConcurrency
+	}
+	if deadline <= 0 {
+		deadline = defaultRefreshDeadline
+	}
+
+	jobs := make([]*refreshJob, 0, r.domainRoleCache.Len())
+	r.foreachCacheData(ctx, func(key string, cd *cacheData) bool {
+		if r.isIdle(cd) {
+			// the role token not requested for a long time is not refreshed any more
+			glg.Infof("evict idle role token, key: %s", key)
+			r.domainRoleCache.Delete(key)
+			metrics.IncCacheEviction("roletoken", "idle")
+			return true
+		}
+		if filter(cd) {
+			jobs = append(jobs, &refreshJob{
+				key:  key,
+				data: cd,
+			})
+		}
+		return true
+	})
+	if concurrency > len(jobs) {
+		concurrency = len(jobs)
+	}
+
+	echan := make(chan error, len(jobs)*(errRetryMaxCount+1))
 	go func() {
 		defer close(echan)
 
-		r.domainRoleCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
-			domain, role, principal := decode(key)
-			cd := val.(*cacheData)
+		pctx, cancel := context.WithTimeout(ctx, deadline)
+		defer cancel()
+
+		// each job is in the queue at most once, so sending to the queue never blocks
+		queue := make(chan *refreshJob, len(jobs))
+		var pending sync.WaitGroup
+		pending.Add(len(jobs))
+		for _, job := range jobs {
+			queue <- job
+		}
+
+		// the result of the pass represents the readiness, which fails only when all the failed role tokens are not refreshed
+		var (
+			resMu     sync.Mutex
+			succeeded int
+			failed    int
+			lastErr   error
+		)
+
+		var workers sync.WaitGroup
+		workers.Add(concurrency)
+		for i := 0; i < concurrency; i++ {
+			go func() {
+				defer workers.Done()
+				for job := range queue {
+					if pctx.Err() != nil {
+						if ctx.Err() == nil {
+							echan <- errors.Wrapf(pctx.Err(), "refresh pass deadline exceeded, key: %s", job.key)
+						}
+						r.rescheduleRefresh(job.key)
+						pending.Done()
+						continue
+					}
+
+					domain, role, principal := decode(job.key)
+					_, err := r.updateRoleToken(pctx, domain, role, principal, job.data.minExpiry, job.data.maxExpiry)
+					if err == nil {
+						glg.Debugf("update success, key: %s", job.key)
+						resMu.Lock()
+						succeeded++
+						resMu.Unlock()
+						pending.Done()
+						continue
+					}
+
+					metrics.IncCacheRefreshFailure("roletoken")
+					echan <- err
+					job.attempts++
+					if job.attempts > errRetryMaxCount {
+						if pctx.Err() == nil && !isZTSClientError(err) {
+							resMu.Lock()
+							failed++
+							lastErr = err
+							resMu.Unlock()
+						}
+						r.rescheduleRefresh(job.key)
+						pending.Done()
+						continue
+					}
+					// the backoff is aborted by the pass deadline or the cancellation, so that the pass does not outlive them
+					go func(job *refreshJob, backoff time.Duration) {
+						t := time.NewTimer(backoff)
+						defer t.Stop()
+						select {
+						case <-t.C:
+							queue <- job
+						case <-pctx.Done():
+							if ctx.Err() == nil {
+								echan <- errors.Wrapf(pctx.Err(), "refresh pass deadline exceeded, key: %s", job.key)
+							}
+							r.rescheduleRefresh(job.key)
+							pending.Done()
+						}
+					}(job, retryBackoff.duration(job.attempts))
+				}
+			}()
+		}
+
+		pending.Wait()
+		close(queue)
+		workers.Wait()
 
-			for err := range r.updateRoleTokenWithRetry(ctx, domain, role, principal, cd.minExpiry, cd.maxExpiry) {
-				echan <- err
+		if ctx.Err() == nil {
+			res := refreshResult{}
+			if succeeded == 0 && failed > 0 {
+				res.err = errors.Wrapf(lastErr, "failed to refresh %d role tokens", failed)
 			}
-			return true
-		})
+			r.lastRefresh.Store(res)
+		}
 	}()
 
 	return echan
 }
 
-func (r *roleService) updateRoleTokenWithRetry(ctx context.Context, domain, role, proxyForPrincipal string, minExpiry, maxExpiry int64) <-chan error {
-	glg.Debugf("updateRoleTokenWithRetry started, domain: %s, role: %s, proxyForPrincipal: %s, minExpiry: %s, maxExpiry: %s", domain, role, proxyForPrincipal, minExpiry, maxExpiry)
+// prefetchRoleTokens fetches the prefetch role tokens which are not cached or do not satisfy the expiry, and returns the error channel when they are fetched.
+// The failed role token is retried with backoff up to the maximum error retry count if retry is true.
+func (r *roleService) prefetchRoleTokens(ctx context.Context, retry bool) <-chan error {
+	r.mu.RLock()
+	prefetch, errRetryMaxCount, concurrency := r.prefetch, r.errRetryMaxCount, r.refreshConcurrency
+	r.mu.RUnlock()
+	retryBackoff := r.retryBackoff()
+	if !retry {
+		errRetryMaxCount = 0
+	}
+	if concurrency <= 0 {
+		concurrency = defaultRefreshConcurrency
+	}
 
-	echan := make(chan error, r.errRetryMaxCount+1)
+	echan := make(chan error, len(prefetch)*(errRetryMaxCount+1))
 	go func() {
 		defer close(echan)
 
-		for i := 0; i <= r.errRetryMaxCount; i++ {
-			if _, err := r.updateRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry); err != nil {
-				echan <- err
-				time.Sleep(r.errRetryInterval)
-			} else {
-				glg.Debug("update success")
-				break
+		sem := make(chan struct{}, concurrency)
+		var wg sync.WaitGroup
+		for _, p := range prefetch {
+			if cd, ok := r.getCacheData(p.Domain, p.Role, p.ProxyForPrincipal); ok && satisfyExpiry(cd.token, fastime.Now(), p.MinExpiry, p.MaxExpiry) {
+				continue
 			}
+
+			wg.Add(1)
+			go func(p config.PrefetchRole) {
+				defer wg.Done()
+				select {
+				case sem <- struct{}{}:
+					defer func() {
+						<-sem
+					}()
+				case <-ctx.Done():
+					return
+				}
+
+				for attempts := 1; ; attempts++ {
+					_, err := r.updateRoleToken(ctx, p.Domain, p.Role, p.ProxyForPrincipal, p.MinExpiry, p.MaxExpiry)
+					if err == nil {
+						glg.Debugf("prefetch success, domain: %s, role: %s, proxyForPrincipal: %s", p.Domain, p.Role, p.ProxyForPrincipal)
+						return
+					}
+					echan <- errors.Wrapf(err, "error prefetch role token, domain: %s, role: %s, proxyForPrincipal: %s", p.Domain, p.Role, p.ProxyForPrincipal)
+					if attempts > errRetryMaxCount {
+						return
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(retryBackoff.duration(attempts)):
+					}
+				}
+			}(p)
 		}
+		wg.Wait()
 	}()
 
 	return echan
@@ -281,24 +833,78 @@ func (r *roleService) updateRoleTokenWithRetry(ctx context.Context, domain, role
 // This function ask athenz to generate role token and return, or return any error when generating the role token.
 func (r *roleService) updateRoleToken(ctx context.Context, domain, role, proxyForPrincipal string, minExpiry, maxExpiry int64) (*RoleToken, error) {
 	key := encode(domain, role, proxyForPrincipal)
-	expTimeDelta := fastime.Now().Add(time.Minute)
+	expTimeDelta := fastime.Now().Add(expiryMargin)
+
+	r.mu.RLock()
+	serveStale, negativeCacheTTL := r.serveStale, r.negativeCacheTTL
+	r.mu.RUnlock()
 
-	rt, err, _ := r.group.Do(key, func() (interface{}, error) {
+	// return the client error without requesting athenz server again until it expires
+	if r.negativeCache != nil {
+		if e, ok := r.negativeCache.Get(key); ok {
+			glg.Debugf("return the cached athenz server error, domain: %s, role: %s, proxyForPrincipal: %s", domain, role, proxyForPrincipal)
+			return nil, e.(*ZTSError)
+		}
+	}
+
+	// the requests with different expiry are not shared, as the fetched role token may not satisfy each other
+	rt, err, _ := r.group.Do(expiryKey(key, minExpiry, maxExpiry), func() (interface{}, error) {
 		rt, e := r.fetchRoleToken(ctx, domain, role, proxyForPrincipal, minExpiry, maxExpiry)
 		if e != nil {
+			if cd, ok := r.getCacheData(domain, role, proxyForPrincipal); ok {
+				cd.lastError.Store(e.Error())
+			}
+			if serveStale {
+				r.markStale(key)
+				r.markStale(expiryKey(key, minExpiry, maxExpiry))
+			}
+			if ze, ok := e.(*ZTSError); ok && r.negativeCache != nil && negativeCacheTTL > 0 {
+				r.negativeCache.SetWithExpire(key, ze, negativeCacheTTL)
+			}
 			return nil, e
 		}
 
-		r.domainRoleCache.SetWithExpire(key, &cacheData{
+		now := fastime.Now()
+		cacheKey := key
+		old, exists := r.loadCacheData(key)
+		if exists && (old.minExpiry != minExpiry || old.maxExpiry != maxExpiry) {
+			// the cached token is replaced only by the one satisfying its expiry as well,
+			// otherwise the callers with the incompatible expiry would replace the token of each other on every request,
+			// so the token of the incompatible expiry is cached separately under the key with the expiry
+			if ecd, ok := r.loadCacheData(expiryKey(key, minExpiry, maxExpiry)); ok {
+				cacheKey, old = expiryKey(key, minExpiry, maxExpiry), ecd
+			} else if old.token != nil && !satisfyExpiry(rt, now, old.minExpiry, old.maxExpiry) && satisfyExpiry(old.token, now, old.minExpiry, old.maxExpiry) {
+				glg.Debugf("token is cached separately for the incompatible expiry, domain: %s, role: %s, proxyForPrincipal: %s, minExpiry: %d, maxExpiry: %d", domain, role, proxyForPrincipal, minExpiry, maxExpiry)
+				cacheKey, old, exists = expiryKey(key, minExpiry, maxExpiry), nil, false
+			}
+		}
+
+		if serveStale {
+			// keep the token until the actual expiry, getRoleToken flags it as stale in the margin
+			expTimeDelta = now
+		}
+		// the refresh is not an access, so the last access time is carried over
+		lastAccess := now.UnixNano()
+		if exists {
+			lastAccess = atomic.LoadInt64(&old.lastAccess)
+		}
+		r.domainRoleCache.SetWithExpire(cacheKey, &cacheData{
 			token:             rt,
 			domain:            domain,
 			role:              role,
 			proxyForPrincipal: proxyForPrincipal,
 			minExpiry:         minExpiry,
 			maxExpiry:         maxExpiry,
+			refreshAt:         now.Add(r.nextRefreshDelay(rt, now)).UnixNano(),
+			lastAccess:        lastAccess,
+			refreshedAt:       now.UnixNano(),
 		}, time.Unix(rt.ExpiryTime, 0).Sub(expTimeDelta))
+		if !exists {
+			r.evictLRU(cacheKey)
+		}
 
 		glg.Debugf("token is cached, domain: %s, role: %s, proxyForPrincipal: %s, expiry time: %v", domain, role, proxyForPrincipal, rt.ExpiryTime)
+		r.snapshot.notify()
 		return rt, nil
 	})
 	if err != nil {
@@ -318,20 +924,74 @@ func (r *roleService) fetchRoleToken(ctx context.Context, domain, role, proxyFor
 		return nil, err
 	}
 
-	// prepare request object
-	req, err := r.createGetRoleTokenRequest(domain, role, minExpiry, maxExpiry, proxyForPrincipal, tok)
-	if err != nil {
-		glg.Debugf("fail to create request object, error: %s", err)
-		return nil, err
+	r.mu.RLock()
+	httpClient, inflight, endpoints := r.httpClient, r.inflight, r.endpoints
+	r.mu.RUnlock()
+
+	eps := endpoints.order()
+	if len(eps) == 0 {
+		return nil, ErrNoAthenzEndpoint
 	}
-	glg.Debugf("request url: %v", req.URL)
 
-	res, err := r.httpClient.Do(req.WithContext(ctx))
-	if err != nil {
-		return nil, err
+	if inflight != nil {
+		select {
+		case inflight <- struct{}{}:
+			defer func() {
+				<-inflight
+			}()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	var res *http.Response
+	for i, ep := range eps {
+		// prepare request object
+		req, err := r.createGetRoleTokenRequest(ep.host, domain, role, minExpiry, maxExpiry, proxyForPrincipal, tok)
+		if err != nil {
+			glg.Debugf("fail to create request object, error: %s", err)
+			return nil, err
+		}
+		glg.Debugf("request url: %v", req.URL)
+
+		res, err = doZTSRequest(ctx, httpClient, ep.breaker, "roletoken", req)
+		if err == nil && res.StatusCode < http.StatusInternalServerError {
+			endpoints.markHealthy(ep)
+			break
+		}
+		if ctx.Err() != nil {
+			if err == nil {
+				flushAndClose(res.Body)
+				err = ctx.Err()
+			}
+			return nil, err
+		}
+
+		// fail over to the next endpoint on the connection error, the server error or the open circuit
+		if err != ErrCircuitOpen {
+			endpoints.markUnhealthy(ep)
+		}
+		if i == len(eps)-1 {
+			if err != nil {
+				return nil, err
+			}
+			// the server error of the last endpoint is handled below
+			break
+		}
+		if err == nil {
+			glg.Warnf("fail over the role token request to the next athenz server, host: %s, status: %d", ep.host, res.StatusCode)
+			flushAndClose(res.Body)
+		} else {
+			glg.Warnf("fail over the role token request to the next athenz server, host: %s, error: %v", ep.host, err)
+		}
 	}
 
 	defer flushAndClose(res.Body)
+	if res.StatusCode >= http.StatusBadRequest && res.StatusCode < http.StatusInternalServerError {
+		e := newZTSError(res)
+		glg.Debugf("client error return from server, domain: %s, role: %s, proxyForPrincipal: %s, error: %v", domain, role, proxyForPrincipal, e)
+		return nil, e
+	}
 	if res.StatusCode != http.StatusOK {
 		buf := new(bytes.Buffer)
 		if _, err := buf.ReadFrom(res.Body); err != nil {
@@ -349,16 +1009,395 @@ func (r *roleService) fetchRoleToken(ctx context.Context, domain, role, proxyFor
 	return data, nil
 }
 
+// cacheSnapshotEntries returns the cached role tokens to write to the cache snapshot.
+func (r *roleService) cacheSnapshotEntries() []snapshotEntry {
+	entries := make([]snapshotEntry, 0, r.domainRoleCache.Len())
+	// the cache is read after the context is canceled on shutdown
+	r.foreachCacheData(context.Background(), func(key string, cd *cacheData) bool {
+		if strings.Contains(key, expirySeparater) {
+			// the role token of the incompatible expiry is fetched again on demand
+			return true
+		}
+		entries = append(entries, snapshotEntry{
+			Domain:            cd.domain,
+			Role:              cd.role,
+			ProxyForPrincipal: cd.proxyForPrincipal,
+			MinExpiry:         cd.minExpiry,
+			MaxExpiry:         cd.maxExpiry,
+			Token:             cd.token,
+		})
+		return true
+	})
+	return entries
+}
+
+// loadCacheSnapshot restores the role token cache from the cache snapshot, and discards the expired role tokens.
+// The role token cache is kept empty if the snapshot cannot be read, e.g. it is written with another private key.
+func (r *roleService) loadCacheSnapshot() {
+	entries, err := r.snapshot.read()
+	if err != nil {
+		glg.Warnf("cannot read cache snapshot, start with empty cache, path: %s, error: %v", r.snapshot.path, err)
+		return
+	}
+
+	now := fastime.Now()
+	expTimeDelta := now.Add(expiryMargin)
+	if r.serveStale {
+		expTimeDelta = now
+	}
+	loaded := 0
+	for _, e := range entries {
+		if e.Token == nil {
+			continue
+		}
+		exp := time.Unix(e.Token.ExpiryTime, 0).Sub(expTimeDelta)
+		if exp <= 0 {
+			continue
+		}
+		r.domainRoleCache.SetWithExpire(encode(e.Domain, e.Role, e.ProxyForPrincipal), &cacheData{
+			token:             e.Token,
+			domain:            e.Domain,
+			role:              e.Role,
+			proxyForPrincipal: e.ProxyForPrincipal,
+			minExpiry:         e.MinExpiry,
+			maxExpiry:         e.MaxExpiry,
+			refreshAt:         now.Add(r.nextRefreshDelay(e.Token, now)).UnixNano(),
+			lastAccess:        now.UnixNano(),
+		}, exp)
+		loaded++
+	}
+	r.evictLRU("")
+	glg.Infof("role token cache is restored from snapshot, path: %s, loaded: %d, discarded: %d", r.snapshot.path, loaded, len(entries)-loaded)
+}
+
+// CacheEntries returns the information of all the cached role tokens, sorted by domain, role and proxy for principal.
+func (r *roleService) CacheEntries() []RoleCacheEntry {
+	entries := make([]RoleCacheEntry, 0)
+	r.foreachCacheData(context.Background(), func(key string, cd *cacheData) bool {
+		entries = append(entries, newRoleCacheEntry(cd))
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Domain != entries[j].Domain {
+			return entries[i].Domain < entries[j].Domain
+		}
+		if entries[i].Role != entries[j].Role {
+			return entries[i].Role < entries[j].Role
+		}
+		return entries[i].ProxyForPrincipal < entries[j].ProxyForPrincipal
+	})
+	return entries
+}
+
+// RefreshCacheEntry fetches the cached role token from athenz server immediately, and returns the refreshed information.
+// It returns ErrRoleCacheEntryNotFound if the role token is not cached.
+func (r *roleService) RefreshCacheEntry(ctx context.Context, domain, role, proxyForPrincipal string) (*RoleCacheEntry, error) {
+	cd, ok := r.getCacheData(domain, role, proxyForPrincipal)
+	if !ok {
+		return nil, ErrRoleCacheEntryNotFound
+	}
+	if _, err := r.updateRoleToken(ctx, domain, role, proxyForPrincipal, cd.minExpiry, cd.maxExpiry); err != nil {
+		return nil, err
+	}
+	if cd, ok = r.getCacheData(domain, role, proxyForPrincipal); !ok {
+		return nil, ErrRoleCacheEntryNotFound
+	}
+	e := newRoleCacheEntry(cd)
+	return &e, nil
+}
+
+// InvalidateCacheEntry deletes the role token and the cached athenz server error from the cache, and returns false if neither is cached.
+// The role tokens cached separately for the incompatible expiry are deleted as well.
+func (r *roleService) InvalidateCacheEntry(domain, role, proxyForPrincipal string) bool {
+	key := encode(domain, role, proxyForPrincipal)
+	negative := false
+	if r.negativeCache != nil {
+		if _, negative = r.negativeCache.Get(key); negative {
+			r.negativeCache.Delete(key)
+		}
+	}
+	keys := make([]string, 0, 1)
+	r.foreachCacheData(context.Background(), func(k string, cd *cacheData) bool {
+		if k == key || strings.HasPrefix(k, key+expirySeparater) {
+			keys = append(keys, k)
+		}
+		return true
+	})
+	if len(keys) == 0 {
+		return negative
+	}
+	for _, k := range keys {
+		r.domainRoleCache.Delete(k)
+	}
+	r.snapshot.notify()
+	glg.Infof("role token cache is invalidated, key: %s", key)
+	return true
+}
+
+// InvalidateAllCacheEntries deletes all the role tokens and the cached athenz server errors from the cache, and returns the number of the deleted role tokens.
+func (r *roleService) InvalidateAllCacheEntries() int {
+	keys := make([]string, 0)
+	r.foreachCacheData(context.Background(), func(key string, cd *cacheData) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		r.domainRoleCache.Delete(key)
+	}
+	if r.negativeCache != nil {
+		r.negativeCache.Clear()
+	}
+	r.snapshot.notify()
+	glg.Infof("all the role token cache is invalidated, count: %d", len(keys))
+	return len(keys)
+}
+
+// newRoleCacheEntry returns the information of cd without the token.
+func newRoleCacheEntry(cd *cacheData) RoleCacheEntry {
+	e := RoleCacheEntry{
+		Domain:            cd.domain,
+		Role:              cd.role,
+		ProxyForPrincipal: cd.proxyForPrincipal,
+		ExpiryTime:        cd.token.ExpiryTime,
+	}
+	if cd.refreshedAt > 0 {
+		e.LastRefresh = time.Unix(0, cd.refreshedAt).Unix()
+	}
+	if last := atomic.LoadInt64(&cd.lastAccess); last > 0 {
+		e.LastAccess = time.Unix(0, last).Unix()
+	}
+	if msg, ok := cd.lastError.Load().(string); ok {
+		e.LastError = msg
+	}
+	return e
+}
+
+// touch records the access time of the cached role token of key.
+func (r *roleService) touch(key string) {
+	if cd, ok := r.loadCacheData(key); ok {
+		atomic.StoreInt64(&cd.lastAccess, fastime.Now().UnixNano())
+	}
+}
+
+// isPrefetch returns true if cd is one of the prefetch role tokens, which are never evicted.
+func (r *roleService) isPrefetch(cd *cacheData) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.prefetch {
+		if p.Domain == cd.domain && p.Role == cd.role && p.ProxyForPrincipal == cd.proxyForPrincipal {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdle returns true if cd is not requested within the idle TTL.
+func (r *roleService) isIdle(cd *cacheData) bool {
+	r.mu.RLock()
+	idleTTL := r.idleTTL
+	r.mu.RUnlock()
+
+	last := atomic.LoadInt64(&cd.lastAccess)
+	if idleTTL <= 0 || last == 0 || fastime.Now().UnixNano()-last <= int64(idleTTL) {
+		return false
+	}
+	return !r.isPrefetch(cd)
+}
+
+// evictLRU evicts the least recently used role tokens until the number of the cached role tokens is within the maximum.
+// The role token of keep and the prefetch role tokens are not evicted.
+func (r *roleService) evictLRU(keep string) {
+	r.mu.RLock()
+	max := r.maxCacheEntries
+	r.mu.RUnlock()
+	if max <= 0 {
+		return
+	}
+
+	r.evictMu.Lock()
+	defer r.evictMu.Unlock()
+
+	// gache.Len counts the overwrites, so the entries are counted here
+	type candidate struct {
+		key        string
+		lastAccess int64
+	}
+	n := 0
+	candidates := make([]candidate, 0, max+1)
+	r.foreachCacheData(context.Background(), func(key string, cd *cacheData) bool {
+		n++
+		if key != keep && !r.isPrefetch(cd) {
+			candidates = append(candidates, candidate{
+				key:        key,
+				lastAccess: atomic.LoadInt64(&cd.lastAccess),
+			})
+		}
+		return true
+	})
+	if n <= max {
+		return
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].lastAccess < candidates[j].lastAccess
+	})
+	for i := 0; i < n-max && i < len(candidates); i++ {
+		glg.Infof("evict least recently used role token, key: %s", candidates[i].key)
+		r.domainRoleCache.Delete(candidates[i].key)
+		metrics.IncCacheEviction("roletoken", "capacity")
+	}
+}
+
+// markStale records the refresh failure of the cached role token of key, and schedules the next background retry with exponential backoff.
+func (r *roleService) markStale(key string) {
+	val, ok := r.domainRoleCache.Get(key)
+	if !ok {
+		return
+	}
+	// the cached entry is updated in place, so that the concurrent updates of the other fields are not lost
+	cd := val.(*cacheData)
+
+	now := fastime.Now()
+	if !now.Before(time.Unix(cd.token.ExpiryTime, 0)) {
+		r.domainRoleCache.Delete(key)
+		return
+	}
+	failures := atomic.AddInt32(&cd.failures, 1)
+	nextRetry := now.Add(r.retryBackoff().duration(int(failures)))
+	atomic.StoreInt64(&cd.nextRetry, nextRetry.UnixNano())
+	glg.Warnf("serving stale role token, domain: %s, role: %s, proxyForPrincipal: %s, failures: %d, next retry: %v", cd.domain, cd.role, cd.proxyForPrincipal, failures, nextRetry)
+}
+
+// satisfyExpiry returns whether the remaining lifetime of tok at now is in the range of minExpiry and maxExpiry seconds.
+// The zero or negative minExpiry and maxExpiry implies unspecified.
+func satisfyExpiry(tok *RoleToken, now time.Time, minExpiry, maxExpiry int64) bool {
+	remain := tok.ExpiryTime - now.Unix()
+	if minExpiry > 0 && remain < minExpiry {
+		return false
+	}
+	if maxExpiry > 0 && remain > maxExpiry {
+		return false
+	}
+	return true
+}
+
+// staleRoleToken returns the copy of tok flagged as stale, the cached role token is not modified.
+func staleRoleToken(tok *RoleToken) *RoleToken {
+	st := *tok
+	st.Stale = true
+	return &st
+}
+
+// retryBackoff returns the backoff of the retry, which starts from the error retry interval and doubles on each failure up to the error retry max interval.
+// The refresh interval is used when the error retry max interval is not specified.
+func (r *roleService) retryBackoff() backoff {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	max := r.errRetryMaxInterval
+	if max <= 0 {
+		max = r.refreshInterval
+	}
+	return backoff{
+		base:   r.errRetryInterval,
+		max:    max,
+		jitter: defaultBackoffJitter,
+	}
+}
+
+// nextRefreshDelay returns the delay to refresh tok fetched at now.
+// The delay is the refresh ratio of the remaining lifetime in the range of minRefreshDelay and the refresh interval, minus the random jitter.
+// The jitter is applied after the range, so that the role tokens capped by the refresh interval are not refreshed at once.
+func (r *roleService) nextRefreshDelay(tok *RoleToken, now time.Time) time.Duration {
+	r.mu.RLock()
+	ratio, jitter, maxDelay := r.refreshRatio, r.refreshJitter, r.refreshInterval
+	r.mu.RUnlock()
+
+	d := time.Duration(float64(time.Unix(tok.ExpiryTime, 0).Sub(now)) * ratio)
+	if maxDelay > 0 && d > maxDelay {
+		d = maxDelay
+	}
+	minDelay := minRefreshDelay
+	if maxDelay > 0 && maxDelay < minDelay {
+		minDelay = maxDelay
+	}
+	if d < minDelay {
+		d = minDelay
+	}
+	return time.Duration(float64(d) * (1 - jitter*rand.Float64()))
+}
+
+// rescheduleRefresh schedules the refresh of the cached role token of key after the refresh interval.
+func (r *roleService) rescheduleRefresh(key string) {
+	val, ok := r.domainRoleCache.Get(key)
+	if !ok {
+		return
+	}
+	atomic.StoreInt64(&val.(*cacheData).refreshAt, fastime.Now().Add(r.getRefreshInterval()).UnixNano())
+}
+
+// untilNextRefresh returns the duration until the earliest refresh time of the cached role tokens.
+// It returns at most expiryCheckInterval, so that the role tokens cached after the call are refreshed in time.
+func (r *roleService) untilNextRefresh(ctx context.Context) time.Duration {
+	now := fastime.Now().UnixNano()
+	next := now + int64(expiryCheckInterval)
+	r.foreachCacheData(ctx, func(key string, cd *cacheData) bool {
+		if at := atomic.LoadInt64(&cd.refreshAt); at < next {
+			next = at
+		}
+		return true
+	})
+	if next < now {
+		return 0
+	}
+	return time.Duration(next - now)
+}
+
+// getRefreshInterval returns the current refresh interval of the role token cache.
+func (r *roleService) getRefreshInterval() time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.refreshInterval
+}
+
 func (r *roleService) getCache(domain, role, principal string) (*RoleToken, bool) {
-	val, ok := r.domainRoleCache.Get(encode(domain, role, principal))
+	cd, ok := r.getCacheData(domain, role, principal)
+	if !ok {
+		return nil, false
+	}
+	return cd.token, ok
+}
+
+// foreachCacheData calls f for each role token cache until f returns false.
+// gache.Foreach calls the function concurrently per shard, so f is serialized here to collect the results without any lock.
+func (r *roleService) foreachCacheData(ctx context.Context, f func(key string, cd *cacheData) bool) {
+	var mu sync.Mutex
+	r.domainRoleCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return f(key, val.(*cacheData))
+	})
+}
+
+func (r *roleService) getCacheData(domain, role, principal string) (*cacheData, bool) {
+	return r.loadCacheData(encode(domain, role, principal))
+}
+
+// loadCacheData returns the cached role token of key.
+func (r *roleService) loadCacheData(key string) (*cacheData, bool) {
+	val, ok := r.domainRoleCache.Get(key)
 	if !ok {
 		return nil, false
 	}
-	return val.(*cacheData).token, ok
+	return val.(*cacheData), ok
 }
 
-func (r *roleService) createGetRoleTokenRequest(domain, role string, minExpiry, maxExpiry int64, proxyForPrincipal, token string) (*http.Request, error) {
-	u := fmt.Sprintf("https://%s/domain/%s/token", strings.TrimPrefix(strings.TrimPrefix(r.athenzURL, "https://"), "http://"), domain)
+func (r *roleService) createGetRoleTokenRequest(host, domain, role string, minExpiry, maxExpiry int64, proxyForPrincipal, token string) (*http.Request, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// the domain is escaped not to request the other path of athenz server
+	u := fmt.Sprintf("https://%s/domain/%s/token", host, url.PathEscape(domain))
 
 	req, err := http.NewRequest(http.MethodGet, u, nil)
 	if err != nil {
@@ -402,6 +1441,32 @@ func (r *roleService) createGetRoleTokenRequest(domain, role string, minExpiry,
 	return req, nil
 }
 
+// newAthenzHTTPClient returns the *http.Client to connect to athenz server.
+// The client trusts the root CA certificate given by rootCA, otherwise http.DefaultClient is returned.
+func newAthenzHTTPClient(rootCA string) *http.Client {
+	var cp *x509.CertPool
+	if len(rootCA) > 0 {
+		certPath := config.GetActualValue(rootCA)
+		_, err := os.Stat(certPath)
+		if !os.IsNotExist(err) {
+			cp, err = NewX509CertPool(certPath)
+			if err != nil {
+				cp = nil
+			}
+		}
+	}
+	if cp == nil {
+		return http.DefaultClient
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs: cp,
+			},
+		},
+	}
+}
+
 func encode(domain, role, principal string) string {
 	roles := strings.Split(role, roleSeparater)
 	sort.Strings(roles)
@@ -413,7 +1478,17 @@ func encode(domain, role, principal string) string {
 	return strings.Join(s, cacheKeySeparater)
 }
 
+// expiryKey returns the cache key of the role token of key requested with minExpiry and maxExpiry,
+// which is cached separately when it does not satisfy the expiry of the cached one.
+func expiryKey(key string, minExpiry, maxExpiry int64) string {
+	return key + expirySeparater + strconv.FormatInt(minExpiry, 10) + cacheKeySeparater + strconv.FormatInt(maxExpiry, 10)
+}
+
 func decode(key string) (string, string, string) {
+	// the expiry of the key is not the part of the domain, role and principal
+	if i := strings.Index(key, expirySeparater); i >= 0 {
+		key = key[:i]
+	}
 	keys := strings.SplitN(key, cacheKeySeparater, 3)
 	res := make([]string, 3)
 	copy(res, keys)
@@ -19,10 +19,17 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -51,7 +58,7 @@ func TestNewRoleService(t *testing.T) {
 			args := args{
 				cfg: config.Role{
 					TokenExpiry:             "5s",
-					AthenzURL:               "dummy",
+					AthenzURL:               config.URLList{"dummy"},
 					PrincipalAuthHeaderName: "dummyAuthHeader",
 					RefreshInterval:         "1s",
 				},
@@ -67,7 +74,7 @@ func TestNewRoleService(t *testing.T) {
 					wantS := want.(*roleService)
 					if !reflect.DeepEqual(gotS.cfg, wantS.cfg) ||
 						reflect.ValueOf(gotS.token).Pointer() != reflect.ValueOf(wantS.token).Pointer() ||
-						!reflect.DeepEqual(gotS.athenzURL, wantS.athenzURL) ||
+						!reflect.DeepEqual(gotS.endpoints, wantS.endpoints) ||
 						!reflect.DeepEqual(gotS.athenzPrincipleHeader, wantS.athenzPrincipleHeader) ||
 						//!reflect.DeepEqual(gotS.domainRoleCache, wantS.domainRoleCache) ||
 						!reflect.DeepEqual(gotS.expiry, wantS.expiry) {
@@ -79,7 +86,7 @@ func TestNewRoleService(t *testing.T) {
 				want: &roleService{
 					cfg:                   args.cfg,
 					token:                 args.token,
-					athenzURL:             args.cfg.AthenzURL,
+					endpoints:             newTestEndpointPool(args.cfg.AthenzURL...),
 					athenzPrincipleHeader: args.cfg.PrincipalAuthHeaderName,
 					domainRoleCache:       gache.New(),
 					expiry: func() time.Duration {
@@ -92,7 +99,7 @@ func TestNewRoleService(t *testing.T) {
 		func() test {
 			args := args{
 				cfg: config.Role{
-					AthenzURL:               "dummy",
+					AthenzURL:               config.URLList{"dummy"},
 					PrincipalAuthHeaderName: "dummyAuthHeader",
 				},
 				token: func() (string, error) {
@@ -107,7 +114,7 @@ func TestNewRoleService(t *testing.T) {
 					wantS := want.(*roleService)
 					if !reflect.DeepEqual(gotS.cfg, wantS.cfg) ||
 						reflect.ValueOf(gotS.token).Pointer() != reflect.ValueOf(wantS.token).Pointer() ||
-						!reflect.DeepEqual(gotS.athenzURL, wantS.athenzURL) ||
+						!reflect.DeepEqual(gotS.endpoints, wantS.endpoints) ||
 						!reflect.DeepEqual(gotS.athenzPrincipleHeader, wantS.athenzPrincipleHeader) ||
 						//!reflect.DeepEqual(gotS.domainRoleCache, wantS.domainRoleCache) ||
 						!reflect.DeepEqual(gotS.expiry, wantS.expiry) ||
@@ -122,7 +129,7 @@ func TestNewRoleService(t *testing.T) {
 				want: &roleService{
 					cfg:                   args.cfg,
 					token:                 args.token,
-					athenzURL:             args.cfg.AthenzURL,
+					endpoints:             newTestEndpointPool(args.cfg.AthenzURL...),
 					athenzPrincipleHeader: args.cfg.PrincipalAuthHeaderName,
 					domainRoleCache:       gache.New(),
 					expiry:                0,
@@ -183,7 +190,55 @@ func TestNewRoleService(t *testing.T) {
 		func() test {
 			args := args{
 				cfg: config.Role{
-					AthenzURL:               "dummy",
+					RefreshRatio: 1.5,
+				},
+			}
+			return test{
+				name:    "NewRoleService return error with RefreshRatio > 1",
+				args:    args,
+				wantErr: errors.Wrap(ErrInvalidSetting, "RefreshRatio is out of range [0, 1]"),
+			}
+		}(),
+		func() test {
+			args := args{
+				cfg: config.Role{
+					RefreshJitter: -0.1,
+				},
+			}
+			return test{
+				name:    "NewRoleService return error with RefreshJitter < 0",
+				args:    args,
+				wantErr: errors.Wrap(ErrInvalidSetting, "RefreshJitter is out of range [0, 1)"),
+			}
+		}(),
+		func() test {
+			args := args{
+				cfg: config.Role{
+					IdleTTL: "dummy",
+				},
+			}
+			return test{
+				name:    "NewRoleService return error with IdleTTL of invalid format",
+				args:    args,
+				wantErr: errors.Wrap(ErrInvalidSetting, "IdleTTL: time: invalid duration \"dummy\""),
+			}
+		}(),
+		func() test {
+			args := args{
+				cfg: config.Role{
+					MaxCacheEntries: -1,
+				},
+			}
+			return test{
+				name:    "NewRoleService return error with MaxCacheEntries < 0",
+				args:    args,
+				wantErr: errors.Wrap(ErrInvalidSetting, "MaxCacheEntries < 0"),
+			}
+		}(),
+		func() test {
+			args := args{
+				cfg: config.Role{
+					AthenzURL:               config.URLList{"dummy"},
 					PrincipalAuthHeaderName: "dummyAuthHeader",
 					RefreshInterval:         "60s",
 					TokenExpiry:             "1s",
@@ -202,7 +257,7 @@ func TestNewRoleService(t *testing.T) {
 			cnt := 10
 			args := args{
 				cfg: config.Role{
-					AthenzURL:               "dummy",
+					AthenzURL:               config.URLList{"dummy"},
 					PrincipalAuthHeaderName: "dummyAuthHeader",
 					ErrRetryMaxCount:        cnt,
 				},
@@ -218,7 +273,7 @@ func TestNewRoleService(t *testing.T) {
 					wantS := want.(*roleService)
 					if !reflect.DeepEqual(gotS.cfg, wantS.cfg) ||
 						reflect.ValueOf(gotS.token).Pointer() != reflect.ValueOf(wantS.token).Pointer() ||
-						!reflect.DeepEqual(gotS.athenzURL, wantS.athenzURL) ||
+						!reflect.DeepEqual(gotS.endpoints, wantS.endpoints) ||
 						!reflect.DeepEqual(gotS.athenzPrincipleHeader, wantS.athenzPrincipleHeader) ||
 						//!reflect.DeepEqual(gotS.domainRoleCache, wantS.domainRoleCache) ||
 						!reflect.DeepEqual(gotS.expiry, wantS.expiry) ||
@@ -233,7 +288,7 @@ func TestNewRoleService(t *testing.T) {
 				want: &roleService{
 					cfg:                   args.cfg,
 					token:                 args.token,
-					athenzURL:             args.cfg.AthenzURL,
+					endpoints:             newTestEndpointPool(args.cfg.AthenzURL...),
 					athenzPrincipleHeader: args.cfg.PrincipalAuthHeaderName,
 					domainRoleCache:       gache.New(),
 					expiry:                0,
@@ -246,7 +301,7 @@ func TestNewRoleService(t *testing.T) {
 		func() test {
 			args := args{
 				cfg: config.Role{
-					AthenzURL:               "dummy",
+					AthenzURL:               config.URLList{"dummy"},
 					PrincipalAuthHeaderName: "dummyAuthHeader",
 					AthenzRootCA:            "assets/dummyCa.pem",
 				},
@@ -262,7 +317,7 @@ func TestNewRoleService(t *testing.T) {
 					wantS := want.(*roleService)
 					if !reflect.DeepEqual(gotS.cfg, wantS.cfg) ||
 						reflect.ValueOf(gotS.token).Pointer() != reflect.ValueOf(wantS.token).Pointer() ||
-						!reflect.DeepEqual(gotS.athenzURL, wantS.athenzURL) ||
+						!reflect.DeepEqual(gotS.endpoints, wantS.endpoints) ||
 						!reflect.DeepEqual(gotS.athenzPrincipleHeader, wantS.athenzPrincipleHeader) ||
 						//!reflect.DeepEqual(gotS.domainRoleCache, wantS.domainRoleCache) ||
 						!reflect.DeepEqual(gotS.expiry, wantS.expiry) ||
@@ -283,7 +338,7 @@ func TestNewRoleService(t *testing.T) {
 				want: &roleService{
 					cfg:                   args.cfg,
 					token:                 args.token,
-					athenzURL:             args.cfg.AthenzURL,
+					endpoints:             newTestEndpointPool(args.cfg.AthenzURL...),
 					athenzPrincipleHeader: args.cfg.PrincipalAuthHeaderName,
 					domainRoleCache:       gache.New(),
 					expiry:                0,
@@ -296,7 +351,7 @@ func TestNewRoleService(t *testing.T) {
 		func() test {
 			args := args{
 				cfg: config.Role{
-					AthenzURL:               "dummy",
+					AthenzURL:               config.URLList{"dummy"},
 					PrincipalAuthHeaderName: "dummyAuthHeader",
 					AthenzRootCA:            "assets/invalid_dummyCa.pem",
 				},
@@ -312,7 +367,7 @@ func TestNewRoleService(t *testing.T) {
 					wantS := want.(*roleService)
 					if !reflect.DeepEqual(gotS.cfg, wantS.cfg) ||
 						reflect.ValueOf(gotS.token).Pointer() != reflect.ValueOf(wantS.token).Pointer() ||
-						!reflect.DeepEqual(gotS.athenzURL, wantS.athenzURL) ||
+						!reflect.DeepEqual(gotS.endpoints, wantS.endpoints) ||
 						!reflect.DeepEqual(gotS.athenzPrincipleHeader, wantS.athenzPrincipleHeader) ||
 						//!reflect.DeepEqual(gotS.domainRoleCache, wantS.domainRoleCache) ||
 						!reflect.DeepEqual(gotS.expiry, wantS.expiry) ||
@@ -331,7 +386,7 @@ func TestNewRoleService(t *testing.T) {
 				want: &roleService{
 					cfg:                   args.cfg,
 					token:                 args.token,
-					athenzURL:             args.cfg.AthenzURL,
+					endpoints:             newTestEndpointPool(args.cfg.AthenzURL...),
 					athenzPrincipleHeader: args.cfg.PrincipalAuthHeaderName,
 					domainRoleCache:       gache.New(),
 					expiry:                0,
@@ -375,9 +430,10 @@ func Test_roleService_StartRoleUpdater(t *testing.T) {
 		expiry                time.Duration
 		httpClient            *http.Client
 
-		refreshInterval  time.Duration
-		errRetryMaxCount int
-		errRetryInterval time.Duration
+		refreshInterval     time.Duration
+		errRetryMaxCount    int
+		errRetryInterval    time.Duration
+		errRetryMaxInterval time.Duration
 	}
 	type args struct {
 		ctx context.Context
@@ -574,6 +630,7 @@ func Test_roleService_StartRoleUpdater(t *testing.T) {
 					errRetryMaxCount:      9,
 					refreshInterval:       time.Millisecond * 700,
 					errRetryInterval:      time.Millisecond,
+					errRetryMaxInterval:   time.Millisecond,
 					expiry:                time.Millisecond * 700,
 				},
 				args: args{
@@ -625,7 +682,7 @@ func Test_roleService_StartRoleUpdater(t *testing.T) {
 			r := &roleService{
 				cfg:                   tt.fields.cfg,
 				token:                 tt.fields.token,
-				athenzURL:             tt.fields.athenzURL,
+				endpoints:             newTestEndpointPool(tt.fields.athenzURL),
 				athenzPrincipleHeader: tt.fields.athenzPrincipleHeader,
 				domainRoleCache:       tt.fields.domainRoleCache,
 				group:                 tt.fields.group,
@@ -634,6 +691,7 @@ func Test_roleService_StartRoleUpdater(t *testing.T) {
 				refreshInterval:       tt.fields.refreshInterval,
 				errRetryMaxCount:      tt.fields.errRetryMaxCount,
 				errRetryInterval:      tt.fields.errRetryInterval,
+				errRetryMaxInterval:   tt.fields.errRetryMaxInterval,
 			}
 			got := r.StartRoleUpdater(tt.args.ctx)
 			if err := tt.checkFunc(r, got); err != nil {
@@ -643,6 +701,186 @@ func Test_roleService_StartRoleUpdater(t *testing.T) {
 	}
 }
 
+func TestNewRoleService_cacheSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := config.Role{
+		CacheSnapshot: config.CacheSnapshot{
+			Path: filepath.Join(dir, "roletoken.snapshot"),
+		},
+	}
+
+	// the private key is required
+	if _, err := NewRoleService(cfg, nil); err == nil || err.Error() != errors.Wrap(ErrInvalidSetting, "CacheSnapshot: private key is required").Error() {
+		t.Fatalf("NewRoleService() without private key error = %v", err)
+	}
+
+	// restore the valid role tokens from the snapshot written by the previous process
+	rs, err := NewRoleService(cfg, nil, WithRoleCacheKey("./assets/dummyServer.key"))
+	if err != nil {
+		t.Fatalf("NewRoleService() error = %v", err)
+	}
+	r := rs.(*roleService)
+	if r.domainRoleCache.Len() != 0 {
+		t.Fatalf("NewRoleService() without snapshot file cache len = %v, want 0", r.domainRoleCache.Len())
+	}
+	valid := &RoleToken{
+		Token:      "valid",
+		ExpiryTime: fastime.Now().Add(time.Hour).Unix(),
+	}
+	if err := r.snapshot.write([]snapshotEntry{
+		{
+			Domain: "domain",
+			Role:   "valid",
+			Token:  valid,
+		},
+		{
+			Domain: "domain",
+			Role:   "expired",
+			Token: &RoleToken{
+				Token:      "expired",
+				ExpiryTime: fastime.Now().Add(time.Second).Unix(),
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err = NewRoleService(cfg, nil, WithRoleCacheKey("./assets/dummyServer.key"))
+	if err != nil {
+		t.Fatalf("NewRoleService() error = %v", err)
+	}
+	r = rs.(*roleService)
+	if r.domainRoleCache.Len() != 1 {
+		t.Errorf("NewRoleService() cache len = %v, want 1", r.domainRoleCache.Len())
+	}
+	cd, ok := r.getCacheData("domain", "valid", "")
+	if !ok || !reflect.DeepEqual(cd.token, valid) || cd.refreshAt == 0 {
+		t.Errorf("NewRoleService() restored cache = %+v, %v", cd, ok)
+	}
+
+	// the snapshot is written on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	ech := r.StartRoleUpdater(ctx)
+	r.domainRoleCache.Delete(encode("domain", "valid", ""))
+	cancel()
+	for range ech {
+	}
+	if entries, err := r.snapshot.read(); err != nil || len(entries) != 0 {
+		t.Errorf("snapshot on shutdown = %+v, %v, want empty", entries, err)
+	}
+}
+
+func Test_roleService_Reload_httpClient(t *testing.T) {
+	client := &http.Client{}
+	r := &roleService{
+		cfg: config.Role{
+			AthenzRootCA: "assets/dummyCa.pem",
+		},
+		endpoints:       newTestEndpointPool("oldURL"),
+		domainRoleCache: gache.New(),
+		httpClient:      client,
+	}
+
+	// the http client is kept when the root CA is not changed
+	if err := r.Reload(config.Role{
+		AthenzURL:    config.URLList{"newURL"},
+		AthenzRootCA: "assets/dummyCa.pem",
+	}); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	if r.httpClient != client {
+		t.Error("Reload() replaced the http client with the same root CA")
+	}
+
+	// the http client is rebuilt when the root CA is changed
+	if err := r.Reload(config.Role{
+		AthenzURL: config.URLList{"newURL"},
+	}); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	if r.httpClient != http.DefaultClient {
+		t.Errorf("Reload() http client = %v, want http.DefaultClient without the root CA", r.httpClient)
+	}
+}
+
+func Test_roleService_Reload(t *testing.T) {
+	type test struct {
+		name      string
+		cfg       config.Role
+		checkFunc func(*roleService) error
+		wantErr   error
+	}
+	tests := []test{
+		{
+			name: "Reload replace the settings and keep the cache",
+			cfg: config.Role{
+				AthenzURL:               config.URLList{"newURL"},
+				PrincipalAuthHeaderName: "newHeader",
+				TokenExpiry:             "1h",
+				RefreshInterval:         "10m",
+				ErrRetryMaxCount:        1,
+				ErrRetryInterval:        "1s",
+			},
+			checkFunc: func(r *roleService) error {
+				if r.endpoints.endpoints[0].host != "newURL" ||
+					r.athenzPrincipleHeader != "newHeader" ||
+					r.expiry != time.Hour ||
+					r.refreshInterval != time.Minute*10 ||
+					r.errRetryMaxCount != 1 ||
+					r.errRetryInterval != time.Second {
+					return fmt.Errorf("settings not reloaded, got: %+v", r)
+				}
+				if _, ok := r.domainRoleCache.Get("cachedKey"); !ok {
+					return fmt.Errorf("cache is not kept")
+				}
+				return nil
+			},
+		},
+		{
+			name: "Reload return error and keep the settings when config is invalid",
+			cfg: config.Role{
+				AthenzURL:       config.URLList{"newURL"},
+				RefreshInterval: "dummy",
+			},
+			checkFunc: func(r *roleService) error {
+				if r.endpoints.endpoints[0].host != "oldURL" || r.refreshInterval != time.Hour {
+					return fmt.Errorf("settings should not be reloaded, got: %+v", r)
+				}
+				return nil
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "RefreshInterval: time: invalid duration \"dummy\""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &roleService{
+				endpoints:       newTestEndpointPool("oldURL"),
+				domainRoleCache: gache.New(),
+				refreshInterval: time.Hour,
+			}
+			r.domainRoleCache.Set("cachedKey", &cacheData{})
+
+			err := r.Reload(tt.cfg)
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("Reload() error: %v", err)
+				return
+			}
+			if tt.wantErr != nil && (err == nil || err.Error() != tt.wantErr.Error()) {
+				t.Errorf("Reload() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err := tt.checkFunc(r); err != nil {
+				t.Errorf("Reload() %v", err)
+			}
+		})
+	}
+}
+
 func Test_roleService_GetRoleProvider(t *testing.T) {
 	tests := []struct {
 		name string
@@ -671,6 +909,7 @@ func Test_roleService_getRoleToken(t *testing.T) {
 		group                 singleflight.Group
 		expiry                time.Duration
 		httpClient            *http.Client
+		serveStale            bool
 	}
 	type args struct {
 		ctx               context.Context
@@ -786,6 +1025,108 @@ func Test_roleService_getRoleToken(t *testing.T) {
 				want: dummyRoleToken,
 			}
 		}(),
+		func() test {
+			dummyTok := "dummyNewToken"
+			dummyExpTime := fastime.Now().Add(time.Hour * 2).Unix()
+			dummyToken := fmt.Sprintf(`{"token":"%v", "expiryTime": %v}`, dummyTok, dummyExpTime)
+
+			var sampleHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, dummyToken)
+			})
+			dummyServer := httptest.NewTLSServer(sampleHandler)
+
+			gac := gache.New()
+			gac.Set("dummyDomain;dummyRole;dummyProxy", &cacheData{
+				token: &RoleToken{
+					Token:      "dummyToken",
+					ExpiryTime: fastime.Now().Add(time.Minute * 2).Unix(),
+				},
+			})
+
+			return test{
+				name: "getRoleToken fetch when cached token does not satisfy min expiry",
+				fields: fields{
+					httpClient:      dummyServer.Client(),
+					domainRoleCache: gac,
+					token: func() (string, error) {
+						return dummyToken, nil
+					},
+					athenzURL:             dummyServer.URL,
+					athenzPrincipleHeader: "Athenz-Principal",
+				},
+				args: args{
+					ctx:               context.Background(),
+					domain:            "dummyDomain",
+					role:              "dummyRole",
+					proxyForPrincipal: "dummyProxy",
+					minExpiry:         3600,
+				},
+				afterFunc: func() error {
+					dummyServer.Close()
+					return nil
+				},
+				want: &RoleToken{
+					Token:      dummyTok,
+					ExpiryTime: dummyExpTime,
+				},
+			}
+		}(),
+		func() test {
+			dummyRoleToken := &RoleToken{
+				Token:      "dummyToken",
+				ExpiryTime: fastime.Now().Add(time.Hour).Unix(),
+			}
+			gac := gache.New()
+			gac.Set("dummyDomain;dummyRole;dummyProxy", &cacheData{
+				token: dummyRoleToken,
+			})
+
+			return test{
+				name: "getRoleToken return from cache in serve stale mode",
+				fields: fields{
+					domainRoleCache: gac,
+					serveStale:      true,
+				},
+				args: args{
+					ctx:               context.Background(),
+					domain:            "dummyDomain",
+					role:              "dummyRole",
+					proxyForPrincipal: "dummyProxy",
+				},
+				want: dummyRoleToken,
+			}
+		}(),
+		func() test {
+			dummyExpTime := fastime.Now().Add(time.Hour).Unix()
+			gac := gache.New()
+			gac.Set("dummyDomain;dummyRole;dummyProxy", &cacheData{
+				token: &RoleToken{
+					Token:      "dummyToken",
+					ExpiryTime: dummyExpTime,
+				},
+				failures:  1,
+				nextRetry: fastime.Now().Add(time.Hour).UnixNano(),
+			})
+
+			return test{
+				name: "getRoleToken return stale token from cache",
+				fields: fields{
+					domainRoleCache: gac,
+					serveStale:      true,
+				},
+				args: args{
+					ctx:               context.Background(),
+					domain:            "dummyDomain",
+					role:              "dummyRole",
+					proxyForPrincipal: "dummyProxy",
+				},
+				want: &RoleToken{
+					Token:      "dummyToken",
+					ExpiryTime: dummyExpTime,
+					Stale:      true,
+				},
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -796,12 +1137,13 @@ func Test_roleService_getRoleToken(t *testing.T) {
 			r := &roleService{
 				cfg:                   tt.fields.cfg,
 				token:                 tt.fields.token,
-				athenzURL:             tt.fields.athenzURL,
+				endpoints:             newTestEndpointPool(tt.fields.athenzURL),
 				athenzPrincipleHeader: tt.fields.athenzPrincipleHeader,
 				domainRoleCache:       tt.fields.domainRoleCache,
 				group:                 tt.fields.group,
 				expiry:                tt.fields.expiry,
 				httpClient:            tt.fields.httpClient,
+				serveStale:            tt.fields.serveStale,
 			}
 			got, err := r.getRoleToken(tt.args.ctx, tt.args.domain, tt.args.role, tt.args.proxyForPrincipal, tt.args.minExpiry, tt.args.maxExpiry)
 			if tt.wantErr == nil && err != nil {
@@ -1137,7 +1479,7 @@ func Test_roleService_RefreshRoleTokenCache(t *testing.T) {
 			r := &roleService{
 				cfg:                   tt.fields.cfg,
 				token:                 tt.fields.token,
-				athenzURL:             tt.fields.athenzURL,
+				endpoints:             newTestEndpointPool(tt.fields.athenzURL),
 				athenzPrincipleHeader: tt.fields.athenzPrincipleHeader,
 				domainRoleCache:       tt.fields.domainRoleCache,
 				group:                 tt.fields.group,
@@ -1155,237 +1497,759 @@ func Test_roleService_RefreshRoleTokenCache(t *testing.T) {
 	}
 }
 
-func Test_roleService_updateRoleTokenWithRetry(t *testing.T) {
-	type fields struct {
-		cfg                   config.Role
-		token                 ntokend.TokenProvider
-		athenzURL             string
-		athenzPrincipleHeader string
-		domainRoleCache       gache.Gache
-		group                 singleflight.Group
-		expiry                time.Duration
-		httpClient            *http.Client
-		refreshInterval       time.Duration
-		errRetryMaxCount      int
-		errRetryInterval      time.Duration
-	}
-	type args struct {
-		ctx               context.Context
-		domain            string
-		role              string
-		proxyForPrincipal string
-		minExpiry         int64
-		maxExpiry         int64
-	}
+func Test_roleService_refreshRoleTokenCache(t *testing.T) {
 	type test struct {
-		name      string
-		fields    fields
-		args      args
-		want      <-chan error
-		checkFunc func(<-chan error) error
-		afterFunc func() error
+		name        string
+		handler     http.HandlerFunc
+		r           func(*httptest.Server) *roleService
+		cancelAfter time.Duration
+		checkFunc   func(*roleService, []error, time.Duration) error
+	}
+	newService := func(srv *httptest.Server, keys []string) *roleService {
+		gac := gache.New()
+		for _, k := range keys {
+			gac.Set(k, &cacheData{})
+		}
+		return &roleService{
+			token: func() (string, error) {
+				return "dummyNToken", nil
+			},
+			endpoints:             newTestEndpointPool(srv.URL),
+			athenzPrincipleHeader: "Athenz-Principal",
+			domainRoleCache:       gac,
+			httpClient:            srv.Client(),
+			refreshInterval:       time.Hour,
+			errRetryMaxCount:      3,
+			errRetryInterval:      time.Millisecond * 200,
+			refreshConcurrency:    2,
+			refreshDeadline:       time.Second * 5,
+		}
 	}
 	tests := []test{
-		func() test {
-			dummyTok := "dummyToken"
-			dummyExpTime := int64(999999999)
-			dummyToken := fmt.Sprintf(`{"token":"%v", "expiryTime": %v}`, dummyTok, dummyExpTime)
-
-			var sampleHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				fmt.Fprintf(w, dummyToken)
-			})
-			dummyServer := httptest.NewTLSServer(sampleHandler)
-
-			domainRoleCache := gache.New()
-
-			return test{
-				name: "updateRoleTokenWithRetry success",
-				fields: fields{
-					httpClient:      dummyServer.Client(),
-					domainRoleCache: domainRoleCache,
-					token: func() (string, error) {
-						return dummyToken, nil
-					},
-					athenzURL:             dummyServer.URL,
-					athenzPrincipleHeader: "Athenz-Principal",
-					errRetryMaxCount:      9,
-				},
-				args: args{
-					ctx:               context.Background(),
-					domain:            "dummyDomain",
-					role:              "dummyRole",
-					proxyForPrincipal: "dummyProxy",
-				},
-				checkFunc: func(echan <-chan error) error {
-					for err := range echan {
-						return errors.Wrap(err, "Unexpected error occurred")
+		{
+			name: "refreshRoleTokenCache does not block the other keys by retry",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				if strings.Contains(r.URL.Path, "failDomain") {
+					w.WriteHeader(http.StatusInternalServerError)
+					return
+				}
+				fmt.Fprintf(w, `{"token":"%s","expiryTime":%d}`, r.URL.Path, fastime.Now().Add(time.Hour).Unix())
+			},
+			r: func(srv *httptest.Server) *roleService {
+				return newService(srv, []string{"failDomain;role", "domain1;role", "domain2;role", "domain3;role"})
+			},
+			checkFunc: func(r *roleService, errs []error, elapsed time.Duration) error {
+				if len(errs) != 4 {
+					return errors.Errorf("len(errs) = %v, errors: %v", len(errs), errs)
+				}
+				for _, k := range []string{"domain1;role", "domain2;role", "domain3;role"} {
+					val, ok := r.domainRoleCache.Get(k)
+					if !ok || val.(*cacheData).token == nil {
+						return errors.Errorf("token of %s is not refreshed", k)
 					}
-
-					tok, ok := domainRoleCache.Get("dummyDomain;dummyRole;dummyProxy")
-					if !ok {
-						return errors.New("token donot set to the cache")
+				}
+				val, _ := r.domainRoleCache.Get("failDomain;role")
+				if time.Until(time.Unix(0, val.(*cacheData).refreshAt)) < time.Minute*59 {
+					return errors.New("failed token is not rescheduled")
+				}
+				return nil
+			},
+		},
+		{
+			name: "refreshRoleTokenCache stop retry at the pass deadline",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			r: func(srv *httptest.Server) *roleService {
+				r := newService(srv, []string{"failDomain;role"})
+				r.errRetryMaxCount = 100
+				r.refreshDeadline = time.Millisecond * 500
+				return r
+			},
+			checkFunc: func(r *roleService, errs []error, elapsed time.Duration) error {
+				if elapsed > time.Second*2 {
+					return errors.Errorf("refresh pass is not stopped at the deadline, elapsed: %v", elapsed)
+				}
+				last := errs[len(errs)-1]
+				if !strings.HasPrefix(last.Error(), "refresh pass deadline exceeded, key: failDomain;role") {
+					return errors.Errorf("unexpected last error: %v", last)
+				}
+				return nil
+			},
+		},
+		{
+			name: "refreshRoleTokenCache stop the backoff longer than the pass deadline",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			r: func(srv *httptest.Server) *roleService {
+				r := newService(srv, []string{"failDomain;role"})
+				r.errRetryInterval = time.Hour
+				r.refreshDeadline = time.Millisecond * 300
+				return r
+			},
+			checkFunc: func(r *roleService, errs []error, elapsed time.Duration) error {
+				if elapsed > time.Second*2 {
+					return errors.Errorf("refresh pass waits for the backoff, elapsed: %v", elapsed)
+				}
+				if len(errs) != 2 || !strings.HasPrefix(errs[1].Error(), "refresh pass deadline exceeded, key: failDomain;role") {
+					return errors.Errorf("unexpected errors: %v", errs)
+				}
+				val, _ := r.domainRoleCache.Get("failDomain;role")
+				if time.Until(time.Unix(0, val.(*cacheData).refreshAt)) < time.Minute*59 {
+					return errors.New("failed token is not rescheduled")
+				}
+				return nil
+			},
+		},
+		{
+			name: "refreshRoleTokenCache stop the backoff when the context is cancelled",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusInternalServerError)
+			},
+			r: func(srv *httptest.Server) *roleService {
+				r := newService(srv, []string{"failDomain;role"})
+				r.errRetryInterval = time.Hour
+				return r
+			},
+			cancelAfter: time.Millisecond * 300,
+			checkFunc: func(r *roleService, errs []error, elapsed time.Duration) error {
+				if elapsed > time.Second*2 {
+					return errors.Errorf("refresh pass waits for the backoff after cancel, elapsed: %v", elapsed)
+				}
+				if len(errs) != 1 {
+					return errors.Errorf("unexpected errors: %v", errs)
+				}
+				return nil
+			},
+		},
+		{
+			name: "refreshRoleTokenCache drop the idle role tokens",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprintf(w, `{"token":"%s","expiryTime":%d}`, r.URL.Path, fastime.Now().Add(time.Hour).Unix())
+			},
+			r: func(srv *httptest.Server) *roleService {
+				r := newService(srv, nil)
+				r.idleTTL = time.Hour
+				r.prefetch = []config.PrefetchRole{
+					{
+						Domain: "prefetchDomain",
+						Role:   "role",
+					},
+				}
+				for k, last := range map[string]time.Time{
+					"idleDomain;role":     fastime.Now().Add(-time.Hour * 2),
+					"activeDomain;role":   fastime.Now(),
+					"prefetchDomain;role": fastime.Now().Add(-time.Hour * 2),
+				} {
+					domain, role, _ := decode(k)
+					r.domainRoleCache.Set(k, &cacheData{
+						domain:     domain,
+						role:       role,
+						lastAccess: last.UnixNano(),
+					})
+				}
+				return r
+			},
+			checkFunc: func(r *roleService, errs []error, elapsed time.Duration) error {
+				if len(errs) != 0 {
+					return errors.Errorf("errors: %v", errs)
+				}
+				if _, ok := r.domainRoleCache.Get("idleDomain;role"); ok {
+					return errors.New("idle token is not dropped")
+				}
+				for _, k := range []string{"activeDomain;role", "prefetchDomain;role"} {
+					val, ok := r.domainRoleCache.Get(k)
+					if !ok || val.(*cacheData).token == nil {
+						return errors.Errorf("token of %s is not refreshed", k)
 					}
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewTLSServer(tt.handler)
+			defer srv.Close()
 
-					if tok.(*cacheData).token.Token != dummyTok {
-						return errors.New("invalid token set on the cache")
-					}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelAfter > 0 {
+				time.AfterFunc(tt.cancelAfter, cancel)
+			}
 
-					return nil
-				},
-				afterFunc: func() error {
-					dummyServer.Close()
-					return nil
-				},
+			r := tt.r(srv)
+			start := time.Now()
+			errs := make([]error, 0)
+			for err := range r.refreshRoleTokenCache(ctx, func(*cacheData) bool {
+				return true
+			}) {
+				errs = append(errs, err)
 			}
-		}(),
-		func() test {
-			dummyTok := "dummyToken"
-			dummyExpTime := int64(999999999)
-			dummyToken := fmt.Sprintf(`{"token":"%v", "expiryTime": %v}`, dummyTok, dummyExpTime)
+			if err := tt.checkFunc(r, errs, time.Since(start)); err != nil {
+				t.Errorf("roleService.refreshRoleTokenCache() error: %v", err)
+			}
+		})
+	}
+}
 
-			i := 0
-			var sampleHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				if i < 3 {
-					w.WriteHeader(http.StatusInternalServerError)
-				} else {
-					fmt.Fprintf(w, dummyToken)
-				}
-				i++
-			})
-			dummyServer := httptest.NewTLSServer(sampleHandler)
+func Test_roleService_RefreshCheck(t *testing.T) {
+	code := int32(http.StatusInternalServerError)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c := int(atomic.LoadInt32(&code)); c != http.StatusOK {
+			w.WriteHeader(c)
+			return
+		}
+		fmt.Fprintf(w, `{"token":"token","expiryTime":%d}`, fastime.Now().Add(time.Hour).Unix())
+	}))
+	defer srv.Close()
+
+	gac := gache.New()
+	gac.Set(encode("domain1", "role", ""), &cacheData{})
+	gac.Set(encode("domain2", "role", ""), &cacheData{})
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             newTestEndpointPool(srv.URL),
+		athenzPrincipleHeader: "Athenz-Principal",
+		domainRoleCache:       gac,
+		httpClient:            srv.Client(),
+		refreshInterval:       time.Hour,
+		refreshConcurrency:    2,
+		refreshDeadline:       time.Second * 5,
+	}
+	refresh := func(ctx context.Context) {
+		for range r.RefreshRoleTokenCache(ctx) {
+		}
+	}
 
-			domainRoleCache := gache.New()
+	if err := r.RefreshCheck()(); err != nil {
+		t.Errorf("RefreshCheck() before refresh = %v", err)
+	}
 
-			return test{
-				name: "updateRoleTokenWithRetry success with retry",
-				fields: fields{
-					httpClient:      dummyServer.Client(),
-					domainRoleCache: domainRoleCache,
-					token: func() (string, error) {
-						return dummyToken, nil
-					},
-					athenzURL:             dummyServer.URL,
-					athenzPrincipleHeader: "Athenz-Principal",
-					errRetryMaxCount:      9,
-				},
-				args: args{
-					ctx:               context.Background(),
-					domain:            "dummyDomain",
-					role:              "dummyRole",
-					proxyForPrincipal: "dummyProxy",
-				},
-				checkFunc: func(echan <-chan error) error {
-					errs := make([]error, 0, 3)
-					for err := range echan {
-						errs = append(errs, err)
-					}
+	// the server error fails the check
+	refresh(context.Background())
+	if err := r.RefreshCheck()(); err == nil {
+		t.Error("RefreshCheck() after server error = nil")
+	}
 
-					// check the length
-					if len(errs) != 3 {
-						return errors.Errorf("len(err) = %v", len(errs))
-					}
+	// the cancellation does not change the check
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	refresh(ctx)
+	if err := r.RefreshCheck()(); err == nil {
+		t.Error("RefreshCheck() after cancellation = nil")
+	}
 
-					// check errors
-					for _, err := range errs {
-						if err != ErrRoleTokenRequestFailed {
-							return errors.Errorf("Unexpected error: %v", err)
-						}
-					}
+	// the client error does not fail the check
+	atomic.StoreInt32(&code, http.StatusForbidden)
+	refresh(context.Background())
+	if err := r.RefreshCheck()(); err != nil {
+		t.Errorf("RefreshCheck() after client error = %v", err)
+	}
 
-					tok, ok := domainRoleCache.Get("dummyDomain;dummyRole;dummyProxy")
-					if !ok {
-						return errors.New("token donot set to the cache")
-					}
+	// the successful pass recovers the check
+	atomic.StoreInt32(&code, http.StatusInternalServerError)
+	refresh(context.Background())
+	atomic.StoreInt32(&code, http.StatusOK)
+	refresh(context.Background())
+	if err := r.RefreshCheck()(); err != nil {
+		t.Errorf("RefreshCheck() after recovery = %v", err)
+	}
+}
 
-					if tok.(*cacheData).token.Token != dummyTok {
-						return errors.New("invalid token set on the cache")
-					}
+func Test_roleService_evictLRU(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"token":"%s","expiryTime":%d}`, r.URL.Path, fastime.Now().Add(time.Hour).Unix())
+	}))
+	defer srv.Close()
 
-					return nil
-				},
-				afterFunc: func() error {
-					dummyServer.Close()
-					return nil
-				},
-			}
-		}(),
-		func() test {
-			dummyToken := "tok"
-			// create a dummy server that returns a dummy token
-			var sampleHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-				w.WriteHeader(http.StatusInternalServerError)
-			})
-			dummyServer := httptest.NewTLSServer(sampleHandler)
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             newTestEndpointPool(srv.URL),
+		athenzPrincipleHeader: "Athenz-Principal",
+		domainRoleCache:       gache.New(),
+		httpClient:            srv.Client(),
+		refreshInterval:       time.Hour,
+		maxCacheEntries:       3,
+		prefetch: []config.PrefetchRole{
+			{
+				Domain: "prefetch",
+			},
+		},
+	}
+	ctx := context.Background()
+	get := func(domain string) {
+		if _, err := r.getRoleToken(ctx, domain, "", "", 0, 0); err != nil {
+			t.Fatalf("getRoleToken() error: %v", err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	for _, domain := range []string{"prefetch", "domain1", "domain2", "domain1"} {
+		get(domain)
+	}
 
-			domainRoleCache := gache.New()
+	// the refresh does not update the last access time
+	cd, _ := r.getCacheData("domain2", "", "")
+	last := atomic.LoadInt64(&cd.lastAccess)
+	if _, err := r.updateRoleToken(ctx, "domain2", "", "", 0, 0); err != nil {
+		t.Fatalf("updateRoleToken() error: %v", err)
+	}
+	cd, _ = r.getCacheData("domain2", "", "")
+	if got := atomic.LoadInt64(&cd.lastAccess); got != last {
+		t.Errorf("lastAccess after refresh = %v, want %v", got, last)
+	}
 
-			return test{
-				name: "updateRoleTokenWithRetry returns error",
-				fields: fields{
-					httpClient:      dummyServer.Client(),
-					domainRoleCache: domainRoleCache,
-					token: func() (string, error) {
-						return dummyToken, nil
-					},
-					athenzURL:             dummyServer.URL,
-					athenzPrincipleHeader: "Athenz-Principal",
-					errRetryMaxCount:      9,
-				},
-				args: args{
-					ctx:               context.Background(),
-					domain:            "dummyDomain",
-					role:              "dummyRole",
-					proxyForPrincipal: "dummyProxy",
-				},
-				checkFunc: func(echan <-chan error) error {
-					errs := make([]error, 0, 10)
-					for err := range echan {
-						errs = append(errs, err)
-					}
+	// domain2 is the least recently used, and the prefetch role token is never evicted
+	get("domain3")
+	if _, ok := r.getCache("domain2", "", ""); ok {
+		t.Error("least recently used token is not evicted")
+	}
+	for _, domain := range []string{"prefetch", "domain1", "domain3"} {
+		if _, ok := r.getCache(domain, "", ""); !ok {
+			t.Errorf("token of %s is evicted", domain)
+		}
+	}
+}
 
-					// check the length
-					if len(errs) != 10 {
-						return errors.Errorf("len(err) = %v", len(errs))
-					}
+func Test_roleService_cacheAdmin(t *testing.T) {
+	var count int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&count, 1)
+		fmt.Fprintf(w, `{"token":"token%d","expiryTime":%d}`, n, fastime.Now().Add(time.Hour).Unix())
+	}))
+	defer srv.Close()
+
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             newTestEndpointPool(srv.URL),
+		athenzPrincipleHeader: "Athenz-Principal",
+		domainRoleCache:       gache.New(),
+		httpClient:            srv.Client(),
+		refreshInterval:       time.Hour,
+	}
+	ctx := context.Background()
+	for _, domain := range []string{"domain2", "domain1"} {
+		if _, err := r.getRoleToken(ctx, domain, "role", "", 0, 0); err != nil {
+			t.Fatalf("getRoleToken() error: %v", err)
+		}
+	}
 
-					// check errors
-					for _, err := range errs {
-						if err != ErrRoleTokenRequestFailed {
-							return errors.Errorf("Unexpected error: %v", err)
-						}
-					}
+	// the entries are sorted and do not contain the tokens
+	entries := r.CacheEntries()
+	if len(entries) != 2 || entries[0].Domain != "domain1" || entries[1].Domain != "domain2" {
+		t.Fatalf("CacheEntries() = %+v", entries)
+	}
+	if entries[0].Role != "role" || entries[0].ExpiryTime == 0 || entries[0].LastRefresh == 0 || entries[0].LastAccess == 0 {
+		t.Errorf("CacheEntries() = %+v", entries[0])
+	}
 
-					return nil
-				},
-				afterFunc: func() error {
-					dummyServer.Close()
-					return nil
-				},
-			}
-		}(),
+	if _, err := r.RefreshCacheEntry(ctx, "domain3", "role", ""); err != ErrRoleCacheEntryNotFound {
+		t.Errorf("RefreshCacheEntry() error = %v, want %v", err, ErrRoleCacheEntryNotFound)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			defer tt.afterFunc()
-			r := &roleService{
-				cfg:                   tt.fields.cfg,
-				token:                 tt.fields.token,
-				athenzURL:             tt.fields.athenzURL,
-				athenzPrincipleHeader: tt.fields.athenzPrincipleHeader,
-				domainRoleCache:       tt.fields.domainRoleCache,
-				group:                 tt.fields.group,
-				expiry:                tt.fields.expiry,
-				httpClient:            tt.fields.httpClient,
-				refreshInterval:       tt.fields.refreshInterval,
-				errRetryMaxCount:      tt.fields.errRetryMaxCount,
-				errRetryInterval:      tt.fields.errRetryInterval,
+	if _, err := r.RefreshCacheEntry(ctx, "domain1", "role", ""); err != nil {
+		t.Fatalf("RefreshCacheEntry() error = %v", err)
+	}
+	if tok, _ := r.getCache("domain1", "role", ""); tok.Token != "token3" {
+		t.Errorf("token after RefreshCacheEntry() = %v, want token3", tok.Token)
+	}
+
+	if r.InvalidateCacheEntry("domain3", "role", "") {
+		t.Error("InvalidateCacheEntry() of not cached token = true")
+	}
+	if !r.InvalidateCacheEntry("domain1", "role", "") {
+		t.Error("InvalidateCacheEntry() = false")
+	}
+	if _, ok := r.getCache("domain1", "role", ""); ok {
+		t.Error("token is not invalidated")
+	}
+	if got := r.InvalidateAllCacheEntries(); got != 1 {
+		t.Errorf("InvalidateAllCacheEntries() = %v, want 1", got)
+	}
+	if got := r.CacheEntries(); len(got) != 0 {
+		t.Errorf("CacheEntries() after InvalidateAllCacheEntries() = %+v", got)
+	}
+}
+
+func Test_roleService_negativeCache(t *testing.T) {
+	var count int32
+	code := int32(http.StatusForbidden)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		if c := int(atomic.LoadInt32(&code)); c != http.StatusOK {
+			w.WriteHeader(c)
+			fmt.Fprint(w, `{"code":403,"message":"not a member"}`)
+			return
+		}
+		fmt.Fprintf(w, `{"token":"token","expiryTime":%d}`, fastime.Now().Add(time.Hour).Unix())
+	}))
+	defer srv.Close()
+
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             newTestEndpointPool(srv.URL),
+		athenzPrincipleHeader: "Athenz-Principal",
+		domainRoleCache:       gache.New(),
+		httpClient:            srv.Client(),
+		refreshInterval:       time.Hour,
+		negativeCacheTTL:      time.Millisecond * 100,
+		negativeCache:         gache.New(),
+	}
+	ctx := context.Background()
+
+	// the client error is returned without requesting athenz server again
+	for i := 0; i < 3; i++ {
+		_, err := r.getRoleToken(ctx, "domain", "role", "", 0, 0)
+		ze, ok := err.(*ZTSError)
+		if !ok || ze.StatusCode != http.StatusForbidden || ze.Message != "not a member" {
+			t.Fatalf("getRoleToken() error = %v, want ZTSError", err)
+		}
+	}
+	if got := atomic.LoadInt32(&count); got != 1 {
+		t.Errorf("requests to athenz server = %v, want 1", got)
+	}
+
+	// the other key is not affected
+	if _, err := r.getRoleToken(ctx, "domain", "role2", "", 0, 0); err == nil {
+		t.Fatal("getRoleToken() error = nil")
+	}
+	if got := atomic.LoadInt32(&count); got != 2 {
+		t.Errorf("requests to athenz server = %v, want 2", got)
+	}
+
+	// the server error is not cached
+	atomic.StoreInt32(&code, http.StatusInternalServerError)
+	for i := 0; i < 2; i++ {
+		if _, err := r.getRoleToken(ctx, "domain", "role3", "", 0, 0); err != ErrRoleTokenRequestFailed {
+			t.Fatalf("getRoleToken() error = %v, want %v", err, ErrRoleTokenRequestFailed)
+		}
+	}
+	if got := atomic.LoadInt32(&count); got != 4 {
+		t.Errorf("requests to athenz server = %v, want 4", got)
+	}
+
+	// athenz server is requested again after the TTL
+	atomic.StoreInt32(&code, http.StatusOK)
+	time.Sleep(time.Millisecond * 150)
+	if _, err := r.getRoleToken(ctx, "domain", "role", "", 0, 0); err != nil {
+		t.Errorf("getRoleToken() after TTL error = %v", err)
+	}
+
+	// the invalidation clears the cached error
+	r.negativeCache.SetWithExpire(encode("domain", "role2", ""), &ZTSError{StatusCode: http.StatusForbidden}, time.Hour)
+	if !r.InvalidateCacheEntry("domain", "role2", "") {
+		t.Error("InvalidateCacheEntry() of cached error = false")
+	}
+	if _, err := r.getRoleToken(ctx, "domain", "role2", "", 0, 0); err != nil {
+		t.Errorf("getRoleToken() after invalidation error = %v", err)
+	}
+}
+
+func Test_roleService_getRoleToken_incompatibleExpiry(t *testing.T) {
+	var count int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		exp := time.Hour
+		if max := r.URL.Query().Get("maxExpiryTime"); max != "" {
+			d, _ := strconv.Atoi(max)
+			exp = time.Duration(d) * time.Second
+		}
+		fmt.Fprintf(w, `{"token":"token%d","expiryTime":%d}`, int64(exp/time.Second), fastime.Now().Add(exp).Unix())
+	}))
+	defer srv.Close()
+
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             newTestEndpointPool(srv.URL),
+		athenzPrincipleHeader: "Athenz-Principal",
+		domainRoleCache:       gache.New(),
+		httpClient:            srv.Client(),
+		refreshInterval:       time.Hour,
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		// the long-lived token is cached
+		tok, err := r.getRoleToken(ctx, "domain", "role", "", 1800, 0)
+		if err != nil || tok.Token != "token3600" {
+			t.Fatalf("getRoleToken() with minExpiry = %v, %v, want token3600", tok, err)
+		}
+		// the short-lived token does not replace the cached one
+		tok, err = r.getRoleToken(ctx, "domain", "role", "", 0, 600)
+		if err != nil || tok.Token != "token600" {
+			t.Fatalf("getRoleToken() with maxExpiry = %v, %v, want token600", tok, err)
+		}
+	}
+	// both tokens are served from the cache after the first requests
+	if got := atomic.LoadInt32(&count); got != 2 {
+		t.Errorf("requests to athenz server = %v, want 2", got)
+	}
+	if cd, ok := r.getCacheData("domain", "role", ""); !ok || cd.token.Token != "token3600" || cd.minExpiry != 1800 {
+		t.Errorf("cached token = %+v, want token3600", cd)
+	}
+	if cd, ok := r.loadCacheData(expiryKey(encode("domain", "role", ""), 0, 600)); !ok || cd.token.Token != "token600" || cd.maxExpiry != 600 {
+		t.Errorf("separately cached token = %+v, want token600", cd)
+	}
+	if d, ro, p := decode(expiryKey(encode("domain", "role", ""), 0, 600)); d != "domain" || ro != "role" || p != "" {
+		t.Errorf("decode() = %v, %v, %v, want domain, role and empty principal", d, ro, p)
+	}
+
+	// the separately cached token is invalidated with the cached one
+	if !r.InvalidateCacheEntry("domain", "role", "") {
+		t.Fatal("InvalidateCacheEntry() = false, want true")
+	}
+	if n := r.domainRoleCache.Len(); n != 0 {
+		t.Errorf("cached tokens after invalidation = %v, want 0", n)
+	}
+
+	// the token satisfying the expiry of the cached one replaces it
+	if _, err := r.getRoleToken(ctx, "domain", "role2", "", 0, 0); err != nil {
+		t.Fatalf("getRoleToken() error: %v", err)
+	}
+	if tok, err := r.getRoleToken(ctx, "domain", "role2", "", 0, 600); err != nil || tok.Token != "token600" {
+		t.Fatalf("getRoleToken() with maxExpiry = %v, %v, want token600", tok, err)
+	}
+	if cd, ok := r.getCacheData("domain", "role2", ""); !ok || cd.token.Token != "token600" || cd.maxExpiry != 600 {
+		t.Errorf("cached token = %+v, want token600", cd)
+	}
+}
+
+func Test_roleService_fetchRoleToken_inflight(t *testing.T) {
+	var cur, max int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&cur, 1)
+		defer atomic.AddInt32(&cur, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
 			}
-			got := r.updateRoleTokenWithRetry(tt.args.ctx, tt.args.domain, tt.args.role, tt.args.proxyForPrincipal, tt.args.minExpiry, tt.args.maxExpiry)
-			if err := tt.checkFunc(got); err != nil {
-				t.Errorf("roleService.updateRoleTokenWithRetry(). error: %v", err)
+		}
+		time.Sleep(time.Millisecond * 50)
+		fmt.Fprint(w, `{"token":"dummyToken","expiryTime":1}`)
+	}))
+	defer srv.Close()
+
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             newTestEndpointPool(srv.URL),
+		athenzPrincipleHeader: "Athenz-Principal",
+		httpClient:            srv.Client(),
+		inflight:              make(chan struct{}, 2),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := r.fetchRoleToken(context.Background(), fmt.Sprintf("domain%d", i), "role", "", 0, 0); err != nil {
+				t.Errorf("fetchRoleToken() error: %v", err)
 			}
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&max); got > 2 {
+		t.Errorf("fetchRoleToken() concurrent requests = %v, want <= 2", got)
+	}
+}
+
+func Test_roleService_fetchRoleToken_failover(t *testing.T) {
+	var failed int32
+	down := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failed, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"token":"dummyToken","expiryTime":1}`)
+	}))
+	defer up.Close()
+
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             newTestEndpointPool(down.URL, up.URL),
+		athenzPrincipleHeader: "Athenz-Principal",
+		httpClient:            up.Client(),
+	}
+
+	for i := 0; i < 2; i++ {
+		got, err := r.fetchRoleToken(context.Background(), "domain", "role", "", 0, 0)
+		if err != nil {
+			t.Fatalf("fetchRoleToken() error: %v", err)
+		}
+		if got.Token != "dummyToken" {
+			t.Errorf("fetchRoleToken() = %v, want dummyToken", got.Token)
+		}
+	}
+
+	// the unhealthy endpoint is not tried while the healthy endpoint is available
+	if got := atomic.LoadInt32(&failed); got != 1 {
+		t.Errorf("requests to the unhealthy endpoint = %v, want 1", got)
+	}
+
+	// the server error of the last endpoint is returned
+	r.endpoints = newTestEndpointPool(down.URL)
+	if _, err := r.fetchRoleToken(context.Background(), "domain", "role", "", 0, 0); err != ErrRoleTokenRequestFailed {
+		t.Errorf("fetchRoleToken() error = %v, want %v", err, ErrRoleTokenRequestFailed)
+	}
+
+	// no endpoint
+	r.endpoints = newTestEndpointPool()
+	if _, err := r.fetchRoleToken(context.Background(), "domain", "role", "", 0, 0); err != ErrNoAthenzEndpoint {
+		t.Errorf("fetchRoleToken() error = %v, want %v", err, ErrNoAthenzEndpoint)
+	}
+}
+
+func Test_roleService_fetchRoleToken_circuitBreaker(t *testing.T) {
+	var failed int32
+	down := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&failed, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"token":"dummyToken","expiryTime":1}`)
+	}))
+	defer up.Close()
+
+	endpoints := newTestEndpointPool(down.URL, up.URL)
+	for _, ep := range endpoints.endpoints {
+		ep.breaker, _ = newCircuitBreaker(config.CircuitBreaker{
+			FailureThreshold: 1,
+			OpenDuration:     "1m",
 		})
 	}
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             endpoints,
+		athenzPrincipleHeader: "Athenz-Principal",
+		httpClient:            up.Client(),
+	}
+
+	for i := 0; i < 3; i++ {
+		// try the endpoint with the open circuit first
+		atomic.StoreInt64(&endpoints.endpoints[0].unhealthyUntil, 0)
+
+		got, err := r.fetchRoleToken(context.Background(), "domain", "role", "", 0, 0)
+		if err != nil {
+			t.Fatalf("fetchRoleToken() error: %v", err)
+		}
+		if got.Token != "dummyToken" {
+			t.Errorf("fetchRoleToken() = %v, want dummyToken", got.Token)
+		}
+	}
+
+	// the failure of the first endpoint opens only its own circuit
+	if got := atomic.LoadInt32(&failed); got != 1 {
+		t.Errorf("requests to the endpoint with the open circuit = %v, want 1", got)
+	}
+
+	// all the circuits are open
+	r.endpoints = newTestEndpointPool(down.URL)
+	r.endpoints.endpoints[0].breaker = endpoints.endpoints[0].breaker
+	if _, err := r.fetchRoleToken(context.Background(), "domain", "role", "", 0, 0); err != ErrCircuitOpen {
+		t.Errorf("fetchRoleToken() error = %v, want %v", err, ErrCircuitOpen)
+	}
+}
+
+func Test_roleService_prefetchRoleTokens(t *testing.T) {
+	var requests int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if strings.HasPrefix(r.URL.Path, "/domain/failed/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprintf(w, `{"token":"dummyToken","expiryTime":%d}`, fastime.Now().Add(time.Hour).Unix())
+	}))
+	defer srv.Close()
+
+	r := &roleService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		endpoints:             newTestEndpointPool(srv.URL),
+		athenzPrincipleHeader: "Athenz-Principal",
+		domainRoleCache:       gache.New(),
+		httpClient:            srv.Client(),
+		refreshInterval:       time.Hour,
+		errRetryMaxCount:      2,
+		errRetryInterval:      time.Millisecond,
+		prefetch: []config.PrefetchRole{
+			{
+				Domain: "cached",
+				Role:   "role",
+			},
+			{
+				Domain:    "domain",
+				Role:      "role",
+				MinExpiry: 600,
+			},
+			{
+				Domain: "failed",
+				Role:   "role",
+			},
+		},
+	}
+	r.domainRoleCache.Set(encode("cached", "role", ""), &cacheData{
+		token: &RoleToken{
+			Token:      "cachedToken",
+			ExpiryTime: fastime.Now().Add(time.Hour).Unix(),
+		},
+	})
+
+	if err := r.PrefetchCheck()(); err != ErrRoleTokenPrefetching {
+		t.Errorf("PrefetchCheck() before prefetch = %v, want %v", err, ErrRoleTokenPrefetching)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ech := r.StartRoleUpdater(ctx)
+
+	// the failed role token is retried errRetryMaxCount times
+	for i := 0; i < 3; i++ {
+		select {
+		case err := <-ech:
+			if !strings.Contains(err.Error(), "error prefetch role token, domain: failed") {
+				t.Errorf("StartRoleUpdater() error = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("StartRoleUpdater() prefetch error is not reported")
+		}
+	}
+	time.Sleep(time.Millisecond * 50)
+
+	if err := r.PrefetchCheck()(); err != nil {
+		t.Errorf("PrefetchCheck() after prefetch = %v", err)
+	}
+	if got := atomic.LoadInt32(&requests); got != 4 {
+		t.Errorf("prefetch requests = %v, want 4", got)
+	}
+	cd, ok := r.getCacheData("domain", "role", "")
+	if !ok || cd.token.Token != "dummyToken" || cd.minExpiry != 600 {
+		t.Errorf("prefetched cache = %+v, %v", cd, ok)
+	}
+	if tok, _ := r.getCache("cached", "role", ""); tok.Token != "cachedToken" {
+		t.Errorf("cached token is prefetched again, got %v", tok.Token)
+	}
 }
 
 func Test_roleService_updateRoleToken(t *testing.T) {
@@ -1706,7 +2570,7 @@ func Test_roleService_updateRoleToken(t *testing.T) {
 			r := &roleService{
 				cfg:                   tt.fields.cfg,
 				token:                 tt.fields.token,
-				athenzURL:             tt.fields.athenzURL,
+				endpoints:             newTestEndpointPool(tt.fields.athenzURL),
 				athenzPrincipleHeader: tt.fields.athenzPrincipleHeader,
 				domainRoleCache:       tt.fields.domainRoleCache,
 				group:                 tt.fields.group,
@@ -1898,7 +2762,7 @@ func Test_roleService_fetchRoleToken(t *testing.T) {
 			r := &roleService{
 				cfg:                   tt.fields.cfg,
 				token:                 tt.fields.token,
-				athenzURL:             tt.fields.athenzURL,
+				endpoints:             newTestEndpointPool(tt.fields.athenzURL),
 				athenzPrincipleHeader: tt.fields.athenzPrincipleHeader,
 				domainRoleCache:       tt.fields.domainRoleCache,
 				group:                 tt.fields.group,
@@ -1930,6 +2794,266 @@ func Test_roleService_fetchRoleToken(t *testing.T) {
 	}
 }
 
+func Test_roleService_nextRefreshDelay(t *testing.T) {
+	now := time.Unix(10000, 0)
+	type test struct {
+		name    string
+		r       *roleService
+		tok     *RoleToken
+		wantMin time.Duration
+		wantMax time.Duration
+	}
+	tests := []test{
+		{
+			name: "nextRefreshDelay return the ratio of remaining lifetime",
+			r: &roleService{
+				refreshRatio:    0.75,
+				refreshInterval: time.Hour * 2,
+			},
+			tok: &RoleToken{
+				ExpiryTime: now.Add(time.Hour).Unix(),
+			},
+			wantMin: time.Minute * 45,
+			wantMax: time.Minute * 45,
+		},
+		{
+			name: "nextRefreshDelay return the ratio of remaining lifetime with jitter",
+			r: &roleService{
+				refreshRatio:    0.75,
+				refreshJitter:   0.2,
+				refreshInterval: time.Hour * 2,
+			},
+			tok: &RoleToken{
+				ExpiryTime: now.Add(time.Hour).Unix(),
+			},
+			wantMin: time.Minute * 36,
+			wantMax: time.Minute * 45,
+		},
+		{
+			name: "nextRefreshDelay return the refresh interval when it is shorter",
+			r: &roleService{
+				refreshRatio:    0.75,
+				refreshInterval: time.Minute * 30,
+			},
+			tok: &RoleToken{
+				ExpiryTime: now.Add(time.Hour).Unix(),
+			},
+			wantMin: time.Minute * 30,
+			wantMax: time.Minute * 30,
+		},
+		{
+			name: "nextRefreshDelay return the refresh interval with jitter when it is shorter",
+			r: &roleService{
+				refreshRatio:    0.75,
+				refreshJitter:   0.2,
+				refreshInterval: time.Minute * 30,
+			},
+			tok: &RoleToken{
+				ExpiryTime: now.Add(time.Hour).Unix(),
+			},
+			wantMin: time.Minute * 24,
+			wantMax: time.Minute * 30,
+		},
+		{
+			name: "nextRefreshDelay return the minimum delay for expired token",
+			r: &roleService{
+				refreshRatio:    0.75,
+				refreshInterval: time.Minute * 30,
+			},
+			tok: &RoleToken{
+				ExpiryTime: now.Add(-time.Hour).Unix(),
+			},
+			wantMin: minRefreshDelay,
+			wantMax: minRefreshDelay,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.r.nextRefreshDelay(tt.tok, now)
+			if got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("nextRefreshDelay() = %v, want [%v, %v]", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+
+	// the role tokens capped by the refresh interval are spread by the jitter
+	r := &roleService{
+		refreshRatio:    0.75,
+		refreshJitter:   0.2,
+		refreshInterval: time.Minute * 30,
+	}
+	tok := &RoleToken{
+		ExpiryTime: now.Add(time.Hour * 24).Unix(),
+	}
+	delays := make(map[time.Duration]bool)
+	for i := 0; i < 10; i++ {
+		delays[r.nextRefreshDelay(tok, now)] = true
+	}
+	if len(delays) < 2 {
+		t.Errorf("nextRefreshDelay() = %v, want the different delays by the jitter", delays)
+	}
+}
+
+func Test_satisfyExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	tok := &RoleToken{
+		ExpiryTime: 1600,
+	}
+	tests := []struct {
+		name      string
+		minExpiry int64
+		maxExpiry int64
+		want      bool
+	}{
+		{
+			name: "satisfyExpiry return true when expiry is not specified",
+			want: true,
+		},
+		{
+			name:      "satisfyExpiry return true when remaining lifetime is in the range",
+			minExpiry: 300,
+			maxExpiry: 600,
+			want:      true,
+		},
+		{
+			name:      "satisfyExpiry return false when remaining lifetime < min expiry",
+			minExpiry: 601,
+			want:      false,
+		},
+		{
+			name:      "satisfyExpiry return false when remaining lifetime > max expiry",
+			maxExpiry: 599,
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := satisfyExpiry(tok, now, tt.minExpiry, tt.maxExpiry); got != tt.want {
+				t.Errorf("satisfyExpiry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_roleService_markStale(t *testing.T) {
+	type test struct {
+		name         string
+		cache        func() gache.Gache
+		times        int
+		wantOK       bool
+		wantFailures int32
+		wantBackoff  time.Duration
+	}
+	tests := []test{
+		{
+			name: "markStale do nothing when cache not exist",
+			cache: func() gache.Gache {
+				return gache.New()
+			},
+			times: 1,
+		},
+		{
+			name: "markStale delete expired token",
+			cache: func() gache.Gache {
+				gac := gache.New()
+				gac.Set("dummyDomain;dummyRole", &cacheData{
+					token: &RoleToken{
+						ExpiryTime: fastime.Now().Add(-time.Hour).Unix(),
+					},
+				})
+				return gac
+			},
+			times: 1,
+		},
+		{
+			name: "markStale double the backoff up to refresh interval",
+			cache: func() gache.Gache {
+				gac := gache.New()
+				gac.Set("dummyDomain;dummyRole", &cacheData{
+					token: &RoleToken{
+						ExpiryTime: fastime.Now().Add(time.Hour).Unix(),
+					},
+				})
+				return gac
+			},
+			times:        5,
+			wantOK:       true,
+			wantFailures: 5,
+			wantBackoff:  time.Second * 4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &roleService{
+				domainRoleCache:  tt.cache(),
+				errRetryInterval: time.Second,
+				refreshInterval:  time.Second * 4,
+			}
+			for i := 0; i < tt.times; i++ {
+				r.markStale("dummyDomain;dummyRole")
+			}
+
+			val, ok := r.domainRoleCache.Get("dummyDomain;dummyRole")
+			if ok != tt.wantOK {
+				t.Errorf("markStale() cache exists = %v, want %v", ok, tt.wantOK)
+				return
+			}
+			if !ok {
+				return
+			}
+			cd := val.(*cacheData)
+			if got := atomic.LoadInt32(&cd.failures); got != tt.wantFailures {
+				t.Errorf("markStale() failures = %v, want %v", got, tt.wantFailures)
+			}
+			if d := time.Until(time.Unix(0, atomic.LoadInt64(&cd.nextRetry))); d > tt.wantBackoff || d < tt.wantBackoff-time.Second {
+				t.Errorf("markStale() next retry after %v, want %v", d, tt.wantBackoff)
+			}
+		})
+	}
+}
+
+func Test_roleService_markStale_concurrent(t *testing.T) {
+	cd := &cacheData{
+		token: &RoleToken{
+			ExpiryTime: fastime.Now().Add(time.Hour).Unix(),
+		},
+	}
+	gac := gache.New()
+	gac.Set("dummyDomain;dummyRole", cd)
+	r := &roleService{
+		domainRoleCache:  gac,
+		errRetryInterval: time.Second,
+		refreshInterval:  time.Second * 4,
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			r.markStale("dummyDomain;dummyRole")
+		}()
+		go func(i int64) {
+			defer wg.Done()
+			atomic.StoreInt64(&cd.refreshAt, i)
+			atomic.StoreInt64(&cd.lastAccess, i)
+		}(int64(i + 1))
+	}
+	wg.Wait()
+
+	// the cached entry is updated in place, so that none of the updates is lost
+	val, ok := r.domainRoleCache.Get("dummyDomain;dummyRole")
+	if !ok || val.(*cacheData) != cd {
+		t.Fatalf("markStale() replaced the cached entry, got %v, %v", val, ok)
+	}
+	if got := atomic.LoadInt32(&cd.failures); got != 10 {
+		t.Errorf("markStale() failures = %v, want 10", got)
+	}
+	if atomic.LoadInt64(&cd.refreshAt) == 0 || atomic.LoadInt64(&cd.lastAccess) == 0 {
+		t.Errorf("the concurrent updates are lost, refreshAt = %v, lastAccess = %v", cd.refreshAt, cd.lastAccess)
+	}
+}
+
 func Test_roleService_getCache(t *testing.T) {
 	type fields struct {
 		cfg                   config.Role
@@ -2003,7 +3127,7 @@ func Test_roleService_getCache(t *testing.T) {
 			r := &roleService{
 				cfg:                   tt.fields.cfg,
 				token:                 tt.fields.token,
-				athenzURL:             tt.fields.athenzURL,
+				endpoints:             newTestEndpointPool(tt.fields.athenzURL),
 				athenzPrincipleHeader: tt.fields.athenzPrincipleHeader,
 				domainRoleCache:       tt.fields.domainRoleCache,
 				group:                 tt.fields.group,
@@ -2248,6 +3372,24 @@ func Test_createGetRoleTokenRequest(t *testing.T) {
 				r, _ := http.NewRequest(http.MethodGet, "https://dummyUURL/domain/dummyDomain/token?maxExpiryTime=1&minExpiryTime=1", nil)
 				r.Header.Set("dummyHeader", "dummyToken")
 
+				return r
+			}(),
+		},
+		{
+			name: "createGetRoleTokenRequest escape domain",
+			args: args{
+				domain: "../../admin?role=",
+				role:   "dummyRole",
+				token:  "dummyToken",
+			},
+			fields: fields{
+				athenzURL:             "dummyUURL",
+				athenzPrincipleHeader: "dummyHeader",
+			},
+			want: func() *http.Request {
+				r, _ := http.NewRequest(http.MethodGet, "https://dummyUURL/domain/..%2F..%2Fadmin%3Frole=/token?role=dummyRole", nil)
+				r.Header.Set("dummyHeader", "dummyToken")
+
 				return r
 			}(),
 		},
@@ -2257,13 +3399,12 @@ func Test_createGetRoleTokenRequest(t *testing.T) {
 			r := &roleService{
 				cfg:                   tt.fields.cfg,
 				token:                 tt.fields.token,
-				athenzURL:             tt.fields.athenzURL,
 				athenzPrincipleHeader: tt.fields.athenzPrincipleHeader,
 				domainRoleCache:       tt.fields.domainRoleCache,
 				group:                 tt.fields.group,
 				expiry:                tt.fields.expiry,
 			}
-			got, err := r.createGetRoleTokenRequest(tt.args.domain, tt.args.role, tt.args.minExpiry, tt.args.maxExpiry, tt.args.proxyForPrincipal, tt.args.token)
+			got, err := r.createGetRoleTokenRequest(tt.fields.athenzURL, tt.args.domain, tt.args.role, tt.args.minExpiry, tt.args.maxExpiry, tt.args.proxyForPrincipal, tt.args.token)
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("createGetRoleTokenRequest(), got: %+v, want: %+v", got, tt.want)
 			}
@@ -0,0 +1,406 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/kpango/gache"
+	"github.com/kpango/glg"
+	ntokend "github.com/kpango/ntokend"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+	"golang.org/x/sync/singleflight"
+)
+
+// RoleCertService represent a interface to automatically refresh the role certificate, and a role certificate provider function pointer.
+type RoleCertService interface {
+	StartRoleCertUpdater(context.Context) <-chan error
+	RefreshRoleCertCache(ctx context.Context) <-chan error
+	GetRoleCertProvider() RoleCertProvider
+}
+
+// roleCertService represent the implementation of athenz RoleCertService
+type roleCertService struct {
+	cfg                   config.RoleCert
+	token                 ntokend.TokenProvider
+	athenzURL             string
+	athenzPrincipleHeader string
+	principal             string
+	key                   crypto.Signer
+	roleCertCache         gache.Gache
+	group                 singleflight.Group
+	expiry                time.Duration
+	httpClient            *http.Client
+
+	checkInterval    time.Duration
+	refreshBefore    time.Duration
+	errRetryMaxCount int
+	errRetryInterval time.Duration
+}
+
+type roleCertCacheData struct {
+	cert              *RoleCert
+	domain            string
+	role              string
+	proxyForPrincipal string
+	expiry            int64
+	refreshAt         int64
+}
+
+// RoleCert represent the role certificate.
+type RoleCert struct {
+	Cert       string `json:"cert"`
+	ExpiryTime int64  `json:"expiryTime"`
+}
+
+// RoleCertProvider represent a function pointer to get the role certificate.
+type RoleCertProvider func(ctx context.Context, domain string, role string, proxyForPrincipal string, expiry int64) (*RoleCert, error)
+
+// roleCertificateRequest represent the request body of the ZTS role certificate API.
+type roleCertificateRequest struct {
+	CSR               string `json:"csr"`
+	ProxyForPrincipal string `json:"proxyForPrincipal,omitempty"`
+	ExpiryTime        int64  `json:"expiryTime,omitempty"`
+}
+
+var (
+	// ErrRoleCertRequestFailed represent an error when failed to fetch the role certificate from RoleCertProvider.
+	ErrRoleCertRequestFailed = errors.New("Failed to fetch role certificate")
+)
+
+const (
+	// defaultCertCheckInterval represents the default interval to check whether the cached certificates should be refreshed.
+	defaultCertCheckInterval = time.Minute
+)
+
+// NewRoleCertService returns a RoleCertService to update and get the role certificate from athenz.
+// The certificate signing request is signed by the private key of the service identity defined in the token configuration.
+func NewRoleCertService(cfg config.RoleCert, tcfg config.Token, token ntokend.TokenProvider) (RoleCertService, error) {
+	var (
+		err              error
+		exp              = defaultCertExpiry
+		refreshBefore    = defaultCertRefreshBefore
+		errRetryInterval = defaultErrRetryInterval
+	)
+
+	if cfg.Expiration != "" {
+		if exp, err = time.ParseDuration(cfg.Expiration); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "Expiration: "+err.Error())
+		}
+	}
+	if cfg.RefreshBefore != "" {
+		if refreshBefore, err = time.ParseDuration(cfg.RefreshBefore); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "RefreshBefore: "+err.Error())
+		}
+	}
+	if cfg.ErrRetryInterval != "" {
+		if errRetryInterval, err = time.ParseDuration(cfg.ErrRetryInterval); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryInterval: "+err.Error())
+		}
+	}
+
+	errRetryMaxCount := defaultErrRetryMaxCount
+	if cfg.ErrRetryMaxCount > 0 {
+		errRetryMaxCount = cfg.ErrRetryMaxCount
+	} else if cfg.ErrRetryMaxCount != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0")
+	}
+
+	domain := config.GetActualValue(tcfg.AthenzDomain)
+	service := config.GetActualValue(tcfg.ServiceName)
+	if domain == "" || service == "" {
+		return nil, errors.Wrap(ErrInvalidSetting, "athenz domain and service name are required")
+	}
+
+	key, err := loadPrivateKey(config.GetActualValue(tcfg.PrivateKeyPath))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, "PrivateKeyPath: "+err.Error())
+	}
+
+	return &roleCertService{
+		cfg:                   cfg,
+		token:                 token,
+		athenzURL:             cfg.AthenzURL,
+		athenzPrincipleHeader: cfg.PrincipalAuthHeaderName,
+		principal:             domain + "." + service,
+		key:                   key,
+		roleCertCache:         gache.New(),
+		expiry:                exp,
+		httpClient:            newAthenzHTTPClient(cfg.AthenzRootCA),
+		checkInterval:         defaultCertCheckInterval,
+		refreshBefore:         refreshBefore,
+		errRetryMaxCount:      errRetryMaxCount,
+		errRetryInterval:      errRetryInterval,
+	}, nil
+}
+
+// StartRoleCertUpdater returns RoleCertService.
+// This function will periodically refresh the role certificates which are going to expire.
+func (r *roleCertService) StartRoleCertUpdater(ctx context.Context) <-chan error {
+	glg.Info("Starting role certificate updater")
+
+	ech := make(chan error, 100)
+	go func() {
+		defer close(ech)
+
+		ticker := time.NewTicker(r.checkInterval)
+		for {
+			select {
+			case <-ctx.Done():
+				glg.Info("Stopping role certificate updater")
+				ticker.Stop()
+				ech <- ctx.Err()
+				return
+			case <-ticker.C:
+				for err := range r.RefreshRoleCertCache(ctx) {
+					ech <- errors.Wrap(err, "error update role certificate")
+				}
+			}
+		}
+	}()
+
+	r.roleCertCache.StartExpired(ctx, expiryCheckInterval)
+	r.roleCertCache.EnableExpiredHook().SetExpiredHook(func(ctx context.Context, k string) {
+		glg.Warnf("the following role certificate cache is expired, key: %v", k)
+	})
+	return ech
+}
+
+// GetRoleCertProvider returns a function pointer to get the role certificate.
+func (r *roleCertService) GetRoleCertProvider() RoleCertProvider {
+	return r.getRoleCert
+}
+
+// getRoleCert returns RoleCert struct or error.
+// This function will return the role certificate stored inside the cache, or fetch the role certificate from athenz when corresponding role certificate cannot be found in the cache.
+func (r *roleCertService) getRoleCert(ctx context.Context, domain, role, proxyForPrincipal string, expiry int64) (*RoleCert, error) {
+	val, ok := r.roleCertCache.Get(roleCertKey(domain, role, proxyForPrincipal, expiry))
+	if !ok {
+		return r.updateRoleCert(ctx, domain, role, proxyForPrincipal, expiry)
+	}
+	return val.(*roleCertCacheData).cert, nil
+}
+
+// RefreshRoleCertCache returns the error channel when it is updated.
+// Only the role certificates which reach the refresh time are refreshed.
+func (r *roleCertService) RefreshRoleCertCache(ctx context.Context) <-chan error {
+	glg.Debug("RefreshRoleCertCache started")
+
+	echan := make(chan error, r.roleCertCache.Len()*(r.errRetryMaxCount+1))
+	go func() {
+		defer close(echan)
+
+		now := fastime.Now().Unix()
+		r.roleCertCache.Foreach(ctx, func(key string, val interface{}, exp int64) bool {
+			cd := val.(*roleCertCacheData)
+			if cd.refreshAt > now {
+				return true
+			}
+
+			for err := range r.updateRoleCertWithRetry(ctx, cd.domain, cd.role, cd.proxyForPrincipal, cd.expiry) {
+				echan <- err
+			}
+			return true
+		})
+	}()
+
+	return echan
+}
+
+func (r *roleCertService) updateRoleCertWithRetry(ctx context.Context, domain, role, proxyForPrincipal string, expiry int64) <-chan error {
+	glg.Debugf("updateRoleCertWithRetry started, domain: %s, role: %s, proxyForPrincipal: %s, expiry: %d", domain, role, proxyForPrincipal, expiry)
+
+	echan := make(chan error, r.errRetryMaxCount+1)
+	go func() {
+		defer close(echan)
+
+		for i := 0; i <= r.errRetryMaxCount; i++ {
+			_, err := r.updateRoleCert(ctx, domain, role, proxyForPrincipal, expiry)
+			if err == nil {
+				glg.Debug("update success")
+				return
+			}
+			echan <- err
+			if i == r.errRetryMaxCount {
+				return
+			}
+			t := time.NewTimer(r.errRetryInterval)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	return echan
+}
+
+// updateRoleCert returns RoleCert struct or error.
+// This function ask athenz to issue the role certificate and cache it, or return any error when issuing the role certificate.
+func (r *roleCertService) updateRoleCert(ctx context.Context, domain, role, proxyForPrincipal string, expiry int64) (*RoleCert, error) {
+	// the role certificates requested with different lifetime are cached and shared separately
+	key := roleCertKey(domain, role, proxyForPrincipal, expiry)
+
+	rc, err, _ := r.group.Do(key, func() (interface{}, error) {
+		rc, e := r.fetchRoleCert(ctx, domain, role, proxyForPrincipal, expiry)
+		if e != nil {
+			return nil, e
+		}
+
+		now := fastime.Now()
+		notAfter := time.Unix(rc.ExpiryTime, 0)
+		r.roleCertCache.SetWithExpire(key, &roleCertCacheData{
+			cert:              rc,
+			domain:            domain,
+			role:              role,
+			proxyForPrincipal: proxyForPrincipal,
+			expiry:            expiry,
+			refreshAt:         now.Add(nextCertRefresh(now, notAfter, r.refreshBefore)).Unix(),
+		}, notAfter.Sub(now))
+
+		glg.Debugf("role certificate is cached, domain: %s, role: %s, proxyForPrincipal: %s, expiry time: %v", domain, role, proxyForPrincipal, rc.ExpiryTime)
+		return rc, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return rc.(*RoleCert), nil
+}
+
+// fetchRoleCert fetch the role certificate from Athenz server, and return the decoded role certificate and any error if occurred.
+func (r *roleCertService) fetchRoleCert(ctx context.Context, domain, role, proxyForPrincipal string, expiry int64) (*RoleCert, error) {
+	glg.Debugf("get role certificate, domain: %s, role: %s, proxyForPrincipal: %s, expiry: %d", domain, role, proxyForPrincipal, expiry)
+
+	// get the n-token
+	tok, err := r.token()
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare request object
+	req, err := r.createPostRoleCertRequest(domain, role, proxyForPrincipal, expiry, tok)
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, err
+	}
+	glg.Debugf("request url: %v", req.URL)
+
+	res, err := doZTSRequest(ctx, r.httpClient, nil, "rolecert", req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer flushAndClose(res.Body)
+	if res.StatusCode != http.StatusOK {
+		buf := new(bytes.Buffer)
+		if _, err := buf.ReadFrom(res.Body); err != nil {
+			glg.Debugf("cannot read response body, err: %v", err)
+		}
+		glg.Debugf("error return from server, response:%+v, body: %v", res, buf.String())
+		return nil, ErrRoleCertRequestFailed
+	}
+
+	var data *RoleToken
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	notAfter, err := certNotAfter(data.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RoleCert{
+		Cert:       data.Token,
+		ExpiryTime: notAfter.Unix(),
+	}, nil
+}
+
+// roleCertKey returns the cache key of the role certificate requested with expiry.
+func roleCertKey(domain, role, principal string, expiry int64) string {
+	return encode(domain, role, principal) + expirySeparater + strconv.FormatInt(expiry, 10)
+}
+
+// createPostRoleCertRequest returns the role certificate request, expiry is the requested lifetime in seconds.
+func (r *roleCertService) createPostRoleCertRequest(domain, role, proxyForPrincipal string, expiry int64, token string) (*http.Request, error) {
+	u := fmt.Sprintf("https://%s/domain/%s/role/%s/token", strings.TrimPrefix(strings.TrimPrefix(r.athenzURL, "https://"), "http://"), url.PathEscape(domain), url.PathEscape(role))
+
+	csr, err := createCSR(r.key, roleCertCSRTemplate(domain, role, r.principal, r.cfg.DNSSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	exp := int64(r.expiry / time.Second)
+	if expiry > 0 {
+		exp = expiry
+	}
+
+	body, err := json.Marshal(roleCertificateRequest{
+		CSR:               csr,
+		ProxyForPrincipal: proxyForPrincipal,
+		ExpiryTime:        exp / int64(time.Minute/time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// set authenication token
+	req.Header.Set(r.athenzPrincipleHeader, token)
+
+	return req, nil
+}
+
+// roleCertCSRTemplate returns the CSR template of the role certificate of domain:role.role for the principal.
+// The SAN email is "<principal>@<dnsSuffix>", and the SAN URI is the SPIFFE ID of the role.
+func roleCertCSRTemplate(domain, role, principal, dnsSuffix string) csrTemplate {
+	t := csrTemplate{
+		commonName: domain + ":role." + role,
+		uris: []*url.URL{
+			{
+				Scheme: "spiffe",
+				Host:   domain,
+				Path:   "/ra/" + role,
+			},
+		},
+	}
+	if dnsSuffix != "" {
+		t.emails = []string{
+			principal + "@" + dnsSuffix,
+		}
+	}
+	return t
+}
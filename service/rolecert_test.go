@@ -0,0 +1,432 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/kpango/gache"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+func TestNewRoleCertService(t *testing.T) {
+	tokenCfg := config.Token{
+		AthenzDomain:   "dummyDomain",
+		ServiceName:    "dummyService",
+		PrivateKeyPath: "./assets/dummyServer.key",
+	}
+	type test struct {
+		name      string
+		cfg       config.RoleCert
+		tokenCfg  config.Token
+		checkFunc func(got RoleCertService) error
+		wantErr   error
+	}
+	tests := []test{
+		{
+			name: "NewRoleCertService return correct",
+			cfg: config.RoleCert{
+				AthenzURL:               "dummy",
+				PrincipalAuthHeaderName: "dummyAuthHeader",
+				DNSSuffix:               "athenz.cloud",
+				Expiration:              "720h",
+				RefreshBefore:           "1h",
+				ErrRetryMaxCount:        3,
+				ErrRetryInterval:        "1s",
+			},
+			tokenCfg: tokenCfg,
+			checkFunc: func(got RoleCertService) error {
+				r := got.(*roleCertService)
+				if r.athenzURL != "dummy" ||
+					r.athenzPrincipleHeader != "dummyAuthHeader" ||
+					r.principal != "dummyDomain.dummyService" ||
+					r.key == nil ||
+					r.roleCertCache == nil ||
+					r.expiry != time.Hour*720 ||
+					r.checkInterval != defaultCertCheckInterval ||
+					r.refreshBefore != time.Hour ||
+					r.errRetryMaxCount != 3 ||
+					r.errRetryInterval != time.Second {
+					return fmt.Errorf("got: %+v", r)
+				}
+				return nil
+			},
+		},
+		{
+			name: "NewRoleCertService return error with Expiration of invalid format",
+			cfg: config.RoleCert{
+				Expiration: "1x",
+			},
+			tokenCfg: tokenCfg,
+			wantErr:  errors.Wrap(ErrInvalidSetting, `Expiration: time: unknown unit "x" in duration "1x"`),
+		},
+		{
+			name: "NewRoleCertService return error with negative ErrRetryMaxCount",
+			cfg: config.RoleCert{
+				ErrRetryMaxCount: -1,
+			},
+			tokenCfg: tokenCfg,
+			wantErr:  errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0"),
+		},
+		{
+			name:     "NewRoleCertService return error without athenz domain",
+			tokenCfg: config.Token{ServiceName: "dummyService"},
+			wantErr:  errors.Wrap(ErrInvalidSetting, "athenz domain and service name are required"),
+		},
+		{
+			name: "NewRoleCertService return error with invalid private key",
+			tokenCfg: config.Token{
+				AthenzDomain:   "dummyDomain",
+				ServiceName:    "dummyService",
+				PrivateKeyPath: "./assets/dummyServer.crt",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "PrivateKeyPath: unsupported PEM type CERTIFICATE: Invalid private key"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewRoleCertService(tt.cfg, tt.tokenCfg, nil)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("error not the same, want: %v, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewRoleCertService() error: %v", err)
+				return
+			}
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("NewRoleCertService() %v", err)
+			}
+		})
+	}
+}
+
+func Test_roleCertService_getRoleCert(t *testing.T) {
+	cert, err := ioutil.ReadFile("./assets/dummyServer.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAfter, _ := certNotAfter(string(cert))
+	key, err := loadPrivateKey("./assets/dummyServer.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		domain            string
+		role              string
+		proxyForPrincipal string
+		expiry            int64
+	}
+	type test struct {
+		name      string
+		service   func() *roleCertService
+		args      args
+		want      *RoleCert
+		wantErr   error
+		afterFunc func()
+	}
+	tests := []test{
+		func() test {
+			c := gache.New()
+			rc := &RoleCert{
+				Cert:       "cachedCert",
+				ExpiryTime: fastime.Now().Add(time.Hour).Unix(),
+			}
+			c.Set(roleCertKey("dummyDomain", "dummyRole", "", 0), &roleCertCacheData{
+				cert: rc,
+			})
+			return test{
+				name: "getRoleCert return the cached certificate",
+				service: func() *roleCertService {
+					return &roleCertService{
+						roleCertCache: c,
+					}
+				},
+				args: args{
+					domain: "dummyDomain",
+					role:   "dummyRole",
+				},
+				want: rc,
+			}
+		}(),
+		func() test {
+			c := gache.New()
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/domain/dummyDomain/role/dummyRole/token" || r.Header.Get("Athenz-Principal") != "dummyNToken" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				var req roleCertificateRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.CSR == "" || req.ProxyForPrincipal != "dummyProxy" || req.ExpiryTime != 60 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				json.NewEncoder(w).Encode(RoleToken{
+					Token:      string(cert),
+					ExpiryTime: 1,
+				})
+			}))
+			return test{
+				name: "getRoleCert fetch the certificate when it is not cached",
+				service: func() *roleCertService {
+					return &roleCertService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						principal:             "dummyDomain.dummyService",
+						key:                   key,
+						roleCertCache:         c,
+						expiry:                time.Hour * 24,
+						httpClient:            dummyServer.Client(),
+						refreshBefore:         time.Hour,
+					}
+				},
+				args: args{
+					domain:            "dummyDomain",
+					role:              "dummyRole",
+					proxyForPrincipal: "dummyProxy",
+					expiry:            3600,
+				},
+				want: &RoleCert{
+					Cert:       string(cert),
+					ExpiryTime: notAfter.Unix(),
+				},
+				afterFunc: func() {
+					dummyServer.Close()
+					if _, ok := c.Get(roleCertKey("dummyDomain", "dummyRole", "dummyProxy", 3600)); !ok {
+						t.Error("getRoleCert() did not cache the certificate")
+					}
+				},
+			}
+		}(),
+		func() test {
+			c := gache.New()
+			c.Set(roleCertKey("dummyDomain", "dummyRole", "", 0), &roleCertCacheData{
+				cert: &RoleCert{
+					Cert:       "cachedCert",
+					ExpiryTime: fastime.Now().Add(time.Hour * 24).Unix(),
+				},
+			})
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req roleCertificateRequest
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ExpiryTime != 60 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				json.NewEncoder(w).Encode(RoleToken{
+					Token:      string(cert),
+					ExpiryTime: 1,
+				})
+			}))
+			return test{
+				name: "getRoleCert fetch the certificate when it is cached with the other expiry",
+				service: func() *roleCertService {
+					return &roleCertService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						principal:             "dummyDomain.dummyService",
+						key:                   key,
+						roleCertCache:         c,
+						expiry:                time.Hour * 24,
+						httpClient:            dummyServer.Client(),
+						refreshBefore:         time.Hour,
+					}
+				},
+				args: args{
+					domain: "dummyDomain",
+					role:   "dummyRole",
+					expiry: 3600,
+				},
+				want: &RoleCert{
+					Cert:       string(cert),
+					ExpiryTime: notAfter.Unix(),
+				},
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+		func() test {
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			}))
+			return test{
+				name: "getRoleCert return error when athenz return error",
+				service: func() *roleCertService {
+					return &roleCertService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						key:                   key,
+						roleCertCache:         gache.New(),
+						httpClient:            dummyServer.Client(),
+					}
+				},
+				args: args{
+					domain: "dummyDomain",
+					role:   "dummyRole",
+				},
+				wantErr: ErrRoleCertRequestFailed,
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+		func() test {
+			ntokenErr := fmt.Errorf("dummy n-token error")
+			return test{
+				name: "getRoleCert return error when getting n-token failed",
+				service: func() *roleCertService {
+					return &roleCertService{
+						token: func() (string, error) {
+							return "", ntokenErr
+						},
+						roleCertCache: gache.New(),
+					}
+				},
+				wantErr: ntokenErr,
+			}
+		}(),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.afterFunc != nil {
+				defer tt.afterFunc()
+			}
+			got, err := tt.service().getRoleCert(context.Background(), tt.args.domain, tt.args.role, tt.args.proxyForPrincipal, tt.args.expiry)
+			if err != tt.wantErr {
+				t.Errorf("getRoleCert() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getRoleCert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_roleCertService_RefreshRoleCertCache(t *testing.T) {
+	cert, err := ioutil.ReadFile("./assets/dummyServer.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := loadPrivateKey("./assets/dummyServer.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requested int32
+	dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/domain/dummyDomain/role/refreshRole/token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		atomic.AddInt32(&requested, 1)
+		json.NewEncoder(w).Encode(RoleToken{
+			Token: string(cert),
+		})
+	}))
+	defer dummyServer.Close()
+
+	c := gache.New()
+	now := fastime.Now()
+	c.Set(roleCertKey("dummyDomain", "refreshRole", "", 0), &roleCertCacheData{
+		cert:      &RoleCert{Cert: "oldCert"},
+		domain:    "dummyDomain",
+		role:      "refreshRole",
+		refreshAt: now.Add(-time.Minute).Unix(),
+	})
+	c.Set(roleCertKey("dummyDomain", "freshRole", "", 0), &roleCertCacheData{
+		cert:      &RoleCert{Cert: "freshCert"},
+		domain:    "dummyDomain",
+		role:      "freshRole",
+		refreshAt: now.Add(time.Hour).Unix(),
+	})
+
+	r := &roleCertService{
+		token: func() (string, error) {
+			return "dummyNToken", nil
+		},
+		athenzURL:             dummyServer.URL,
+		athenzPrincipleHeader: "Athenz-Principal",
+		key:                   key,
+		roleCertCache:         c,
+		httpClient:            dummyServer.Client(),
+		refreshBefore:         time.Hour,
+	}
+
+	for err := range r.RefreshRoleCertCache(context.Background()) {
+		t.Errorf("RefreshRoleCertCache() error: %v", err)
+	}
+
+	if got := atomic.LoadInt32(&requested); got != 1 {
+		t.Errorf("RefreshRoleCertCache() requested %d times, want 1", got)
+	}
+	if v, ok := c.Get(roleCertKey("dummyDomain", "refreshRole", "", 0)); !ok || v.(*roleCertCacheData).cert.Cert != string(cert) {
+		t.Errorf("RefreshRoleCertCache() did not refresh the certificate, got: %+v", v)
+	}
+	if v, ok := c.Get(roleCertKey("dummyDomain", "freshRole", "", 0)); !ok || v.(*roleCertCacheData).cert.Cert != "freshCert" {
+		t.Errorf("RefreshRoleCertCache() should not refresh the certificate, got: %+v", v)
+	}
+}
+
+func Test_roleCertCSRTemplate(t *testing.T) {
+	tests := []struct {
+		name       string
+		dnsSuffix  string
+		wantEmails []string
+	}{
+		{
+			name:       "template with DNS suffix",
+			dnsSuffix:  "athenz.cloud",
+			wantEmails: []string{"dummyDomain.dummyService@athenz.cloud"},
+		},
+		{
+			name: "template without DNS suffix",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := roleCertCSRTemplate("dummy.domain", "dummyRole", "dummyDomain.dummyService", tt.dnsSuffix)
+			if got.commonName != "dummy.domain:role.dummyRole" ||
+				!reflect.DeepEqual(got.emails, tt.wantEmails) ||
+				len(got.uris) != 1 || got.uris[0].String() != "spiffe://dummy.domain/ra/dummyRole" {
+				t.Errorf("roleCertCSRTemplate() = %+v", got)
+			}
+		})
+	}
+}
@@ -17,19 +17,26 @@ package service
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/kpango/glg"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
+	"github.com/yahoojapan/athenz-client-sidecar/metrics"
 )
 
 // Server represents a client sidecar server behavior
 type Server interface {
 	ListenAndServe(context.Context) chan []error
+	ReloadTLS(config.TLS) error
+	PrepareReloadTLS(config.TLS) (func(), error)
+	TLSCheck() HealthCheck
 }
 
 type server struct {
@@ -38,9 +45,18 @@ type server struct {
 	srvHandler http.Handler
 	srvRunning bool
 
+	// tlsConfig represents the current *tlsReloader of client sidecar server, which can be replaced by ReloadTLS.
+	tlsConfig atomic.Value
+
 	// Health Check server
 	hcsrv     *http.Server
 	hcrunning bool
+	hc        HealthChecker
+
+	// Admin server
+	admsrv     *http.Server
+	admHandler http.Handler
+	admrunning bool
 
 	cfg config.Server
 
@@ -63,19 +79,40 @@ const (
 
 	// CharsetUTF8 represents a UTF-8 charset for HTTP response "charset=UTF-8"
 	CharsetUTF8 = "charset=UTF-8"
+
+	// ApplicationJSON represents a HTTP content type "application/json"
+	ApplicationJSON = "application/json"
+
+	// defaultLivezPath represents the default server path for liveness check.
+	defaultLivezPath = "/livez"
+
+	// defaultReadyzPath represents the default server path for readiness check.
+	defaultReadyzPath = "/readyz"
 )
 
 var (
 	// ErrContextClosed represents a error that the context is closed
 	ErrContextClosed = errors.New("context Closed")
+
+	// ErrTLSEnabledChanged represents a error that TLS is enabled or disabled on reload, which requires restart.
+	ErrTLSEnabledChanged = errors.New("TLS cannot be enabled or disabled without restart")
+
+	// ErrTLSNotLoaded represents a error that the TLS configuration of client sidecar server is not loaded yet.
+	ErrTLSNotLoaded = errors.New("TLS configuration is not loaded")
 )
 
 // NewServer returns a Server interface, which includes client sidecar server and health check server structs.
 // The client sidecar server is a http.Server instance, which the port number is read from "config.Server.Port"
 // , and set the handler as this function argument "handler".
+// It also serves the same handler on the Unix domain socket "config.Server.Socket.Path" if it is set, and the TCP port is disabled when the port number is 0.
 //
 // The health check server is a http.Server instance, which the port number is read from "config.Server.HealthzPort"
 // , and the handler is as follow - Handle HTTP GET request and always return HTTP Status OK (200) response.
+// The health check server also handles the liveness and readiness check by the health checker on "config.Server.LivezPath" and "config.Server.ReadyzPath",
+// and exposes the Prometheus metrics on "config.Server.MetricsPath" if it is set.
+//
+// The admin server is a http.Server instance listening on the loopback address, which the port number is read from "config.Server.AdminPort"
+// , and the handler is set by WithAdminHandler. It is disabled when the port number is 0.
 func NewServer(opts ...Option) Server {
 	var err error
 
@@ -87,17 +124,26 @@ func NewServer(opts ...Option) Server {
 	s.srv = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.cfg.Port),
 		Handler: s.srvHandler,
+		// attach the peer credentials of the unix domain socket connections to the requests
+		ConnContext: connContext,
 	}
 	s.srv.SetKeepAlivesEnabled(true)
 
 	if s.healthzSrvEnable() {
 		s.hcsrv = &http.Server{
 			Addr:    fmt.Sprintf(":%d", s.cfg.HealthzPort),
-			Handler: createHealthCheckServiceMux(s.cfg.HealthzPath),
+			Handler: createHealthCheckServiceMux(s.cfg, s.hc),
 		}
 		s.hcsrv.SetKeepAlivesEnabled(true)
 	}
 
+	if s.adminSrvEnable() {
+		s.admsrv = &http.Server{
+			Addr:    fmt.Sprintf("127.0.0.1:%d", s.cfg.AdminPort),
+			Handler: s.admHandler,
+		}
+	}
+
 	s.sddur, err = time.ParseDuration(s.cfg.ShutdownDuration)
 	if err != nil {
 		glg.Warn(err)
@@ -119,6 +165,7 @@ func (s *server) ListenAndServe(ctx context.Context) chan []error {
 		echan = make(chan []error, 1)
 		sech  = make(chan error, 1)
 		hech  chan error
+		aech  chan error
 
 		wg = new(sync.WaitGroup)
 	)
@@ -159,6 +206,25 @@ func (s *server) ListenAndServe(ctx context.Context) chan []error {
 		}()
 	}
 
+	if s.adminSrvEnable() {
+		wg.Add(1)
+		aech = make(chan error, 1)
+		go func() {
+			s.mu.Lock()
+			s.admrunning = true
+			s.mu.Unlock()
+			wg.Done()
+
+			glg.Info("client sidecar admin server starting")
+			aech <- s.admsrv.ListenAndServe()
+			close(aech)
+
+			s.mu.Lock()
+			s.admrunning = false
+			s.mu.Unlock()
+		}()
+	}
+
 	go func() {
 		// wait for all server running
 		wg.Wait()
@@ -179,6 +245,10 @@ func (s *server) ListenAndServe(ctx context.Context) chan []error {
 					glg.Info("client sidecar health check server will shutdown")
 					errs = appendErr(errs, s.hcShutdown(context.Background()))
 				}
+				if s.admrunning {
+					glg.Info("client sidecar admin server will shutdown")
+					errs = appendErr(errs, s.adminShutdown(context.Background()))
+				}
 				if s.srvRunning {
 					glg.Info("client sidecar api server will shutdown")
 					errs = appendErr(errs, s.apiShutdown(context.Background()))
@@ -198,6 +268,10 @@ func (s *server) ListenAndServe(ctx context.Context) chan []error {
 					glg.Info("client sidecar health check server will shutdown")
 					errs = appendErr(errs, s.hcShutdown(ctx))
 				}
+				if s.admrunning {
+					glg.Info("client sidecar admin server will shutdown")
+					errs = appendErr(errs, s.adminShutdown(ctx))
+				}
 				s.mu.RUnlock()
 				echan <- errs
 				return
@@ -208,6 +282,28 @@ func (s *server) ListenAndServe(ctx context.Context) chan []error {
 				}
 
 				s.mu.RLock()
+				if s.admrunning {
+					glg.Info("client sidecar admin server will shutdown")
+					errs = appendErr(errs, s.adminShutdown(ctx))
+				}
+				if s.srvRunning {
+					glg.Info("client sidecar api server will shutdown")
+					errs = appendErr(errs, s.apiShutdown(ctx))
+				}
+				s.mu.RUnlock()
+				echan <- errs
+				return
+
+			case err := <-aech: // when admin server returns, close running health check server and client sidecar server and return any error
+				if err != nil {
+					errs = append(errs, err)
+				}
+
+				s.mu.RLock()
+				if s.hcrunning {
+					glg.Info("client sidecar health check server will shutdown")
+					errs = appendErr(errs, s.hcShutdown(ctx))
+				}
 				if s.srvRunning {
 					glg.Info("client sidecar api server will shutdown")
 					errs = appendErr(errs, s.apiShutdown(ctx))
@@ -228,6 +324,13 @@ func (s *server) hcShutdown(ctx context.Context) error {
 	return s.hcsrv.Shutdown(hctx)
 }
 
+// adminShutdown returns any error when shutdown the admin server.
+func (s *server) adminShutdown(ctx context.Context) error {
+	actx, acancel := context.WithTimeout(ctx, s.sddur)
+	defer acancel()
+	return s.admsrv.Shutdown(actx)
+}
+
 // apiShutdown returns any error when shutdown the client sidecar server.
 // Before shutdown the client sidecar server, it will sleep config.ProbeWaitTime to prevent any issue from K8s
 func (s *server) apiShutdown(ctx context.Context) error {
@@ -238,10 +341,26 @@ func (s *server) apiShutdown(ctx context.Context) error {
 }
 
 // createHealthCheckServiceMux return a *http.ServeMux object
-// The function will register the health check server handler for given pattern, and return
-func createHealthCheckServiceMux(pattern string) *http.ServeMux {
+// The function will register the health check server handler for cfg.HealthzPath, the liveness and readiness handlers if hc is not nil,
+// and the metrics handler for cfg.MetricsPath if it is not empty, and return
+func createHealthCheckServiceMux(cfg config.Server, hc HealthChecker) *http.ServeMux {
 	mux := http.NewServeMux()
-	mux.HandleFunc(pattern, handleHealthCheckRequest)
+	mux.HandleFunc(cfg.HealthzPath, handleHealthCheckRequest)
+	if hc != nil {
+		livez := cfg.LivezPath
+		if livez == "" {
+			livez = defaultLivezPath
+		}
+		readyz := cfg.ReadyzPath
+		if readyz == "" {
+			readyz = defaultReadyzPath
+		}
+		mux.HandleFunc(livez, createHealthStatusHandler(hc.Liveness))
+		mux.HandleFunc(readyz, createHealthStatusHandler(hc.Readiness))
+	}
+	if cfg.MetricsPath != "" {
+		mux.Handle(cfg.MetricsPath, metrics.Handler())
+	}
 	return mux
 }
 
@@ -257,15 +376,76 @@ func handleHealthCheckRequest(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// listenAndServeAPI return any error occurred when start a HTTPS server, including any error when loading TLS certificate
+// createHealthStatusHandler returns a handler function for the liveness or readiness check request.
+// The handler responses the result of the checks in JSON format, with HTTP Status OK (200) if all the checks passed, or HTTP Status Service Unavailable (503) otherwise.
+func createHealthStatusHandler(check func() HealthStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		hs := check()
+		code := http.StatusOK
+		if hs.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set(ContentType, fmt.Sprintf("%s;%s", ApplicationJSON, CharsetUTF8))
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(hs); err != nil {
+			glg.Error(err)
+		}
+	}
+}
+
+// listenAndServeAPI return any error occurred when start client sidecar server on the TCP port and the Unix domain socket.
+// When either of them fails, the other one is also closed.
 func (s *server) listenAndServeAPI() error {
+	ech := make(chan error, 2)
+	n := 0
+	if s.socketEnable() {
+		l, err := listenUnix(s.cfg.Socket)
+		if err != nil {
+			return err
+		}
+		n++
+		go func() {
+			glg.Infof("client sidecar api server listening on unix domain socket %s", config.GetActualValue(s.cfg.Socket.Path))
+			ech <- s.srv.Serve(l)
+		}()
+	}
+	if s.cfg.Port > 0 || !s.socketEnable() {
+		n++
+		go func() {
+			ech <- s.listenAndServeTCP()
+		}()
+	}
+
+	err := <-ech
+	if err != http.ErrServerClosed {
+		s.srv.Close()
+	}
+	for i := 1; i < n; i++ {
+		<-ech
+	}
+	return err
+}
+
+// listenAndServeTCP return any error occurred when start a HTTPS server, including any error when loading TLS certificate
+func (s *server) listenAndServeTCP() error {
 	if !s.cfg.TLS.Enabled {
 		return s.srv.ListenAndServe()
 	}
 
-	cfg, err := NewTLSConfig(s.cfg.TLS)
-	if err == nil && cfg != nil {
-		s.srv.TLSConfig = cfg
+	r, err := newTLSReloader(s.cfg.TLS)
+	if err == nil && r != nil {
+		s.tlsConfig.Store(r)
+		s.srv.TLSConfig = &tls.Config{
+			GetConfigForClient: s.getConfigForClient,
+			GetCertificate:     s.getCertificate,
+			NextProtos:         tlsNextProtos(),
+		}
 	}
 	if err != nil {
 		glg.Error(err)
@@ -273,6 +453,92 @@ func (s *server) listenAndServeAPI() error {
 	return s.srv.ListenAndServeTLS("", "")
 }
 
+// ReloadTLS replaces the TLS configuration of client sidecar server without dropping the connections.
+// The new certificate and CA are used for the new TLS handshakes. It returns error without any change if the TLS configuration cannot be loaded.
+// The changes of the certificate, the key and the CA files are also reloaded automatically without ReloadTLS.
+func (s *server) ReloadTLS(cfg config.TLS) error {
+	apply, err := s.PrepareReloadTLS(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareReloadTLS loads the TLS configuration of cfg and returns the function to replace the TLS configuration of client sidecar server.
+// It returns error without any change if the TLS configuration cannot be loaded.
+func (s *server) PrepareReloadTLS(cfg config.TLS) (func(), error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cfg.Enabled != s.cfg.TLS.Enabled {
+		return nil, ErrTLSEnabledChanged
+	}
+	if !cfg.Enabled {
+		return func() {}, nil
+	}
+
+	r, err := newTLSReloader(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.tlsConfig.Store(r)
+		s.cfg.TLS = cfg
+	}, nil
+}
+
+// TLSCheck returns a HealthCheck which fails when the current TLS configuration of client sidecar server is not loaded,
+// or the changed certificate, key or CA files cannot be reloaded. The configuration replaced by ReloadTLS is checked.
+func (s *server) TLSCheck() HealthCheck {
+	return func() error {
+		s.mu.RLock()
+		enabled := s.cfg.TLS.Enabled
+		s.mu.RUnlock()
+		if !enabled {
+			return nil
+		}
+		r, _ := s.tlsConfig.Load().(*tlsReloader)
+		if r == nil {
+			return ErrTLSNotLoaded
+		}
+		return r.status()
+	}
+}
+
+// getConfigForClient returns the current TLS configuration for the TLS handshake.
+func (s *server) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	r, _ := s.tlsConfig.Load().(*tlsReloader)
+	if r == nil {
+		return nil, nil
+	}
+	return r.getConfigForClient(hello)
+}
+
+// getCertificate returns the current certificate for the TLS handshake.
+func (s *server) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	t, err := s.getConfigForClient(hello)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || len(t.Certificates) == 0 {
+		return nil, ErrTLSCertOrKeyNotFound
+	}
+	return &t.Certificates[0], nil
+}
+
+// socketEnable returns whether client sidecar server listens on the Unix domain socket or not.
+func (s *server) socketEnable() bool {
+	return config.GetActualValue(s.cfg.Socket.Path) != ""
+}
+
 func (s *server) healthzSrvEnable() bool {
 	return s.cfg.HealthzPort > 0
 }
+
+// adminSrvEnable returns whether the admin server is enabled or not.
+func (s *server) adminSrvEnable() bool {
+	return s.cfg.AdminPort > 0 && s.admHandler != nil
+}
@@ -19,9 +19,12 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -513,7 +516,8 @@ func Test_server_ListenAndServe(t *testing.T) {
 
 func Test_server_createHealthCheckServiceMux(t *testing.T) {
 	type args struct {
-		pattern string
+		cfg config.Server
+		hc  HealthChecker
 	}
 	type test struct {
 		name       string
@@ -529,7 +533,9 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 			return test{
 				name: "Test create server mux",
 				args: args{
-					pattern: ":8080",
+					cfg: config.Server{
+						HealthzPath: ":8080",
+					},
 				},
 				checkFunc: func(got *http.ServeMux) error {
 					if got == nil {
@@ -539,6 +545,94 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 				},
 			}
 		}(),
+		func() test {
+			return test{
+				name: "Test create server mux with metrics",
+				args: args{
+					cfg: config.Server{
+						HealthzPath: "/healthz",
+						MetricsPath: "/metrics",
+					},
+				},
+				checkFunc: func(got *http.ServeMux) error {
+					rw := httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+					if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "go_goroutines") {
+						return fmt.Errorf("metrics is not served, code: %d, body: %s", rw.Code, rw.Body.String())
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			return test{
+				name: "Test create server mux without metrics",
+				args: args{
+					cfg: config.Server{
+						HealthzPath: "/healthz",
+					},
+				},
+				checkFunc: func(got *http.ServeMux) error {
+					rw := httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+					if rw.Code != http.StatusNotFound {
+						return fmt.Errorf("metrics should not be served, code: %d", rw.Code)
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			hc := NewHealthChecker()
+			hc.RegisterReadiness("dummy", func() error {
+				return fmt.Errorf("dummy error")
+			})
+			return test{
+				name: "Test create server mux with default liveness and readiness path",
+				args: args{
+					cfg: config.Server{
+						HealthzPath: "/healthz",
+					},
+					hc: hc,
+				},
+				checkFunc: func(got *http.ServeMux) error {
+					rw := httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/livez", nil))
+					if rw.Code != http.StatusOK {
+						return fmt.Errorf("livez code: %d, want: %d", rw.Code, http.StatusOK)
+					}
+					rw = httptest.NewRecorder()
+					got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+					if rw.Code != http.StatusServiceUnavailable {
+						return fmt.Errorf("readyz code: %d, want: %d", rw.Code, http.StatusServiceUnavailable)
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			return test{
+				name: "Test create server mux with custom liveness and readiness path",
+				args: args{
+					cfg: config.Server{
+						HealthzPath: "/healthz",
+						LivezPath:   "/custom/livez",
+						ReadyzPath:  "/custom/readyz",
+					},
+					hc: NewHealthChecker(),
+				},
+				checkFunc: func(got *http.ServeMux) error {
+					for _, path := range []string{"/custom/livez", "/custom/readyz"} {
+						rw := httptest.NewRecorder()
+						got.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, path, nil))
+						if rw.Code != http.StatusOK {
+							return fmt.Errorf("%s code: %d, want: %d", path, rw.Code, http.StatusOK)
+						}
+					}
+					return nil
+				},
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -559,7 +653,7 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 				}
 			}
 
-			got := createHealthCheckServiceMux(tt.args.pattern)
+			got := createHealthCheckServiceMux(tt.args.cfg, tt.args.hc)
 			if err := tt.checkFunc(got); err != nil {
 				t.Errorf("server.listenAndServeAPI() Error = %v", err)
 			}
@@ -567,6 +661,68 @@ func Test_server_createHealthCheckServiceMux(t *testing.T) {
 	}
 }
 
+func Test_createHealthStatusHandler(t *testing.T) {
+	type test struct {
+		name       string
+		method     string
+		status     HealthStatus
+		wantCode   int
+		wantBody   string
+		wantHeader string
+	}
+	tests := []test{
+		{
+			name:   "Test handle health status request with all checks passed",
+			method: http.MethodGet,
+			status: HealthStatus{
+				Status: StatusOK,
+				Checks: []CheckResult{
+					{Name: "ntoken", Status: StatusOK},
+				},
+			},
+			wantCode:   http.StatusOK,
+			wantBody:   `{"status":"ok","checks":[{"name":"ntoken","status":"ok"}]}` + "\n",
+			wantHeader: "application/json;charset=UTF-8",
+		},
+		{
+			name:   "Test handle health status request with failed check",
+			method: http.MethodGet,
+			status: HealthStatus{
+				Status: StatusFail,
+				Checks: []CheckResult{
+					{Name: "ntoken", Status: StatusFail, Error: "dummy error"},
+				},
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantBody:   `{"status":"fail","checks":[{"name":"ntoken","status":"fail","error":"dummy error"}]}` + "\n",
+			wantHeader: "application/json;charset=UTF-8",
+		},
+		{
+			name:     "Test handle health status request with HTTP POST",
+			method:   http.MethodPost,
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			createHealthStatusHandler(func() HealthStatus {
+				return tt.status
+			})(rw, httptest.NewRequest(tt.method, "/readyz", nil))
+
+			if rw.Code != tt.wantCode {
+				t.Errorf("code = %d, want %d", rw.Code, tt.wantCode)
+			}
+			if got := rw.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %s, want %s", got, tt.wantBody)
+			}
+			if got := rw.Header().Get(ContentType); got != tt.wantHeader {
+				t.Errorf("header = %s, want %s", got, tt.wantHeader)
+			}
+		})
+	}
+}
+
 func Test_server_handleHealthCheckRequest(t *testing.T) {
 	type args struct {
 		rw http.ResponseWriter
@@ -702,6 +858,125 @@ func Test_server_listenAndServeAPI(t *testing.T) {
 				want: http.ErrServerClosed,
 			}
 		}(),
+		func() test {
+			return test{
+				name: "Test server negotiates HTTP/2 by ALPN",
+				fields: fields{
+					srv: &http.Server{
+						Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							w.Write([]byte("ok"))
+						}),
+						Addr: fmt.Sprintf(":%d", 9998),
+					},
+					cfg: config.Server{
+						Port: 9998,
+						TLS: config.TLS{
+							Enabled: true,
+							Cert:    "./assets/dummyServer.crt",
+							Key:     "./assets/dummyServer.key",
+						},
+					},
+				},
+				checkFunc: func(s *server, want error) error {
+					ech := make(chan error, 1)
+					go func() {
+						ech <- s.listenAndServeAPI()
+					}()
+					defer func() {
+						s.srv.Shutdown(context.Background())
+						<-ech
+					}()
+
+					var conn *tls.Conn
+					var err error
+					for i := 0; i < 50; i++ {
+						if conn, err = tls.Dial("tcp", "127.0.0.1:9998", &tls.Config{
+							InsecureSkipVerify: true,
+							NextProtos:         []string{"h2", "http/1.1"},
+						}); err == nil {
+							break
+						}
+						time.Sleep(time.Millisecond * 10)
+					}
+					if err != nil {
+						return err
+					}
+					defer conn.Close()
+					if got := conn.ConnectionState().NegotiatedProtocol; got != "h2" {
+						return fmt.Errorf("negotiated protocol: %q, want h2", got)
+					}
+					return nil
+				},
+			}
+		}(),
+		func() test {
+			dir, _ := ioutil.TempDir("", "socket")
+			path := filepath.Join(dir, "sidecar.sock")
+
+			return test{
+				name: "Test server startup on unix domain socket only",
+				fields: fields{
+					srv: &http.Server{
+						Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+							w.Write([]byte("ok"))
+						}),
+					},
+					cfg: config.Server{
+						Socket: config.Socket{
+							Path: path,
+							Mode: "0660",
+						},
+					},
+				},
+				checkFunc: func(s *server, want error) error {
+					ech := make(chan error, 1)
+					go func() {
+						ech <- s.listenAndServeAPI()
+					}()
+
+					var fi os.FileInfo
+					for i := 0; i < 50; i++ {
+						if fi, _ = os.Stat(path); fi != nil {
+							break
+						}
+						time.Sleep(time.Millisecond * 10)
+					}
+					if fi == nil || fi.Mode().Perm() != 0660 {
+						return fmt.Errorf("socket file info: %v", fi)
+					}
+
+					c := &http.Client{
+						Transport: &http.Transport{
+							DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+								return net.Dial("unix", path)
+							},
+						},
+					}
+					res, err := c.Get("http://unix/")
+					if err != nil {
+						return err
+					}
+					body, _ := ioutil.ReadAll(res.Body)
+					res.Body.Close()
+					if string(body) != "ok" {
+						return fmt.Errorf("response body: %s", body)
+					}
+
+					s.srv.Shutdown(context.Background())
+					if got := <-ech; got != want {
+						return fmt.Errorf("got:\t%v\nwant:\t%v", got, want)
+					}
+					if _, err := os.Stat(path); !os.IsNotExist(err) {
+						return fmt.Errorf("socket file is not removed, error: %v", err)
+					}
+					return nil
+				},
+				afterFunc: func() error {
+					return os.RemoveAll(dir)
+				},
+				want: http.ErrServerClosed,
+			}
+		}(),
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -732,3 +1007,102 @@ func Test_server_listenAndServeAPI(t *testing.T) {
 		})
 	}
 }
+
+func Test_server_ReloadTLS(t *testing.T) {
+	type test struct {
+		name      string
+		current   config.TLS
+		cfg       config.TLS
+		checkFunc func(*server) error
+		wantErr   error
+	}
+	tests := []test{
+		{
+			name: "Test reload TLS config",
+			current: config.TLS{
+				Enabled: true,
+			},
+			cfg: config.TLS{
+				Enabled: true,
+				Cert:    "./assets/dummyServer.crt",
+				Key:     "./assets/dummyServer.key",
+			},
+			checkFunc: func(s *server) error {
+				got, err := s.getConfigForClient(nil)
+				if err != nil || got == nil || len(got.Certificates) != 1 {
+					return fmt.Errorf("TLS config not reloaded, got: %v, err: %v", got, err)
+				}
+				if crt, err := s.getCertificate(nil); err != nil || crt == nil {
+					return fmt.Errorf("TLS certificate not reloaded, got: %v, err: %v", crt, err)
+				}
+				if err := s.TLSCheck()(); err != nil {
+					return fmt.Errorf("TLSCheck() error = %v", err)
+				}
+				return nil
+			},
+		},
+		{
+			name: "Test reload TLS config failed",
+			current: config.TLS{
+				Enabled: true,
+			},
+			cfg: config.TLS{
+				Enabled: true,
+				Cert:    "./assets/invalid_dummyServer.crt",
+				Key:     "./assets/invalid_dummyServer.key",
+			},
+			checkFunc: func(s *server) error {
+				if got, _ := s.getConfigForClient(nil); got != nil {
+					return fmt.Errorf("TLS config should not be reloaded, got: %v", got)
+				}
+				if _, err := s.getCertificate(nil); err != ErrTLSCertOrKeyNotFound {
+					return fmt.Errorf("getCertificate() error = %v, want %v", err, ErrTLSCertOrKeyNotFound)
+				}
+				if err := s.TLSCheck()(); err != ErrTLSNotLoaded {
+					return fmt.Errorf("TLSCheck() error = %v, want %v", err, ErrTLSNotLoaded)
+				}
+				return nil
+			},
+			wantErr: fmt.Errorf("tls: failed to find any PEM data in certificate input"),
+		},
+		{
+			name: "Test reload TLS config enabled",
+			current: config.TLS{
+				Enabled: false,
+			},
+			cfg: config.TLS{
+				Enabled: true,
+			},
+			checkFunc: func(s *server) error {
+				return nil
+			},
+			wantErr: ErrTLSEnabledChanged,
+		},
+		{
+			name: "Test reload TLS config disabled",
+			checkFunc: func(s *server) error {
+				if err := s.TLSCheck()(); err != nil {
+					return fmt.Errorf("TLSCheck() error = %v", err)
+				}
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{
+				cfg: config.Server{
+					TLS: tt.current,
+				},
+			}
+			err := s.ReloadTLS(tt.cfg)
+			if (err == nil) != (tt.wantErr == nil) || (err != nil && err.Error() != tt.wantErr.Error()) {
+				t.Errorf("server.ReloadTLS() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err := tt.checkFunc(s); err != nil {
+				t.Errorf("server.ReloadTLS() %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+// cacheSnapshot represent the encrypted file to persist the token cache across restarts.
+type cacheSnapshot struct {
+	path     string
+	aead     cipher.AEAD
+	interval time.Duration
+
+	// changed notifies the cache is changed and the snapshot should be written.
+	changed chan struct{}
+}
+
+// snapshotData represent the content of the snapshot file.
+type snapshotData struct {
+	Version int             `json:"version"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+// snapshotEntry represent a cached role token in the snapshot file.
+type snapshotEntry struct {
+	Domain            string     `json:"domain"`
+	Role              string     `json:"role"`
+	ProxyForPrincipal string     `json:"proxyForPrincipal"`
+	MinExpiry         int64      `json:"minExpiry"`
+	MaxExpiry         int64      `json:"maxExpiry"`
+	Token             *RoleToken `json:"token"`
+}
+
+var (
+	// ErrInvalidSnapshot represent an error when the snapshot file cannot be decrypted or decoded.
+	ErrInvalidSnapshot = errors.New("invalid cache snapshot")
+)
+
+const (
+	// snapshotVersion represents the version of the snapshot file format.
+	snapshotVersion = 1
+
+	// snapshotKeyLabel represents the label to derive the snapshot encryption key from the private key.
+	snapshotKeyLabel = "athenz-client-sidecar role token cache snapshot"
+
+	// defaultSnapshotInterval represents the default minimum interval between the writes of the snapshot.
+	defaultSnapshotInterval = time.Second * 10
+)
+
+// newCacheSnapshot returns the snapshot configured by cfg and encrypted by the key derived from the private key,
+// or nil if it is disabled.
+func newCacheSnapshot(cfg config.CacheSnapshot, key crypto.Signer) (*cacheSnapshot, error) {
+	path := config.GetActualValue(cfg.Path)
+	if path == "" {
+		return nil, nil
+	}
+	if key == nil {
+		return nil, errors.Wrap(ErrInvalidSetting, "CacheSnapshot: private key is required")
+	}
+
+	interval := defaultSnapshotInterval
+	if cfg.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(cfg.Interval); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "CacheSnapshot.Interval: "+err.Error())
+		}
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "CacheSnapshot: cannot marshal private key")
+	}
+	k := sha256.Sum256(append([]byte(snapshotKeyLabel), der...))
+	block, err := aes.NewCipher(k[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cacheSnapshot{
+		path:     path,
+		aead:     aead,
+		interval: interval,
+		changed:  make(chan struct{}, 1),
+	}, nil
+}
+
+// notify requests to write the snapshot. It does not block.
+func (s *cacheSnapshot) notify() {
+	if s == nil {
+		return
+	}
+	select {
+	case s.changed <- struct{}{}:
+	default:
+	}
+}
+
+// run writes the snapshot by save on each change at most once per interval, and on ctx done.
+func (s *cacheSnapshot) run(ctx context.Context, save func() []snapshotEntry) {
+	write := func() {
+		if err := s.write(save()); err != nil {
+			glg.Errorf("cannot write cache snapshot, path: %s, error: %v", s.path, err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			write()
+			return
+		case <-s.changed:
+			write()
+		}
+
+		// wait for the interval to merge the following changes
+		select {
+		case <-ctx.Done():
+			write()
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+// write encrypts entries and replaces the snapshot file atomically.
+func (s *cacheSnapshot) write(entries []snapshotEntry) error {
+	b, err := json.Marshal(snapshotData{
+		Version: snapshotVersion,
+		Entries: entries,
+	})
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	b = s.aead.Seal(nonce, nonce, b, nil)
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// TempFile creates the file with 0600
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// read returns the entries decrypted from the snapshot file, or nil if the file does not exist.
+func (s *cacheSnapshot) read() ([]snapshotEntry, error) {
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	n := s.aead.NonceSize()
+	if len(b) < n {
+		return nil, ErrInvalidSnapshot
+	}
+	if b, err = s.aead.Open(nil, b[:n], b[n:], nil); err != nil {
+		return nil, errors.Wrap(ErrInvalidSnapshot, err.Error())
+	}
+
+	var data snapshotData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, errors.Wrap(ErrInvalidSnapshot, err.Error())
+	}
+	if data.Version != snapshotVersion {
+		return nil, errors.Wrapf(ErrInvalidSnapshot, "unsupported version %d", data.Version)
+	}
+	return data.Entries, nil
+}
@@ -0,0 +1,226 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+func Test_newCacheSnapshot(t *testing.T) {
+	key, err := loadPrivateKey("./assets/dummyServer.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type test struct {
+		name         string
+		cfg          config.CacheSnapshot
+		wantNil      bool
+		wantInterval time.Duration
+		wantErr      error
+	}
+	tests := []test{
+		{
+			name:    "newCacheSnapshot return nil when disabled",
+			cfg:     config.CacheSnapshot{},
+			wantNil: true,
+		},
+		{
+			name: "newCacheSnapshot return default interval",
+			cfg: config.CacheSnapshot{
+				Path: "/tmp/roletoken.snapshot",
+			},
+			wantInterval: defaultSnapshotInterval,
+		},
+		{
+			name: "newCacheSnapshot return snapshot",
+			cfg: config.CacheSnapshot{
+				Path:     "/tmp/roletoken.snapshot",
+				Interval: "1m",
+			},
+			wantInterval: time.Minute,
+		},
+		{
+			name: "newCacheSnapshot return error with invalid interval",
+			cfg: config.CacheSnapshot{
+				Path:     "/tmp/roletoken.snapshot",
+				Interval: "dummy",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "CacheSnapshot.Interval: time: invalid duration \"dummy\""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newCacheSnapshot(tt.cfg, key)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("newCacheSnapshot() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("newCacheSnapshot() error = %v", err)
+				return
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("newCacheSnapshot() = %+v, want nil", got)
+				}
+				return
+			}
+			if got.path != tt.cfg.Path || got.interval != tt.wantInterval || got.aead == nil {
+				t.Errorf("newCacheSnapshot() = %+v", got)
+			}
+		})
+	}
+
+	if _, err := newCacheSnapshot(config.CacheSnapshot{Path: "/tmp/roletoken.snapshot"}, nil); err == nil {
+		t.Errorf("newCacheSnapshot() without private key error = nil")
+	}
+}
+
+func Test_cacheSnapshot_writeAndRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := loadPrivateKey("./assets/dummyServer.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.CacheSnapshot{
+		Path: filepath.Join(dir, "roletoken.snapshot"),
+	}
+	s, err := newCacheSnapshot(cfg, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot file does not exist
+	got, err := s.read()
+	if err != nil || got != nil {
+		t.Fatalf("read() without snapshot file = %v, %v", got, err)
+	}
+
+	entries := []snapshotEntry{
+		{
+			Domain:            "domain",
+			Role:              "role",
+			ProxyForPrincipal: "principal",
+			MinExpiry:         1,
+			MaxExpiry:         2,
+			Token: &RoleToken{
+				Token:      "dummyToken",
+				ExpiryTime: 1,
+			},
+		},
+	}
+	if err := s.write(entries); err != nil {
+		t.Fatalf("write() error = %v", err)
+	}
+
+	fi, err := os.Stat(cfg.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("snapshot file mode = %v, want 0600", fi.Mode().Perm())
+	}
+	b, _ := ioutil.ReadFile(cfg.Path)
+	if len(b) == 0 || bytes.Contains(b, []byte("dummyToken")) {
+		t.Errorf("snapshot file is not encrypted")
+	}
+
+	got, err = s.read()
+	if err != nil {
+		t.Fatalf("read() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, entries) {
+		t.Errorf("read() = %+v, want %+v", got, entries)
+	}
+
+	// the snapshot cannot be decrypted by another private key
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	o, err := newCacheSnapshot(cfg, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.read(); errors.Cause(err) != ErrInvalidSnapshot {
+		t.Errorf("read() with another private key error = %v, want %v", err, ErrInvalidSnapshot)
+	}
+}
+
+func Test_cacheSnapshot_run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := loadPrivateKey("./assets/dummyServer.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := newCacheSnapshot(config.CacheSnapshot{
+		Path:     filepath.Join(dir, "roletoken.snapshot"),
+		Interval: "1h",
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []snapshotEntry{{Domain: "first"}}
+	save := func() []snapshotEntry {
+		return entries
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx, save)
+	}()
+
+	// written on change
+	s.notify()
+	time.Sleep(time.Millisecond * 100)
+	if got, err := s.read(); err != nil || !reflect.DeepEqual(got, entries) {
+		t.Errorf("snapshot on change = %+v, %v, want %+v", got, err, entries)
+	}
+
+	// written on shutdown even within the interval
+	entries = []snapshotEntry{{Domain: "last"}}
+	s.notify()
+	cancel()
+	<-done
+	if got, err := s.read(); err != nil || !reflect.DeepEqual(got, entries) {
+		t.Errorf("snapshot on shutdown = %+v, %v, want %+v", got, err, entries)
+	}
+}
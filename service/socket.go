@@ -0,0 +1,175 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+var (
+	// ErrSocketInUse represent an error when the Unix domain socket is used by another running process.
+	ErrSocketInUse = errors.New("unix domain socket is in use")
+)
+
+const (
+	// defaultSocketMode represents the default permission of the Unix domain socket file.
+	defaultSocketMode = os.FileMode(0600)
+
+	// socketDialTimeout represents the timeout to check whether the existing socket file is used.
+	socketDialTimeout = time.Second
+)
+
+// listenUnix returns the listener of the Unix domain socket configured by cfg.
+// The stale socket file left by the previous process is removed, and the new socket file is created with the configured owner, group and mode.
+// The socket file is removed when the listener is closed.
+func listenUnix(cfg config.Socket) (net.Listener, error) {
+	path := config.GetActualValue(cfg.Path)
+	mode := defaultSocketMode
+	if cfg.Mode != "" {
+		m, err := strconv.ParseUint(cfg.Mode, 8, 32)
+		if err != nil || m > 0777 {
+			return nil, errors.Wrapf(ErrInvalidSetting, "Socket.Mode: %q is not an octal permission", cfg.Mode)
+		}
+		mode = os.FileMode(m)
+	}
+	uid, err := lookupID(cfg.Owner, lookupUser)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, "Socket.Owner: "+err.Error())
+	}
+	gid, err := lookupID(cfg.Group, lookupGroup)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, "Socket.Group: "+err.Error())
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	// the socket is created at the temporary path and renamed after the permission is set, so that no client can connect before
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	os.Remove(tmp)
+	l, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, err
+	}
+	ul := l.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+
+	if err := setSocketPermission(tmp, mode, uid, gid); err != nil {
+		ul.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ul.Close()
+		os.Remove(tmp)
+		return nil, err
+	}
+	return &unixListener{
+		UnixListener: ul,
+		path:         path,
+	}, nil
+}
+
+// unixListener is a net.UnixListener removing the socket file on close.
+type unixListener struct {
+	*net.UnixListener
+	path string
+}
+
+// Close closes the listener and removes the socket file.
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	if rerr := os.Remove(l.path); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// removeStaleSocket removes the socket file at path if no process is listening on it.
+// It returns error if path is used by another running process or is not a socket file.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return errors.Errorf("%s exists and is not a unix domain socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, socketDialTimeout)
+	if err == nil {
+		conn.Close()
+		return errors.Wrap(ErrSocketInUse, path)
+	}
+	glg.Infof("remove stale unix domain socket, path: %s", path)
+	return os.Remove(path)
+}
+
+// setSocketPermission changes the mode, and the owner and group if they are not -1, of the socket file.
+func setSocketPermission(path string, mode os.FileMode, uid, gid int) error {
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, mode)
+}
+
+// lookupID returns the numeric id of name, or -1 if name is empty. The name which is not a number is resolved by lookup.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, nil
+	}
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(id)
+}
+
+// lookupUser returns the user ID of the user name.
+func lookupUser(name string) (string, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return u.Uid, nil
+}
+
+// lookupGroup returns the group ID of the group name.
+func lookupGroup(name string) (string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return "", err
+	}
+	return g.Gid, nil
+}
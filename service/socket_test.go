@@ -0,0 +1,148 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+func Test_listenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sidecar.sock")
+
+	// the socket file is created with the default mode and the configured owner
+	l, err := listenUnix(config.Socket{
+		Path:  path,
+		Owner: strconv.Itoa(os.Getuid()),
+		Group: strconv.Itoa(os.Getgid()),
+	})
+	if err != nil {
+		t.Fatalf("listenUnix() error = %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 || fi.Mode().Perm() != defaultSocketMode {
+		t.Errorf("socket file info = %v, error = %v", fi, err)
+	}
+
+	// the socket in use is not removed
+	if _, err := listenUnix(config.Socket{Path: path}); errors.Cause(err) != ErrSocketInUse {
+		t.Errorf("listenUnix() on socket in use error = %v, want %v", err, ErrSocketInUse)
+	}
+
+	if err := l.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file is not removed on close, error: %v", err)
+	}
+
+	if _, err := listenUnix(config.Socket{Path: path, Mode: "0999"}); errors.Cause(err) != ErrInvalidSetting {
+		t.Errorf("listenUnix() with invalid mode error = %v, want %v", err, ErrInvalidSetting)
+	}
+}
+
+func Test_removeStaleSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the socket file left without the listener
+	stale := filepath.Join(dir, "stale.sock")
+	l, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if err := removeStaleSocket(stale); err != nil {
+		t.Errorf("removeStaleSocket() error = %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("stale socket is not removed, error: %v", err)
+	}
+
+	// the regular file is not removed
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleSocket(file); err == nil {
+		t.Error("removeStaleSocket() of regular file error = nil")
+	}
+
+	if err := removeStaleSocket(filepath.Join(dir, "not_found.sock")); err != nil {
+		t.Errorf("removeStaleSocket() of not found file error = %v", err)
+	}
+}
+
+func Test_lookupID(t *testing.T) {
+	lookup := func(name string) (string, error) {
+		if name == "sidecar" {
+			return "1000", nil
+		}
+		return "", errors.New("unknown name")
+	}
+	type test struct {
+		name    string
+		arg     string
+		want    int
+		wantErr bool
+	}
+	tests := []test{
+		{
+			name: "lookupID return -1 for empty",
+			want: -1,
+		},
+		{
+			name: "lookupID return numeric id",
+			arg:  "1001",
+			want: 1001,
+		},
+		{
+			name: "lookupID return id of name",
+			arg:  "sidecar",
+			want: 1000,
+		},
+		{
+			name:    "lookupID return error for unknown name",
+			arg:     "unknown",
+			want:    -1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupID(tt.arg, lookup)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("lookupID() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
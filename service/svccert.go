@@ -0,0 +1,366 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	ntokend "github.com/kpango/ntokend"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+	"golang.org/x/sync/singleflight"
+)
+
+// SvcCertService represent a interface to automatically refresh the service certificate, and a service certificate provider function pointer.
+type SvcCertService interface {
+	StartSvcCertUpdater(context.Context) <-chan error
+	GetSvcCertProvider() SvcCertProvider
+}
+
+// svcCertService represent the implementation of athenz SvcCertService
+type svcCertService struct {
+	cfg                   config.ServiceCert
+	token                 ntokend.TokenProvider
+	athenzURL             string
+	athenzPrincipleHeader string
+	domain                string
+	service               string
+	keyID                 string
+	csr                   string
+	group                 singleflight.Group
+	cert                  atomic.Value
+	expiry                time.Duration
+	httpClient            *http.Client
+
+	refreshBefore    time.Duration
+	errRetryMaxCount int
+	errRetryInterval time.Duration
+}
+
+// SvcCert represent the service certificate and its CA certificate chain.
+type SvcCert struct {
+	Cert       string `json:"cert"`
+	Chain      string `json:"chain"`
+	ExpiryTime int64  `json:"expiryTime"`
+}
+
+// SvcCertProvider represent a function pointer to get the service certificate.
+type SvcCertProvider func(ctx context.Context) (*SvcCert, error)
+
+// instanceRefreshRequest represent the request body of the ZTS instance refresh API.
+type instanceRefreshRequest struct {
+	CSR        string `json:"csr"`
+	ExpiryTime int32  `json:"expiryTime,omitempty"`
+	KeyID      string `json:"keyId,omitempty"`
+}
+
+// identity represent the response body of the ZTS instance refresh API.
+type identity struct {
+	Name         string `json:"name"`
+	Certificate  string `json:"certificate"`
+	CACertBundle string `json:"caCertBundle"`
+}
+
+var (
+	// ErrSvcCertRequestFailed represent an error when failed to fetch the service certificate from SvcCertProvider.
+	ErrSvcCertRequestFailed = errors.New("Failed to fetch service certificate")
+)
+
+const (
+	// defaultCertExpiry represents the default service certificate expiration. (0 implies unspecified.)
+	defaultCertExpiry = time.Duration(0)
+
+	// defaultCertRefreshBefore represents the default duration to refresh the certificate before it expires.
+	defaultCertRefreshBefore = time.Hour * 24
+
+	// svcCertCacheKey is the singleflight key of the service certificate.
+	svcCertCacheKey = "svccert"
+)
+
+// NewSvcCertService returns a SvcCertService to update and get the service certificate from athenz.
+// The certificate signing request is signed by the private key of the service identity defined in the token configuration.
+func NewSvcCertService(cfg config.ServiceCert, tcfg config.Token, token ntokend.TokenProvider) (SvcCertService, error) {
+	var (
+		err              error
+		exp              = defaultCertExpiry
+		refreshBefore    = defaultCertRefreshBefore
+		errRetryInterval = defaultErrRetryInterval
+	)
+
+	if cfg.Expiration != "" {
+		if exp, err = time.ParseDuration(cfg.Expiration); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "Expiration: "+err.Error())
+		}
+	}
+	if cfg.RefreshBefore != "" {
+		if refreshBefore, err = time.ParseDuration(cfg.RefreshBefore); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "RefreshBefore: "+err.Error())
+		}
+	}
+	if cfg.ErrRetryInterval != "" {
+		if errRetryInterval, err = time.ParseDuration(cfg.ErrRetryInterval); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryInterval: "+err.Error())
+		}
+	}
+
+	errRetryMaxCount := defaultErrRetryMaxCount
+	if cfg.ErrRetryMaxCount > 0 {
+		errRetryMaxCount = cfg.ErrRetryMaxCount
+	} else if cfg.ErrRetryMaxCount != 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "ErrRetryMaxCount < 0")
+	}
+
+	domain := config.GetActualValue(tcfg.AthenzDomain)
+	service := config.GetActualValue(tcfg.ServiceName)
+	if domain == "" || service == "" {
+		return nil, errors.Wrap(ErrInvalidSetting, "athenz domain and service name are required")
+	}
+
+	key, err := loadPrivateKey(config.GetActualValue(tcfg.PrivateKeyPath))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidSetting, "PrivateKeyPath: "+err.Error())
+	}
+
+	csr, err := createCSR(key, svcCertCSRTemplate(domain, service, cfg.DNSSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	return &svcCertService{
+		cfg:                   cfg,
+		token:                 token,
+		athenzURL:             cfg.AthenzURL,
+		athenzPrincipleHeader: cfg.PrincipalAuthHeaderName,
+		domain:                domain,
+		service:               service,
+		keyID:                 tcfg.KeyVersion,
+		csr:                   csr,
+		expiry:                exp,
+		httpClient:            newAthenzHTTPClient(cfg.AthenzRootCA),
+		refreshBefore:         refreshBefore,
+		errRetryMaxCount:      errRetryMaxCount,
+		errRetryInterval:      errRetryInterval,
+	}, nil
+}
+
+// StartSvcCertUpdater returns SvcCertService.
+// This function will fetch the service certificate immediately, and refresh it before it expires.
+func (s *svcCertService) StartSvcCertUpdater(ctx context.Context) <-chan error {
+	glg.Info("Starting service certificate updater")
+
+	ech := make(chan error, 100)
+	go func() {
+		defer close(ech)
+
+		timer := time.NewTimer(0)
+		for {
+			select {
+			case <-ctx.Done():
+				glg.Info("Stopping service certificate updater")
+				timer.Stop()
+				ech <- ctx.Err()
+				return
+			case <-timer.C:
+				for err := range s.updateSvcCertWithRetry(ctx) {
+					ech <- errors.Wrap(err, "error update service certificate")
+				}
+				timer.Reset(s.nextRefresh())
+			}
+		}
+	}()
+
+	return ech
+}
+
+// GetSvcCertProvider returns a function pointer to get the service certificate.
+func (s *svcCertService) GetSvcCertProvider() SvcCertProvider {
+	return s.getSvcCert
+}
+
+// getSvcCert returns SvcCert struct or error.
+// This function will return the cached service certificate, or fetch the service certificate from athenz when it is not cached or already expired.
+func (s *svcCertService) getSvcCert(ctx context.Context) (*SvcCert, error) {
+	if c, ok := s.cert.Load().(*SvcCert); ok && c.ExpiryTime > fastime.Now().Unix() {
+		return c, nil
+	}
+	return s.updateSvcCert(ctx)
+}
+
+// nextRefresh returns the duration to wait before refreshing the current service certificate.
+func (s *svcCertService) nextRefresh() time.Duration {
+	c, ok := s.cert.Load().(*SvcCert)
+	if !ok {
+		return s.errRetryInterval
+	}
+	return nextCertRefresh(fastime.Now(), time.Unix(c.ExpiryTime, 0), s.refreshBefore)
+}
+
+func (s *svcCertService) updateSvcCertWithRetry(ctx context.Context) <-chan error {
+	glg.Debugf("updateSvcCertWithRetry started, domain: %s, service: %s", s.domain, s.service)
+
+	echan := make(chan error, s.errRetryMaxCount+1)
+	go func() {
+		defer close(echan)
+
+		for i := 0; i <= s.errRetryMaxCount; i++ {
+			_, err := s.updateSvcCert(ctx)
+			if err == nil {
+				glg.Debug("update success")
+				return
+			}
+			echan <- err
+			if i == s.errRetryMaxCount {
+				return
+			}
+			t := time.NewTimer(s.errRetryInterval)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+	}()
+
+	return echan
+}
+
+// updateSvcCert returns SvcCert struct or error.
+// This function ask athenz to issue the service certificate and cache it, or return any error when issuing the certificate.
+func (s *svcCertService) updateSvcCert(ctx context.Context) (*SvcCert, error) {
+	c, err, _ := s.group.Do(svcCertCacheKey, func() (interface{}, error) {
+		c, e := s.fetchSvcCert(ctx)
+		if e != nil {
+			return nil, e
+		}
+
+		s.cert.Store(c)
+		glg.Debugf("service certificate is cached, domain: %s, service: %s, expiry time: %v", s.domain, s.service, c.ExpiryTime)
+		return c, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return c.(*SvcCert), nil
+}
+
+// fetchSvcCert fetch the service certificate from Athenz server, and return the decoded certificate and any error if occurred.
+func (s *svcCertService) fetchSvcCert(ctx context.Context) (*SvcCert, error) {
+	glg.Debugf("get service certificate, domain: %s, service: %s", s.domain, s.service)
+
+	// get the n-token
+	tok, err := s.token()
+	if err != nil {
+		return nil, err
+	}
+
+	// prepare request object
+	req, err := s.createPostInstanceRefreshRequest(tok)
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, err
+	}
+	glg.Debugf("request url: %v", req.URL)
+
+	res, err := doZTSRequest(ctx, s.httpClient, nil, "svccert", req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer flushAndClose(res.Body)
+	if res.StatusCode != http.StatusOK {
+		buf := new(bytes.Buffer)
+		if _, err := buf.ReadFrom(res.Body); err != nil {
+			glg.Debugf("cannot read response body, err: %v", err)
+		}
+		glg.Debugf("error return from server, response:%+v, body: %v", res, buf.String())
+		return nil, ErrSvcCertRequestFailed
+	}
+
+	var data identity
+	if err = json.NewDecoder(res.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	notAfter, err := certNotAfter(data.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SvcCert{
+		Cert:       data.Certificate,
+		Chain:      data.CACertBundle,
+		ExpiryTime: notAfter.Unix(),
+	}, nil
+}
+
+func (s *svcCertService) createPostInstanceRefreshRequest(token string) (*http.Request, error) {
+	// the domain and the service are escaped not to request the other path of athenz server
+	u := fmt.Sprintf("https://%s/instance/%s/%s/refresh", strings.TrimPrefix(strings.TrimPrefix(s.athenzURL, "https://"), "http://"), url.PathEscape(s.domain), url.PathEscape(s.service))
+
+	body, err := json.Marshal(instanceRefreshRequest{
+		CSR:        s.csr,
+		ExpiryTime: int32(s.expiry / time.Minute),
+		KeyID:      s.keyID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		glg.Debugf("fail to create request object, error: %s", err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// set authenication token
+	req.Header.Set(s.athenzPrincipleHeader, token)
+
+	return req, nil
+}
+
+// svcCertCSRTemplate returns the CSR template of the service certificate of domain.service.
+// The SAN DNS name is "<service>.<domain with '.' replaced by '-'>.<dnsSuffix>", and the SAN URI is the SPIFFE ID of the service.
+func svcCertCSRTemplate(domain, service, dnsSuffix string) csrTemplate {
+	t := csrTemplate{
+		commonName: domain + "." + service,
+		uris: []*url.URL{
+			{
+				Scheme: "spiffe",
+				Host:   domain,
+				Path:   "/sa/" + service,
+			},
+		},
+	}
+	if dnsSuffix != "" {
+		t.dnsNames = []string{
+			fmt.Sprintf("%s.%s.%s", service, strings.Replace(domain, ".", "-", -1), dnsSuffix),
+		}
+	}
+	return t
+}
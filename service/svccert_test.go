@@ -0,0 +1,360 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+func TestNewSvcCertService(t *testing.T) {
+	tokenCfg := config.Token{
+		AthenzDomain:   "dummyDomain",
+		ServiceName:    "dummyService",
+		PrivateKeyPath: "./assets/dummyServer.key",
+		KeyVersion:     "v1",
+	}
+	type test struct {
+		name      string
+		cfg       config.ServiceCert
+		tokenCfg  config.Token
+		checkFunc func(got SvcCertService) error
+		wantErr   error
+	}
+	tests := []test{
+		{
+			name: "NewSvcCertService return correct",
+			cfg: config.ServiceCert{
+				AthenzURL:               "dummy",
+				PrincipalAuthHeaderName: "dummyAuthHeader",
+				DNSSuffix:               "athenz.cloud",
+				Expiration:              "720h",
+				RefreshBefore:           "1h",
+			},
+			tokenCfg: tokenCfg,
+			checkFunc: func(got SvcCertService) error {
+				s := got.(*svcCertService)
+				if s.athenzURL != "dummy" ||
+					s.athenzPrincipleHeader != "dummyAuthHeader" ||
+					s.domain != "dummyDomain" ||
+					s.service != "dummyService" ||
+					s.keyID != "v1" ||
+					s.csr == "" ||
+					s.expiry != time.Hour*720 ||
+					s.refreshBefore != time.Hour ||
+					s.errRetryMaxCount != defaultErrRetryMaxCount ||
+					s.errRetryInterval != defaultErrRetryInterval {
+					return fmt.Errorf("got: %+v", s)
+				}
+				return nil
+			},
+		},
+		{
+			name: "NewSvcCertService return error with RefreshBefore of invalid format",
+			cfg: config.ServiceCert{
+				RefreshBefore: "1x",
+			},
+			tokenCfg: tokenCfg,
+			wantErr:  errors.Wrap(ErrInvalidSetting, `RefreshBefore: time: unknown unit "x" in duration "1x"`),
+		},
+		{
+			name:     "NewSvcCertService return error without service name",
+			tokenCfg: config.Token{AthenzDomain: "dummyDomain"},
+			wantErr:  errors.Wrap(ErrInvalidSetting, "athenz domain and service name are required"),
+		},
+		{
+			name: "NewSvcCertService return error with invalid private key",
+			tokenCfg: config.Token{
+				AthenzDomain:   "dummyDomain",
+				ServiceName:    "dummyService",
+				PrivateKeyPath: "./assets/dummyServer.crt",
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "PrivateKeyPath: unsupported PEM type CERTIFICATE: Invalid private key"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewSvcCertService(tt.cfg, tt.tokenCfg, nil)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("error not the same, want: %v, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("NewSvcCertService() error: %v", err)
+				return
+			}
+			if err := tt.checkFunc(got); err != nil {
+				t.Errorf("NewSvcCertService() %v", err)
+			}
+		})
+	}
+}
+
+func Test_svcCertService_getSvcCert(t *testing.T) {
+	cert, err := ioutil.ReadFile("./assets/dummyServer.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notAfter, _ := certNotAfter(string(cert))
+
+	type test struct {
+		name      string
+		service   func() *svcCertService
+		want      *SvcCert
+		wantErr   error
+		afterFunc func()
+	}
+	tests := []test{
+		func() test {
+			s := &svcCertService{}
+			s.cert.Store(&SvcCert{
+				Cert:       "cachedCert",
+				ExpiryTime: fastime.Now().Add(time.Hour).Unix(),
+			})
+			return test{
+				name: "getSvcCert return the cached certificate",
+				service: func() *svcCertService {
+					return s
+				},
+				want: s.cert.Load().(*SvcCert),
+			}
+		}(),
+		func() test {
+			var gotReq instanceRefreshRequest
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/instance/dummyDomain/dummyService/refresh" || r.Header.Get("Athenz-Principal") != "dummyNToken" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				if err := json.NewDecoder(r.Body).Decode(&gotReq); err != nil || gotReq.CSR != "dummyCSR" || gotReq.ExpiryTime != 60 {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				json.NewEncoder(w).Encode(identity{
+					Name:         "dummyDomain.dummyService",
+					Certificate:  string(cert),
+					CACertBundle: "dummyChain",
+				})
+			}))
+			return test{
+				name: "getSvcCert fetch the certificate when it is expired",
+				service: func() *svcCertService {
+					s := &svcCertService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						domain:                "dummyDomain",
+						service:               "dummyService",
+						csr:                   "dummyCSR",
+						expiry:                time.Hour,
+						httpClient:            dummyServer.Client(),
+					}
+					s.cert.Store(&SvcCert{
+						Cert:       "expiredCert",
+						ExpiryTime: fastime.Now().Add(-time.Hour).Unix(),
+					})
+					return s
+				},
+				want: &SvcCert{
+					Cert:       string(cert),
+					Chain:      "dummyChain",
+					ExpiryTime: notAfter.Unix(),
+				},
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+		func() test {
+			dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusForbidden)
+			}))
+			return test{
+				name: "getSvcCert return error when athenz return error",
+				service: func() *svcCertService {
+					return &svcCertService{
+						token: func() (string, error) {
+							return "dummyNToken", nil
+						},
+						athenzURL:             dummyServer.URL,
+						athenzPrincipleHeader: "Athenz-Principal",
+						httpClient:            dummyServer.Client(),
+					}
+				},
+				wantErr: ErrSvcCertRequestFailed,
+				afterFunc: func() {
+					dummyServer.Close()
+				},
+			}
+		}(),
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.afterFunc != nil {
+				defer tt.afterFunc()
+			}
+			got, err := tt.service().getSvcCert(context.Background())
+			if err != tt.wantErr {
+				t.Errorf("getSvcCert() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getSvcCert() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_svcCertService_StartSvcCertUpdater(t *testing.T) {
+	t.Run("StartSvcCertUpdater fetch the certificate on start", func(t *testing.T) {
+		cert, err := ioutil.ReadFile("./assets/dummyServer.crt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(identity{
+				Certificate: string(cert),
+			})
+		}))
+		defer dummyServer.Close()
+
+		s := &svcCertService{
+			token: func() (string, error) {
+				return "dummyNToken", nil
+			},
+			athenzURL:             dummyServer.URL,
+			athenzPrincipleHeader: "Athenz-Principal",
+			httpClient:            dummyServer.Client(),
+			refreshBefore:         time.Hour,
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		ech := s.StartSvcCertUpdater(ctx)
+		time.Sleep(time.Millisecond * 100)
+		cancel()
+
+		for err := range ech {
+			if err != context.Canceled {
+				t.Errorf("StartSvcCertUpdater() error: %v", err)
+			}
+		}
+
+		c, ok := s.cert.Load().(*SvcCert)
+		if !ok || c.Cert != string(cert) {
+			t.Errorf("StartSvcCertUpdater() did not cache the certificate, got: %+v", c)
+		}
+	})
+}
+
+func Test_svcCertService_updateSvcCertWithRetry(t *testing.T) {
+	dummyServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer dummyServer.Close()
+
+	newService := func(errRetryMaxCount int) *svcCertService {
+		return &svcCertService{
+			token: func() (string, error) {
+				return "dummyNToken", nil
+			},
+			athenzURL:             dummyServer.URL,
+			athenzPrincipleHeader: "Athenz-Principal",
+			httpClient:            dummyServer.Client(),
+			errRetryMaxCount:      errRetryMaxCount,
+			errRetryInterval:      time.Hour,
+		}
+	}
+
+	t.Run("updateSvcCertWithRetry does not wait after the last attempt", func(t *testing.T) {
+		got := 0
+		for range newService(0).updateSvcCertWithRetry(context.Background()) {
+			got++
+		}
+		if got != 1 {
+			t.Errorf("updateSvcCertWithRetry() errors = %v, want 1", got)
+		}
+	})
+
+	t.Run("updateSvcCertWithRetry stops waiting when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		echan := newService(3).updateSvcCertWithRetry(ctx)
+		<-echan
+		cancel()
+		for range echan {
+		}
+	})
+}
+
+func Test_svcCertService_createPostInstanceRefreshRequest(t *testing.T) {
+	s := &svcCertService{
+		athenzURL:             "https://dummy.athenz.com/zts/v1",
+		athenzPrincipleHeader: "Athenz-Principal",
+		domain:                "dummy/../Domain",
+		service:               "dummy?Service",
+	}
+	req, err := s.createPostInstanceRefreshRequest("dummyNToken")
+	if err != nil {
+		t.Fatalf("createPostInstanceRefreshRequest() error: %v", err)
+	}
+	if got, want := req.URL.EscapedPath(), "/zts/v1/instance/dummy%2F..%2FDomain/dummy%3FService/refresh"; got != want {
+		t.Errorf("createPostInstanceRefreshRequest() path = %v, want %v", got, want)
+	}
+	if req.URL.RawQuery != "" {
+		t.Errorf("createPostInstanceRefreshRequest() query = %v, want empty", req.URL.RawQuery)
+	}
+}
+
+func Test_svcCertCSRTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		dnsSuffix string
+		wantDNS   []string
+	}{
+		{
+			name:      "template with DNS suffix",
+			dnsSuffix: "athenz.cloud",
+			wantDNS:   []string{"dummyService.dummy-domain.athenz.cloud"},
+		},
+		{
+			name: "template without DNS suffix",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := svcCertCSRTemplate("dummy.domain", "dummyService", tt.dnsSuffix)
+			if got.commonName != "dummy.domain.dummyService" ||
+				!reflect.DeepEqual(got.dnsNames, tt.wantDNS) ||
+				len(got.uris) != 1 || got.uris[0].String() != "spiffe://dummy.domain/sa/dummyService" {
+				t.Errorf("svcCertCSRTemplate() = %+v", got)
+			}
+		})
+	}
+}
@@ -18,17 +18,88 @@ package service
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
 	"github.com/pkg/errors"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
 )
 
+const (
+	// defaultTLSReloadInterval represents the default interval to check the changes of the TLS files.
+	defaultTLSReloadInterval = 10 * time.Second
+)
+
 var (
 	// ErrTLSCertOrKeyNotFound represents an error that TLS cert or key is not found on the specified file path.
 	ErrTLSCertOrKeyNotFound = errors.New("Cert/Key path not found")
+
+	// tlsVersions represents the TLS versions by the name in the configuration.
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	// tlsCipherSuites represents the cipher suites by the name in the configuration. RC4 and 3DES are not supported as they are insecure.
+	// The cipher suites of TLS 1.3 are not configurable.
+	tlsCipherSuites = map[string]uint16{
+		"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_RSA_WITH_AES_128_CBC_SHA256":         tls.TLS_RSA_WITH_AES_128_CBC_SHA256,
+		"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+		"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256,
+		"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256,
+		"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+		"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	}
+
+	// tlsClientAuthTypes represents the client certificate authentication modes by the name in the configuration.
+	tlsClientAuthTypes = map[string]tls.ClientAuthType{
+		"none":            tls.NoClientCert,
+		"request":         tls.RequestClientCert,
+		"verify-if-given": tls.VerifyClientCertIfGiven,
+		"require":         tls.RequireAndVerifyClientCert,
+	}
 )
 
+// tlsReloader represents the TLS configuration of client sidecar server, which reloads the certificate, the key and the CA
+// when the files are changed, e.g. rotated by cert-manager.
+type tlsReloader struct {
+	cfg      config.TLS
+	interval time.Duration
+
+	// mu guards the fields below, which are updated on the TLS handshake.
+	mu      sync.Mutex
+	current *tls.Config
+	stamp   string
+	checked time.Time
+	// lastErr represents the error of the last reload, nil if the current files are loaded.
+	lastErr error
+}
+
+// tlsNextProtos returns the ALPN protocols of client sidecar server, which net/http sets to the server TLS configuration by default.
+// They are also required in the TLS configuration returned for each handshake, otherwise HTTP/2 is not negotiated.
+func tlsNextProtos() []string {
+	return []string{"h2", "http/1.1"}
+}
+
 // NewTLSConfig returns a *tls.Config struct or error.
 // It reads TLS configuration and initializes *tls.Config struct.
 // It initializes TLS configuration, for example the CA certificate and key to start TLS server.
@@ -44,6 +115,29 @@ func NewTLSConfig(cfg config.TLS) (*tls.Config, error) {
 		},
 		SessionTicketsDisabled: true,
 		ClientAuth:             tls.NoClientCert,
+		NextProtos:             tlsNextProtos(),
+	}
+
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidSetting, "TLS.MinVersion: %q is not a TLS version", cfg.MinVersion)
+		}
+		t.MinVersion = v
+	}
+	if cfg.MaxVersion != "" {
+		v, ok := tlsVersions[cfg.MaxVersion]
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidSetting, "TLS.MaxVersion: %q is not a TLS version", cfg.MaxVersion)
+		}
+		t.MaxVersion = v
+	}
+	for _, name := range cfg.CipherSuites {
+		id, ok := tlsCipherSuites[name]
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidSetting, "TLS.CipherSuites: %q is not supported", name)
+		}
+		t.CipherSuites = append(t.CipherSuites, id)
 	}
 
 	cert := config.GetActualValue(cfg.Cert)
@@ -62,7 +156,7 @@ func NewTLSConfig(cfg config.TLS) (*tls.Config, error) {
 	t.Certificates[0] = crt
 
 	if ca != "" {
-		pool, err := NewX509CertPool(ca)
+		pool, err := newClientCAPool(ca)
 		if err != nil {
 			return nil, err
 		}
@@ -70,10 +164,124 @@ func NewTLSConfig(cfg config.TLS) (*tls.Config, error) {
 		t.ClientAuth = tls.RequireAndVerifyClientCert
 	}
 
+	if cfg.ClientAuth != "" {
+		auth, ok := tlsClientAuthTypes[cfg.ClientAuth]
+		if !ok {
+			return nil, errors.Wrapf(ErrInvalidSetting, "TLS.ClientAuth: %q is not supported", cfg.ClientAuth)
+		}
+		if (auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert) && t.ClientCAs == nil {
+			return nil, errors.Wrapf(ErrInvalidSetting, "TLS.ClientAuth: %q requires CA", cfg.ClientAuth)
+		}
+		t.ClientAuth = auth
+	}
+
 	t.BuildNameToCertificate()
 	return t, nil
 }
 
+// newTLSReloader returns a tlsReloader loading the TLS configuration of cfg, or any error occurred.
+func newTLSReloader(cfg config.TLS) (*tlsReloader, error) {
+	interval := defaultTLSReloadInterval
+	if cfg.ReloadInterval != "" {
+		d, err := time.ParseDuration(cfg.ReloadInterval)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "TLS.ReloadInterval: "+err.Error())
+		}
+		interval = d
+	}
+
+	// the files are checked before loading, so that the change during loading is detected by the next check
+	stamp, serr := tlsFileStamp(cfg)
+	t, err := NewTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if serr != nil {
+		return nil, serr
+	}
+	return &tlsReloader{
+		cfg:      cfg,
+		interval: interval,
+		current:  t,
+		stamp:    stamp,
+		checked:  fastime.Now(),
+	}, nil
+}
+
+// getConfigForClient returns the current TLS configuration for the TLS handshake.
+// The files are checked at most once per interval, and reloaded if any of them is changed.
+// The current TLS configuration is kept if the new files cannot be loaded, e.g. the certificate is updated but the key is not yet.
+func (r *tlsReloader) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := fastime.Now()
+	if r.interval <= 0 || now.Sub(r.checked) < r.interval {
+		return r.current, nil
+	}
+	r.checked = now
+
+	stamp, err := tlsFileStamp(r.cfg)
+	if err != nil {
+		glg.Warnf("cannot check TLS files, keep the current certificate: %v", err)
+		r.lastErr = err
+		return r.current, nil
+	}
+	if stamp == r.stamp {
+		r.lastErr = nil
+		return r.current, nil
+	}
+	t, err := NewTLSConfig(r.cfg)
+	if err != nil {
+		glg.Warnf("cannot reload TLS files, keep the current certificate: %v", err)
+		r.lastErr = err
+		return r.current, nil
+	}
+	r.current = t
+	r.stamp = stamp
+	r.lastErr = nil
+	glg.Info("TLS certificate reloaded")
+	return t, nil
+}
+
+// status returns the error of the last reload, or nil if the current files are loaded.
+func (r *tlsReloader) status() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastErr
+}
+
+// tlsFileStamp returns the modification time and the size of the certificate, the key and the CA files to detect the changes.
+func tlsFileStamp(cfg config.TLS) (string, error) {
+	var b strings.Builder
+	for _, p := range []string{cfg.Cert, cfg.Key, cfg.CA} {
+		p = config.GetActualValue(p)
+		if p == "" {
+			continue
+		}
+		fi, err := os.Stat(p)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", p, fi.ModTime().UnixNano(), fi.Size())
+	}
+	return b.String(), nil
+}
+
+// newClientCAPool returns *x509.CertPool containing only the CA certificates of the path, or error.
+// Unlike NewX509CertPool, the system certificates are not trusted, because the names of the verified client certificate are used for the authorization policy.
+func newClientCAPool(path string) (*x509.CertPool, error) {
+	c, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(c) {
+		return nil, errors.New("Certification Failed")
+	}
+	return pool, nil
+}
+
 // NewX509CertPool returns *x509.CertPool struct or error.
 // The CertPool will read the certificate from the path, and append the content to the system certificate pool.
 func NewX509CertPool(path string) (*x509.CertPool, error) {
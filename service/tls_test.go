@@ -18,11 +18,15 @@ package service
 import (
 	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
 )
 
@@ -351,6 +355,101 @@ func TestNewTLSConfig(t *testing.T) {
 			},
 			wantErr: fmt.Errorf("Certification Failed"),
 		},
+		{
+			name: "Check the configured TLS policy",
+			args: args{
+				cfg: config.TLS{
+					Cert:         "./assets/dummyServer.crt",
+					Key:          "./assets/dummyServer.key",
+					CA:           "./assets/dummyCa.pem",
+					MinVersion:   "1.1",
+					MaxVersion:   "1.2",
+					CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305"},
+					ClientAuth:   "verify-if-given",
+				},
+			},
+			want: &tls.Config{
+				MinVersion:   tls.VersionTLS11,
+				MaxVersion:   tls.VersionTLS12,
+				CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305},
+				ClientAuth:   tls.VerifyClientCertIfGiven,
+			},
+			checkFunc: func(got, want *tls.Config) error {
+				if got.MinVersion != want.MinVersion || got.MaxVersion != want.MaxVersion {
+					return fmt.Errorf("Version unmatched: got: %d-%d  want: %d-%d", got.MinVersion, got.MaxVersion, want.MinVersion, want.MaxVersion)
+				}
+				if !reflect.DeepEqual(got.CipherSuites, want.CipherSuites) {
+					return fmt.Errorf("CipherSuites unmatched: got: %v  want: %v", got.CipherSuites, want.CipherSuites)
+				}
+				if got.ClientAuth != want.ClientAuth {
+					return fmt.Errorf("ClientAuth unmatched: got: %d  want: %d", got.ClientAuth, want.ClientAuth)
+				}
+				return nil
+			},
+		},
+		{
+			name: "Check client_auth request without CA",
+			args: args{
+				cfg: config.TLS{
+					Cert:       "./assets/dummyServer.crt",
+					Key:        "./assets/dummyServer.key",
+					ClientAuth: "request",
+				},
+			},
+			want: &tls.Config{
+				ClientAuth: tls.RequestClientCert,
+			},
+			checkFunc: func(got, want *tls.Config) error {
+				if got.ClientAuth != want.ClientAuth {
+					return fmt.Errorf("ClientAuth unmatched: got: %d  want: %d", got.ClientAuth, want.ClientAuth)
+				}
+				return nil
+			},
+		},
+		{
+			name: "Request with client_auth require without CA",
+			args: args{
+				cfg: config.TLS{
+					Cert:       "./assets/dummyServer.crt",
+					Key:        "./assets/dummyServer.key",
+					ClientAuth: "require",
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `TLS.ClientAuth: "require" requires CA`),
+		},
+		{
+			name: "Request with unknown client_auth",
+			args: args{
+				cfg: config.TLS{
+					Cert:       "./assets/dummyServer.crt",
+					Key:        "./assets/dummyServer.key",
+					ClientAuth: "always",
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `TLS.ClientAuth: "always" is not supported`),
+		},
+		{
+			name: "Request with invalid min_version",
+			args: args{
+				cfg: config.TLS{
+					Cert:       "./assets/dummyServer.crt",
+					Key:        "./assets/dummyServer.key",
+					MinVersion: "TLS1.2",
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `TLS.MinVersion: "TLS1.2" is not a TLS version`),
+		},
+		{
+			name: "Request with unsupported cipher suite",
+			args: args{
+				cfg: config.TLS{
+					Cert:         "./assets/dummyServer.crt",
+					Key:          "./assets/dummyServer.key",
+					CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"},
+				},
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, `TLS.CipherSuites: "TLS_RSA_WITH_RC4_128_SHA" is not supported`),
+		},
 	}
 
 	for _, tt := range tests {
@@ -455,3 +554,96 @@ func TestNewX509CertPool(t *testing.T) {
 		})
 	}
 }
+
+func Test_tlsReloader_getConfigForClient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	copyFile := func(src, dst string) {
+		b, err := ioutil.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(dst, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := config.TLS{
+		Cert:           filepath.Join(dir, "server.crt"),
+		Key:            filepath.Join(dir, "server.key"),
+		ReloadInterval: "1h",
+	}
+	copyFile("./assets/dummyServer.crt", cfg.Cert)
+	copyFile("./assets/dummyServer.key", cfg.Key)
+
+	r, err := newTLSReloader(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _ := r.getConfigForClient(nil)
+	if current == nil || len(current.Certificates) != 1 {
+		t.Fatalf("getConfigForClient() = %v, want the loaded config", current)
+	}
+
+	// the files are not checked before the interval
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(cfg.Cert, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := r.getConfigForClient(nil); got != current {
+		t.Error("getConfigForClient() reloaded before the interval")
+	}
+
+	// the changed files are reloaded after the interval
+	r.checked = time.Time{}
+	got, _ := r.getConfigForClient(nil)
+	if got == current || got == nil || len(got.Certificates) != 1 {
+		t.Errorf("getConfigForClient() = %v, want the reloaded config", got)
+	}
+	current = got
+
+	// the current config is kept if the changed files are invalid
+	copyFile("./assets/invalid_dummyServer.crt", cfg.Cert)
+	r.checked = time.Time{}
+	if got, _ := r.getConfigForClient(nil); got != current {
+		t.Error("getConfigForClient() replaced by the invalid files")
+	}
+	if err := r.status(); err == nil {
+		t.Error("status() after the invalid files = nil")
+	}
+
+	// the files are reloaded again when they are fixed
+	copyFile("./assets/dummyServer.crt", cfg.Cert)
+	r.checked = time.Time{}
+	if got, _ := r.getConfigForClient(nil); got == current {
+		t.Error("getConfigForClient() not reloaded after the files are fixed")
+	}
+	if err := r.status(); err != nil {
+		t.Errorf("status() after the files are fixed = %v", err)
+	}
+
+	if _, err := newTLSReloader(config.TLS{Cert: cfg.Cert, Key: cfg.Key, ReloadInterval: "1 hour"}); err == nil {
+		t.Error("newTLSReloader() with invalid reload interval error = nil")
+	}
+}
+
+func Test_newClientCAPool(t *testing.T) {
+	pool, err := newClientCAPool("./assets/dummyCa.pem")
+	if err != nil {
+		t.Fatalf("newClientCAPool() error = %v", err)
+	}
+	// only the configured CA is trusted, not the system certificates
+	if got := len(pool.Subjects()); got != 1 {
+		t.Errorf("newClientCAPool() contains %d certificates, want 1", got)
+	}
+
+	if _, err := newClientCAPool("./assets/invalid_dummyCa.pem"); err == nil || err.Error() != "Certification Failed" {
+		t.Errorf("newClientCAPool() with invalid CA error = %v, want Certification Failed", err)
+	}
+	if _, err := newClientCAPool("./assets/not_found.pem"); err == nil {
+		t.Error("newClientCAPool() with not found CA error = nil")
+	}
+}
@@ -0,0 +1,248 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpango/fastime"
+	"github.com/kpango/glg"
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+	"github.com/yahoojapan/athenz-client-sidecar/metrics"
+)
+
+// circuitState represent the state of the circuit breaker.
+type circuitState int
+
+const (
+	// circuitClosed represents the requests are sent to athenz server.
+	circuitClosed circuitState = iota
+	// circuitOpen represents the requests fail fast without being sent to athenz server.
+	circuitOpen
+	// circuitHalfOpen represents a probe request is sent to athenz server to check if it is recovered.
+	circuitHalfOpen
+)
+
+// circuitBreaker stops sending the requests to athenz server when it is clearly down.
+// It opens after the consecutive failures reach the threshold, and allows a probe request after the open duration.
+// The probe success closes the circuit, and the probe failure opens it again.
+type circuitBreaker struct {
+	threshold    int
+	openDuration time.Duration
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+}
+
+// backoff represent the exponential backoff with jitter.
+type backoff struct {
+	base   time.Duration
+	max    time.Duration
+	jitter float64
+}
+
+// ZTSError represent an error response of athenz server, which is returned to the caller with the same status code.
+type ZTSError struct {
+	StatusCode int
+	Message    string
+}
+
+var (
+	// ErrCircuitOpen represent an error when the request to athenz server is rejected by the open circuit breaker.
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+const (
+	// defaultCircuitOpenDuration represents the default duration to wait before probing athenz server after the circuit breaker is opened.
+	defaultCircuitOpenDuration = time.Second * 30
+
+	// defaultBackoffJitter represents the maximum fraction of the backoff to randomly subtract.
+	defaultBackoffJitter = 0.2
+
+	// maxZTSErrorMessageSize represents the maximum size of the error response body to read.
+	maxZTSErrorMessageSize = 4096
+)
+
+// Error returns the status code and the message of the error response.
+func (e *ZTSError) Error() string {
+	return fmt.Sprintf("athenz server returned %d: %s", e.StatusCode, e.Message)
+}
+
+// newZTSError returns the ZTSError of res. The message is taken from the athenz error response body, or the body itself if it is not the athenz error.
+func newZTSError(res *http.Response) *ZTSError {
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, maxZTSErrorMessageSize))
+	if err != nil {
+		glg.Debugf("cannot read response body, err: %v", err)
+	}
+
+	var body struct {
+		Message string `json:"message"`
+	}
+	msg := strings.TrimSpace(string(b))
+	if json.Unmarshal(b, &body) == nil && body.Message != "" {
+		msg = body.Message
+	}
+	if msg == "" {
+		msg = http.StatusText(res.StatusCode)
+	}
+	return &ZTSError{
+		StatusCode: res.StatusCode,
+		Message:    msg,
+	}
+}
+
+// isZTSClientError returns true if err is caused by the client error (4xx) of athenz server.
+func isZTSClientError(err error) bool {
+	e, ok := errors.Cause(err).(*ZTSError)
+	return ok && e.StatusCode >= http.StatusBadRequest && e.StatusCode < http.StatusInternalServerError
+}
+
+// newCircuitBreaker returns the circuit breaker configured by cfg, or nil if it is disabled.
+func newCircuitBreaker(cfg config.CircuitBreaker) (*circuitBreaker, error) {
+	if cfg.FailureThreshold < 0 {
+		return nil, errors.Wrap(ErrInvalidSetting, "CircuitBreaker.FailureThreshold < 0")
+	}
+	if cfg.FailureThreshold == 0 {
+		return nil, nil
+	}
+
+	openDuration := defaultCircuitOpenDuration
+	if cfg.OpenDuration != "" {
+		var err error
+		if openDuration, err = time.ParseDuration(cfg.OpenDuration); err != nil {
+			return nil, errors.Wrap(ErrInvalidSetting, "CircuitBreaker.OpenDuration: "+err.Error())
+		}
+	}
+
+	return &circuitBreaker{
+		threshold:    cfg.FailureThreshold,
+		openDuration: openDuration,
+	}, nil
+}
+
+// allow returns ErrCircuitOpen if the request should not be sent to athenz server.
+func (c *circuitBreaker) allow() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case circuitOpen:
+		if fastime.Now().Sub(c.openedAt) < c.openDuration {
+			return ErrCircuitOpen
+		}
+		glg.Info("circuit breaker is half-open, probing athenz server")
+		c.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		// only one probe request is allowed
+		return ErrCircuitOpen
+	}
+	return nil
+}
+
+// report records the result of the request allowed by allow.
+func (c *circuitBreaker) report(success bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if success {
+		if c.state != circuitClosed {
+			glg.Info("circuit breaker is closed")
+		}
+		c.state = circuitClosed
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.state == circuitHalfOpen || c.failures >= c.threshold {
+		if c.state != circuitOpen {
+			glg.Warnf("circuit breaker is open, consecutive failures: %d", c.failures)
+		}
+		c.state = circuitOpen
+		c.openedAt = fastime.Now()
+	}
+}
+
+// abort releases the request allowed by allow without the result.
+// The aborted probe request lets the next request probe athenz server again.
+func (c *circuitBreaker) abort() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == circuitHalfOpen {
+		c.state = circuitOpen
+	}
+}
+
+// duration returns the backoff before the retry of the given attempt, starting from 1.
+// The backoff doubles on each attempt up to the max, and a random jitter of up to the jitter fraction is subtracted.
+func (b backoff) duration(attempt int) time.Duration {
+	d := b.base
+	for i := 1; i < attempt && (b.max <= 0 || d < b.max); i++ {
+		d *= 2
+	}
+	if b.max > 0 && d > b.max {
+		d = b.max
+	}
+	return time.Duration(float64(d) * (1 - b.jitter*rand.Float64()))
+}
+
+// doZTSRequest sends req to athenz server by client and records the metrics labeled by api.
+// The request is rejected by ErrCircuitOpen when breaker is open, and the transport error or the server error (5xx) is reported to breaker as failure.
+// The breaker can be nil to disable the circuit breaker.
+func doZTSRequest(ctx context.Context, client *http.Client, breaker *circuitBreaker, api string, req *http.Request) (*http.Response, error) {
+	if err := breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		metrics.ObserveZTSRequest(api, 0, start)
+		if ctx.Err() != nil {
+			// the request canceled by the caller does not mean athenz server is down
+			breaker.abort()
+		} else {
+			breaker.report(false)
+		}
+		return nil, err
+	}
+	metrics.ObserveZTSRequest(api, res.StatusCode, start)
+	breaker.report(res.StatusCode < http.StatusInternalServerError)
+	return res, nil
+}
@@ -0,0 +1,292 @@
+/*
+Copyright (C)  2018 Yahoo Japan Corporation Athenz team.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package service
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/yahoojapan/athenz-client-sidecar/config"
+)
+
+func Test_newCircuitBreaker(t *testing.T) {
+	type test struct {
+		name    string
+		cfg     config.CircuitBreaker
+		want    *circuitBreaker
+		wantErr error
+	}
+	tests := []test{
+		{
+			name: "newCircuitBreaker return nil when disabled",
+			cfg:  config.CircuitBreaker{},
+		},
+		{
+			name: "newCircuitBreaker return default open duration",
+			cfg: config.CircuitBreaker{
+				FailureThreshold: 3,
+			},
+			want: &circuitBreaker{
+				threshold:    3,
+				openDuration: defaultCircuitOpenDuration,
+			},
+		},
+		{
+			name: "newCircuitBreaker return circuit breaker",
+			cfg: config.CircuitBreaker{
+				FailureThreshold: 3,
+				OpenDuration:     "1m",
+			},
+			want: &circuitBreaker{
+				threshold:    3,
+				openDuration: time.Minute,
+			},
+		},
+		{
+			name: "newCircuitBreaker return error with negative threshold",
+			cfg: config.CircuitBreaker{
+				FailureThreshold: -1,
+			},
+			wantErr: errors.Wrap(ErrInvalidSetting, "CircuitBreaker.FailureThreshold < 0"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newCircuitBreaker(tt.cfg)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Errorf("newCircuitBreaker() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("newCircuitBreaker() error = %v", err)
+				return
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && (got.threshold != tt.want.threshold || got.openDuration != tt.want.openDuration)) {
+				t.Errorf("newCircuitBreaker() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_circuitBreaker(t *testing.T) {
+	c := &circuitBreaker{
+		threshold:    2,
+		openDuration: time.Millisecond * 100,
+	}
+
+	// closed
+	for i := 0; i < 2; i++ {
+		if err := c.allow(); err != nil {
+			t.Fatalf("allow() on closed circuit error = %v", err)
+		}
+		c.report(false)
+	}
+
+	// open after the consecutive failures
+	if err := c.allow(); err != ErrCircuitOpen {
+		t.Fatalf("allow() on open circuit error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// half-open after the open duration, only one probe is allowed
+	time.Sleep(time.Millisecond * 150)
+	if err := c.allow(); err != nil {
+		t.Fatalf("allow() probe error = %v", err)
+	}
+	if err := c.allow(); err != ErrCircuitOpen {
+		t.Fatalf("allow() during probe error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// the probe failure opens again
+	c.report(false)
+	if err := c.allow(); err != ErrCircuitOpen {
+		t.Fatalf("allow() after probe failure error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// the probe success closes
+	time.Sleep(time.Millisecond * 150)
+	if err := c.allow(); err != nil {
+		t.Fatalf("allow() probe error = %v", err)
+	}
+	c.report(true)
+	if err := c.allow(); err != nil {
+		t.Fatalf("allow() after probe success error = %v", err)
+	}
+	if c.failures != 0 {
+		t.Errorf("failures = %v, want 0", c.failures)
+	}
+}
+
+func Test_circuitBreaker_abort(t *testing.T) {
+	c := &circuitBreaker{
+		threshold:    1,
+		openDuration: time.Millisecond * 100,
+	}
+	c.report(false)
+	time.Sleep(time.Millisecond * 150)
+	if err := c.allow(); err != nil {
+		t.Fatalf("allow() probe error = %v", err)
+	}
+	c.abort()
+
+	// the next request can probe again
+	if err := c.allow(); err != nil {
+		t.Errorf("allow() after abort error = %v", err)
+	}
+}
+
+func Test_backoff_duration(t *testing.T) {
+	type test struct {
+		name    string
+		b       backoff
+		attempt int
+		wantMin time.Duration
+		wantMax time.Duration
+	}
+	tests := []test{
+		{
+			name: "duration return base at first attempt",
+			b: backoff{
+				base: time.Second,
+				max:  time.Minute,
+			},
+			attempt: 1,
+			wantMin: time.Second,
+			wantMax: time.Second,
+		},
+		{
+			name: "duration double on each attempt",
+			b: backoff{
+				base: time.Second,
+				max:  time.Minute,
+			},
+			attempt: 4,
+			wantMin: time.Second * 8,
+			wantMax: time.Second * 8,
+		},
+		{
+			name: "duration return max",
+			b: backoff{
+				base: time.Second,
+				max:  time.Second * 5,
+			},
+			attempt: 10,
+			wantMin: time.Second * 5,
+			wantMax: time.Second * 5,
+		},
+		{
+			name: "duration subtract jitter",
+			b: backoff{
+				base:   time.Second,
+				max:    time.Minute,
+				jitter: 0.5,
+			},
+			attempt: 2,
+			wantMin: time.Second,
+			wantMax: time.Second * 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.b.duration(tt.attempt); got < tt.wantMin || got > tt.wantMax {
+				t.Errorf("duration() = %v, want [%v, %v]", got, tt.wantMin, tt.wantMax)
+			}
+		})
+	}
+}
+
+func Test_doZTSRequest(t *testing.T) {
+	code := http.StatusInternalServerError
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(code)
+	}))
+	defer srv.Close()
+
+	c := &circuitBreaker{
+		threshold:    1,
+		openDuration: time.Hour,
+	}
+	do := func() (*http.Response, error) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		return doZTSRequest(context.Background(), srv.Client(), c, "test", req)
+	}
+
+	res, err := do()
+	if err != nil || res.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("doZTSRequest() = %v, %v", res, err)
+	}
+	res.Body.Close()
+
+	// the server error opens the circuit breaker
+	code = http.StatusOK
+	if _, err := do(); err != ErrCircuitOpen {
+		t.Errorf("doZTSRequest() error = %v, want %v", err, ErrCircuitOpen)
+	}
+
+	// nil circuit breaker is disabled
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	res, err = doZTSRequest(context.Background(), srv.Client(), nil, "test", req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("doZTSRequest() without circuit breaker = %v, %v", res, err)
+	}
+	res.Body.Close()
+}
+
+func Test_newZTSError(t *testing.T) {
+	type test struct {
+		name string
+		code int
+		body string
+		want string
+	}
+	tests := []test{
+		{
+			name: "newZTSError return the message of athenz error",
+			code: http.StatusForbidden,
+			body: `{"code":403,"message":"principal is not a member of the role"}`,
+			want: "athenz server returned 403: principal is not a member of the role",
+		},
+		{
+			name: "newZTSError return the body",
+			code: http.StatusBadRequest,
+			body: " bad request body\n",
+			want: "athenz server returned 400: bad request body",
+		},
+		{
+			name: "newZTSError return the status text with empty body",
+			code: http.StatusNotFound,
+			want: "athenz server returned 404: Not Found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := newZTSError(&http.Response{
+				StatusCode: tt.code,
+				Body:       ioutil.NopCloser(strings.NewReader(tt.body)),
+			})
+			if got.StatusCode != tt.code || got.Error() != tt.want {
+				t.Errorf("newZTSError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/kpango/glg"
 	ntokend "github.com/kpango/ntokend"
+	"github.com/pkg/errors"
 	"github.com/yahoojapan/athenz-client-sidecar/config"
 	"github.com/yahoojapan/athenz-client-sidecar/handler"
 	"github.com/yahoojapan/athenz-client-sidecar/infra"
@@ -33,17 +34,23 @@ import (
 // Tenant represent a client sidecar behavior
 type Tenant interface {
 	Start(ctx context.Context) chan []error
+	Reload(cfg config.Config) error
 }
 
 type clientd struct {
-	cfg    config.Config
-	token  ntokend.TokenService
-	server service.Server
-	role   service.RoleService
+	cfg      config.Config
+	token    ntokend.TokenService
+	server   service.Server
+	handler  handler.Handler
+	role     service.RoleService
+	access   service.AccessService
+	svcCert  service.SvcCertService
+	roleCert service.RoleCertService
+	policy   service.PolicyService
 }
 
 // New returns a client sidecar daemon, or any error occurred.
-// Client sidecar daemon contains token service, role token service, host certificate service, user database client and client sidecar service.
+// Client sidecar daemon contains token service, role token service, access token service, service certificate service, role certificate service, user database client and client sidecar service.
 func New(cfg config.Config) (Tenant, error) {
 	// create token service
 	token, err := createNtokend(cfg.Token)
@@ -52,34 +59,150 @@ func New(cfg config.Config) (Tenant, error) {
 	}
 
 	// create role service
-	role, err := service.NewRoleService(cfg.Role, token.GetTokenProvider())
+	role, err := service.NewRoleService(cfg.Role, token.GetTokenProvider(), service.WithRoleCacheKey(config.GetActualValue(cfg.Token.PrivateKeyPath)))
 	if err != nil {
 		return nil, err
 	}
 
-	serveMux := router.New(cfg.Server, handler.New(cfg.Proxy, infra.NewBuffer(cfg.Proxy.BufferSize), token.GetTokenProvider(), role.GetRoleProvider()))
+	// create access service
+	access, err := service.NewAccessService(cfg.Access, token.GetTokenProvider())
+	if err != nil {
+		return nil, err
+	}
+
+	// create service certificate service
+	var (
+		svcCert         service.SvcCertService
+		svcCertProvider service.SvcCertProvider
+	)
+	if cfg.ServiceCert.Enable {
+		svcCert, err = service.NewSvcCertService(cfg.ServiceCert, cfg.Token, token.GetTokenProvider())
+		if err != nil {
+			return nil, err
+		}
+		svcCertProvider = svcCert.GetSvcCertProvider()
+	}
+
+	// create role certificate service
+	var (
+		roleCert         service.RoleCertService
+		roleCertProvider service.RoleCertProvider
+	)
+	if cfg.RoleCert.Enable {
+		roleCert, err = service.NewRoleCertService(cfg.RoleCert, cfg.Token, token.GetTokenProvider())
+		if err != nil {
+			return nil, err
+		}
+		roleCertProvider = roleCert.GetRoleCertProvider()
+	}
+
+	// create health checker
+	hc := service.NewHealthChecker()
+	hc.RegisterReadiness("ntoken", service.TokenCheck(token.GetTokenProvider()))
+	hc.RegisterReadiness("roletoken", role.RefreshCheck())
+	hc.RegisterReadiness("roletoken_prefetch", role.PrefetchCheck())
+
+	// create policy service
+	policy, err := service.NewPolicyService(cfg.Policy)
+	if err != nil {
+		return nil, err
+	}
+
+	h := handler.New(cfg.Proxy, infra.NewBuffer(cfg.Proxy.BufferSize), token.GetTokenProvider(), role.GetRoleProvider(), access.GetAccessProvider(), svcCertProvider, roleCertProvider, policy.GetAuthorizer())
+	serveMux := router.New(cfg.Server, h)
 	srv := service.NewServer(
 		service.WithServerConfig(cfg.Server),
 		service.WithServerHandler(serveMux),
+		service.WithHealthChecker(hc),
+		service.WithAdminHandler(router.NewAdmin(cfg.Server, handler.NewAdmin(role))),
 	)
+	// the TLS configuration replaced on reload is checked by the server
+	hc.RegisterReadiness("tls", srv.TLSCheck())
 
 	return &clientd{
-		cfg:    cfg,
-		token:  token,
-		role:   role,
-		server: srv,
+		cfg:      cfg,
+		token:    token,
+		role:     role,
+		access:   access,
+		svcCert:  svcCert,
+		roleCert: roleCert,
+		policy:   policy,
+		server:   srv,
+		handler:  h,
 	}, nil
 }
 
 // Start returns a error slice channel. This error channel contains the error returned by client sidecar daemon.
 func (t *clientd) Start(ctx context.Context) chan []error {
 	t.token.StartTokenUpdater(ctx)
+	roleDone := make(chan struct{})
 	go func() {
+		defer close(roleDone)
 		for err := range t.role.StartRoleUpdater(ctx) {
 			glg.Error(err)
 		}
 	}()
-	return t.server.ListenAndServe(ctx)
+	go func() {
+		for err := range t.access.StartAccessUpdater(ctx) {
+			glg.Error(err)
+		}
+	}()
+	if t.svcCert != nil {
+		go func() {
+			for err := range t.svcCert.StartSvcCertUpdater(ctx) {
+				glg.Error(err)
+			}
+		}()
+	}
+	if t.roleCert != nil {
+		go func() {
+			for err := range t.roleCert.StartRoleCertUpdater(ctx) {
+				glg.Error(err)
+			}
+		}()
+	}
+
+	ech := make(chan []error, 1)
+	sech := t.server.ListenAndServe(ctx)
+	go func() {
+		errs := <-sech
+		if ctx.Err() != nil {
+			// wait for the role token updater to write the cache snapshot on shutdown
+			<-roleDone
+		}
+		ech <- errs
+	}()
+	return ech
+}
+
+// Reload applies the reloadable part of cfg to the running client sidecar daemon, while the token caches are kept.
+// The TLS configuration of client sidecar server, the role token service settings and the proxy configuration are reloaded.
+// Other changes are ignored and require restart.
+func (t *clientd) Reload(cfg config.Config) error {
+	// all the components are validated before applying any of them, so that an invalid config does not leave a partially applied config
+	applyTLS, err := t.server.PrepareReloadTLS(cfg.Server.TLS)
+	if err != nil {
+		return errors.Wrap(err, "reload TLS config failed")
+	}
+	applyRole, err := t.role.PrepareReload(cfg.Role)
+	if err != nil {
+		return errors.Wrap(err, "reload role token config failed")
+	}
+	applyPolicy := func() {}
+	if t.policy != nil {
+		if applyPolicy, err = t.policy.PrepareReload(cfg.Policy); err != nil {
+			return errors.Wrap(err, "reload policy failed")
+		}
+	}
+
+	applyTLS()
+	applyRole()
+	applyPolicy()
+	t.handler.Reload(cfg.Proxy)
+
+	t.cfg = cfg
+	glg.Info("client sidecar config reloaded")
+	return nil
 }
 
 // createNtokend returns a TokenService object or any error
@@ -92,7 +92,12 @@ func TestNew(t *testing.T) {
 						panic(err)
 					}
 
-					serveMux := router.New(cfg.Server, handler.New(cfg.Proxy, infra.NewBuffer(cfg.Proxy.BufferSize), token.GetTokenProvider(), role.GetRoleProvider()))
+					access, err := service.NewAccessService(cfg.Access, token.GetTokenProvider())
+					if err != nil {
+						panic(err)
+					}
+
+					serveMux := router.New(cfg.Server, handler.New(cfg.Proxy, infra.NewBuffer(cfg.Proxy.BufferSize), token.GetTokenProvider(), role.GetRoleProvider(), access.GetAccessProvider(), nil, nil, nil))
 					server := service.NewServer(
 						service.WithServerConfig(cfg.Server),
 						service.WithServerHandler(serveMux),
@@ -103,6 +108,7 @@ func TestNew(t *testing.T) {
 						token:  token,
 						server: server,
 						role:   role,
+						access: access,
 					}
 				}(),
 			}
@@ -144,6 +150,7 @@ func Test_clientd_Start(t *testing.T) {
 		token  ntokend.TokenService
 		server service.Server
 		role   service.RoleService
+		access service.AccessService
 	}
 	type args struct {
 		ctx context.Context
@@ -203,7 +210,12 @@ func Test_clientd_Start(t *testing.T) {
 						panic(err)
 					}
 
-					serveMux := router.New(cfg.Server, handler.New(cfg.Proxy, infra.NewBuffer(cfg.Proxy.BufferSize), token.GetTokenProvider(), role.GetRoleProvider()))
+					access, err := service.NewAccessService(cfg.Access, token.GetTokenProvider())
+					if err != nil {
+						panic(err)
+					}
+
+					serveMux := router.New(cfg.Server, handler.New(cfg.Proxy, infra.NewBuffer(cfg.Proxy.BufferSize), token.GetTokenProvider(), role.GetRoleProvider(), access.GetAccessProvider(), nil, nil, nil))
 					server := service.NewServer(
 						service.WithServerConfig(cfg.Server),
 						service.WithServerHandler(serveMux),
@@ -214,6 +226,7 @@ func Test_clientd_Start(t *testing.T) {
 						token:  token,
 						server: server,
 						role:   role,
+						access: access,
 					}
 				}(),
 				args: args{
@@ -254,6 +267,7 @@ func Test_clientd_Start(t *testing.T) {
 				token:  tt.fields.token,
 				server: tt.fields.server,
 				role:   tt.fields.role,
+				access: tt.fields.access,
 			}
 			got := te.Start(tt.args.ctx)
 			if err := tt.checkFunc(got, tt.want); err != nil {
@@ -263,6 +277,141 @@ func Test_clientd_Start(t *testing.T) {
 	}
 }
 
+func Test_clientd_Reload(t *testing.T) {
+	type test struct {
+		name      string
+		cfg       config.Config
+		checkFunc func(*clientd) error
+		wantErr   string
+	}
+	tests := []test{
+		{
+			name: "Check Reload applies the config",
+			cfg: config.Config{
+				Role: config.Role{
+					RefreshInterval: "10m",
+				},
+				Proxy: config.Proxy{
+					PrincipalAuthHeaderName: "new-header",
+				},
+			},
+			checkFunc: func(c *clientd) error {
+				if c.cfg.Role.RefreshInterval != "10m" || c.cfg.Proxy.PrincipalAuthHeaderName != "new-header" {
+					return fmt.Errorf("config not reloaded, got: %+v", c.cfg)
+				}
+				return nil
+			},
+		},
+		{
+			name: "Check Reload return error on invalid role config",
+			cfg: config.Config{
+				Role: config.Role{
+					RefreshInterval: "dummy",
+				},
+			},
+			checkFunc: func(c *clientd) error {
+				if c.cfg.Role.RefreshInterval != "1h" {
+					return fmt.Errorf("config should not be reloaded, got: %+v", c.cfg)
+				}
+				return nil
+			},
+			wantErr: "reload role token config failed",
+		},
+		{
+			name: "Check Reload return error without applying any config on invalid policy",
+			cfg: config.Config{
+				Role: config.Role{
+					RefreshInterval: "10m",
+				},
+				Policy: config.Policy{
+					Path: "/not/exist/policy.yaml",
+				},
+			},
+			checkFunc: func(c *clientd) error {
+				if c.cfg.Role.RefreshInterval != "1h" {
+					return fmt.Errorf("config should not be reloaded, got: %+v", c.cfg)
+				}
+				if c.role.(*roleReloadRecorder).applied {
+					return fmt.Errorf("role token config should not be reloaded")
+				}
+				if err := c.policy.GetAuthorizer().AuthorizeNToken(&service.Client{}); err != nil {
+					return fmt.Errorf("policy should not be reloaded, got: %v", err)
+				}
+				return nil
+			},
+			wantErr: "reload policy failed",
+		},
+		{
+			name: "Check Reload return error when TLS is enabled",
+			cfg: config.Config{
+				Server: config.Server{
+					TLS: config.TLS{
+						Enabled: true,
+					},
+				},
+			},
+			checkFunc: func(c *clientd) error {
+				return nil
+			},
+			wantErr: "reload TLS config failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{
+				Role: config.Role{
+					RefreshInterval: "1h",
+				},
+			}
+			role, err := service.NewRoleService(cfg.Role, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy, err := service.NewPolicyService(cfg.Policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &clientd{
+				cfg:     cfg,
+				role:    &roleReloadRecorder{RoleService: role},
+				policy:  policy,
+				server:  service.NewServer(service.WithServerConfig(cfg.Server)),
+				handler: handler.New(cfg.Proxy, nil, nil, nil, nil, nil, nil, nil),
+			}
+
+			err = c.Reload(tt.cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Reload() error: %v", err)
+				return
+			}
+			if tt.wantErr != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.wantErr)) {
+				t.Errorf("Reload() error = %v, want %v", err, tt.wantErr)
+				return
+			}
+			if err := tt.checkFunc(c); err != nil {
+				t.Errorf("Reload() %v", err)
+			}
+		})
+	}
+}
+
+// roleReloadRecorder records whether the prepared role token config is applied.
+type roleReloadRecorder struct {
+	service.RoleService
+	applied bool
+}
+
+func (r *roleReloadRecorder) PrepareReload(cfg config.Role) (func(), error) {
+	apply, err := r.RoleService.PrepareReload(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		r.applied = true
+		apply()
+	}, nil
+}
+
 func Test_createNtokend(t *testing.T) {
 	type args struct {
 		cfg config.Token